@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	fffile "github.com/dkarlovi/fileferry/file"
+	"github.com/symfony-cli/console"
+)
+
+var cachePruneCmd = &console.Command{
+	Category:    "cache",
+	Name:        "prune",
+	Usage:       "Drop stale entries from the metadata cache",
+	Description: "Removes cached metadata of files that are gone or changed, and of files not seen by a run in a while",
+	Flags: []console.Flag{
+		&console.DurationFlag{Name: "older-than", DefaultValue: 30 * 24 * time.Hour, Usage: "Also drop entries no run has used for this long (0 keeps them)"},
+	},
+	Action: func(c *console.Context) error {
+		cache, err := openCache()
+		if err != nil {
+			return console.Exit(fmt.Sprintf("Failed to open the metadata cache: %v", err), 1)
+		}
+		dropped := cache.Prune(c.Duration("older-than"))
+		if err := cache.Save(); err != nil {
+			return console.Exit(err.Error(), 1)
+		}
+		fmt.Fprintf(c.App.Writer, "Pruned %d entries, %d left.\n", dropped, cache.Len())
+		return nil
+	},
+}
+
+// openCache opens the metadata cache at its default location.
+func openCache() (*fffile.MetadataCache, error) {
+	path, err := fffile.DefaultCachePath()
+	if err != nil {
+		return nil, err
+	}
+	return fffile.OpenMetadataCache(path)
+}
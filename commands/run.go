@@ -19,6 +19,7 @@ var runCmd = &console.Command{
 	},
 	Flags: []console.Flag{
 		&console.BoolFlag{Name: "ack", Usage: "Actually move files"},
+		&console.BoolFlag{Name: "no-cache", Usage: "Read every file's metadata again instead of using the metadata cache"},
 	},
 	Action: func(c *console.Context) error {
 		cfg, err := ffconfig.LoadConfigPrefer(c.String("config"))
@@ -38,12 +39,28 @@ var runCmd = &console.Command{
 		skipped := 0
 		moved := 0
 		deduped := 0
+		quarantined := 0
 		errors := 0
 
 		// detect verbose mode (-v)
 		verbose := terminal.IsVerbose()
 
-		filesCh, evCh, sources := fffile.FileIteratorWithEvents(cfg, profileName)
+		// Metadata read on earlier runs is reused for unchanged files. The
+		// cache is an optimization: when it can't be used, files are read.
+		var cache *fffile.MetadataCache
+		if !c.Bool("no-cache") {
+			if cache, err = openCache(); err != nil {
+				fmt.Fprintf(c.App.ErrWriter, "<fg=yellow>Warning: not using the metadata cache: %v</>\n", err)
+			} else {
+				defer func() {
+					if err := cache.Save(); err != nil {
+						fmt.Fprintf(c.App.ErrWriter, "<fg=yellow>Warning: %v</>\n", err)
+					}
+				}()
+			}
+		}
+
+		filesCh, evCh, sources := fffile.FileIteratorWithOptions(cfg, fffile.IteratorOptions{Profile: profileName, Cache: cache})
 		// Keep source sessions (e.g. an MTP device connection) alive until all
 		// moves are done; entries' Open/Delete rely on them.
 		defer sources.Close()
@@ -58,6 +75,8 @@ var runCmd = &console.Command{
 					fmt.Fprintf(c.App.Writer, "Found <warning>%d</> files in <comment>%s</>\n", ev.Found, ev.SrcPath)
 				case "error":
 					fmt.Fprintf(c.App.ErrWriter, "<fg=red>Error scanning %s: %v</>\n", ev.SrcPath, ev.Error)
+				case "warning":
+					fmt.Fprintf(c.App.ErrWriter, "<fg=yellow>Warning: %v</>\n", ev.Error)
 				}
 			}
 		}()
@@ -82,44 +101,119 @@ var runCmd = &console.Command{
 			}
 
 			if !file.ShouldOp {
+				// The file is already in place, but a companion may not
+				// be next to it yet.
+				for _, comp := range file.StrayCompanions() {
+					fmt.Fprintf(c.App.Writer, "<fg=yellow>%s is in place, its companion %s is not</>\n", file.OldPath, comp.OldPath)
+					if c.Bool("ack") {
+						res, err := fffile.MoveEntryWithPolicy(comp.Entry, comp.NewPath, file.Conflict)
+						if err != nil {
+							return console.Exit(fmt.Sprintf("%s: failed to move: %v", comp.OldPath, err), 1)
+						}
+						printCompanion(c, comp, res, "")
+					} else {
+						res, err := fffile.PreviewMoveWithPolicy(comp.Entry, comp.NewPath, file.Conflict)
+						if err != nil {
+							fmt.Fprintf(c.App.ErrWriter, "%s: %v\n", comp.OldPath, err)
+							errors++
+							continue
+						}
+						printCompanion(c, comp, res, "Would move ")
+					}
+				}
 				skipped++
 				continue
 			}
 
 			if c.Bool("ack") {
 				fmt.Fprintf(c.App.Writer, "Moving %s -> %s\n", file.OldPath, file.NewPath)
-				outcome, err := fffile.MoveEntry(file.Entry, file.NewPath)
+				results, err := fffile.MoveGroupWithPolicy(file.Entry, file.NewPath, file.Companions, file.Conflict)
 				if err != nil {
 					return console.Exit(fmt.Sprintf("%s: failed to move: %v", file.OldPath, err), 1)
 				}
-				if outcome == fffile.Deduplicated {
-					fmt.Fprintf(c.App.Writer, "<fg=yellow>Duplicate: %s already exists at %s, deleted source</>\n", file.OldPath, file.NewPath)
+				res := results[0]
+				switch res.Outcome {
+				case fffile.Deduplicated:
+					fmt.Fprintf(c.App.Writer, "<fg=yellow>Duplicate: %s already exists at %s, deleted source</>\n", file.OldPath, res.Path)
 					deduped++
-				} else {
+				case fffile.Skipped:
+					fmt.Fprintf(c.App.Writer, "<fg=yellow>Conflict: %s exists with different content, left %s in place (on_conflict=%s)</>\n", file.NewPath, file.OldPath, res.Conflict)
+					skipped++
+				case fffile.Replaced:
+					fmt.Fprintf(c.App.Writer, "<fg=yellow>Conflict: replaced older %s with %s (on_conflict=%s)</>\n", res.Path, file.OldPath, res.Conflict)
+					moved++
+				case fffile.Quarantined:
+					fmt.Fprintf(c.App.Writer, "<fg=yellow>Conflict: %s exists with different content, quarantined %s -> %s</>\n", file.NewPath, file.OldPath, res.Path)
+					quarantined++
+				default:
+					if res.Path != file.NewPath {
+						fmt.Fprintf(c.App.Writer, "<fg=yellow>Conflict: %s exists with different content, moved to %s instead (on_conflict=%s)</>\n", file.NewPath, res.Path, res.Conflict)
+					}
 					moved++
 				}
+				printCompanions(c, file, results, "")
 			} else {
-				outcome, err := fffile.PreviewMove(file.Entry, file.NewPath)
+				results, err := fffile.PreviewGroupWithPolicy(file.Entry, file.NewPath, file.Companions, file.Conflict)
 				if err != nil {
 					fmt.Fprintf(c.App.ErrWriter, "%s: %v\n", file.OldPath, err)
 					errors++
 					continue
 				}
-				if outcome == fffile.Deduplicated {
-					fmt.Fprintf(c.App.Writer, "<fg=yellow>Would skip duplicate: %s already exists at %s</>\n", file.OldPath, file.NewPath)
+				res := results[0]
+				switch res.Outcome {
+				case fffile.Deduplicated:
+					fmt.Fprintf(c.App.Writer, "<fg=yellow>Would skip duplicate: %s already exists at %s</>\n", file.OldPath, res.Path)
 					deduped++
-				} else {
-					fmt.Fprintf(c.App.Writer, "Would move %s -> %s (use --ack to actually move)\n", file.OldPath, file.NewPath)
+				case fffile.Skipped:
+					fmt.Fprintf(c.App.Writer, "<fg=yellow>Would skip %s: %s exists with different content (on_conflict=%s)</>\n", file.OldPath, file.NewPath, res.Conflict)
+					skipped++
+				case fffile.Replaced:
+					fmt.Fprintf(c.App.Writer, "<fg=yellow>Would replace older %s with %s (on_conflict=%s)</>\n", res.Path, file.OldPath, res.Conflict)
+					moved++
+				case fffile.Quarantined:
+					fmt.Fprintf(c.App.Writer, "<fg=yellow>Would quarantine %s -> %s: %s exists with different content (on_conflict=%s)</>\n", file.OldPath, res.Path, file.NewPath, res.Conflict)
+					quarantined++
+				default:
+					if res.Path != file.NewPath {
+						fmt.Fprintf(c.App.Writer, "<fg=yellow>Would move %s -> %s: %s exists with different content (on_conflict=%s)</>\n", file.OldPath, res.Path, file.NewPath, res.Conflict)
+					} else {
+						fmt.Fprintf(c.App.Writer, "Would move %s -> %s (use --ack to actually move)\n", file.OldPath, file.NewPath)
+					}
 					moved++
 				}
+				printCompanions(c, file, results, "Would move ")
 			}
 		}
 
-		fmt.Fprintf(c.App.Writer, "Summary: %d moved, %d duplicates, %d skipped, %d errors.\n", moved, deduped, skipped, errors)
+		fmt.Fprintf(c.App.Writer, "Summary: %d moved, %d duplicates, %d quarantined, %d skipped, %d errors.\n", moved, deduped, quarantined, skipped, errors)
+		if cache != nil && verbose {
+			hits, misses := cache.Stats()
+			fmt.Fprintf(c.App.Writer, "Metadata cache: %d reused, %d read.\n", hits, misses)
+		}
 		return nil
 	},
 }
 
+// printCompanions reports where a file's companions went (or would go, with
+// the "Would move " prefix). Companions left in place with a skipped file are
+// not listed.
+func printCompanions(c *console.Context, file fffile.File, results []fffile.MoveResult, prefix string) {
+	for i, comp := range file.Companions {
+		printCompanion(c, comp, results[i+1], prefix)
+	}
+}
+
+// printCompanion reports where one companion went (or would go).
+func printCompanion(c *console.Context, comp fffile.Companion, res fffile.MoveResult, prefix string) {
+	switch res.Outcome {
+	case fffile.Skipped:
+	case fffile.Deduplicated:
+		fmt.Fprintf(c.App.Writer, "  <fg=yellow>%scompanion %s: duplicate of %s</>\n", prefix, comp.OldPath, res.Path)
+	default:
+		fmt.Fprintf(c.App.Writer, "  %scompanion %s -> %s\n", prefix, comp.OldPath, res.Path)
+	}
+}
+
 func Commands() []*console.Command {
-	return []*console.Command{runCmd}
+	return []*console.Command{runCmd, cachePruneCmd}
 }
@@ -4,30 +4,149 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/dkarlovi/fileferry/mtp"
 	"gopkg.in/yaml.v3"
 )
 
 type SourceConfig struct {
+	// Name is an optional label for the source, exposed to target templates as
+	// {source.name}. It defaults to the last element of Path (see Label).
+	Name      string   `yaml:"name,omitempty"`
 	Path      string   `yaml:"path"`
 	Recurse   bool     `yaml:"recurse"`
 	Types     []string `yaml:"types"`
 	Filenames []string `yaml:"filenames,omitempty"`
+	// ContainerTime declares how the source's cameras fill container
+	// creation times that are defined as UTC (MP4 mvhd, Matroska DateUTC):
+	// "utc" (the default, as specified) or "local" for cameras that write
+	// their local wall clock there instead.
+	ContainerTime string `yaml:"container_time,omitempty"`
+}
+
+// Label returns the source's display name: Name when set, otherwise the last
+// element of Path (for an MTP URL, the last on-device folder).
+func (s SourceConfig) Label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	p := strings.TrimRight(s.Path, `/\`)
+	if i := strings.LastIndexAny(p, `/\`); i >= 0 {
+		return p[i+1:]
+	}
+	return p
 }
 
 type TargetPathConfig struct {
 	Path string `yaml:"path"`
+	// Sanitize selects the filesystem rules token values are cleaned with:
+	// "posix", "windows" (NTFS) or "fat" (FAT/exFAT). Empty means the rules
+	// of the platform FileFerry runs on.
+	Sanitize string `yaml:"sanitize,omitempty"`
+	// Normalize controls how separators in rendered paths are tidied up.
+	Normalize NormalizeConfig `yaml:"normalize,omitempty"`
+}
+
+// NormalizeConfig controls separator normalization of rendered target paths.
+// By default runs of "-" and "_" in the file name (without extension) are
+// collapsed and trimmed from its ends.
+type NormalizeConfig struct {
+	// Enabled turns normalization off when set to false. Empty path segments
+	// (from tokens that rendered empty) are collapsed regardless.
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// Separators are the characters to collapse and trim; default "-_".
+	Separators string `yaml:"separators,omitempty"`
+	// Directories applies normalization to directory names too.
+	Directories bool `yaml:"directories,omitempty"`
 }
 
+// SanitizeProfiles are the accepted values of TargetPathConfig.Sanitize.
+var SanitizeProfiles = []string{"posix", "windows", "fat"}
+
 type ProfileConfig struct {
 	Sources  []SourceConfig   `yaml:"sources"`
 	Patterns []string         `yaml:"patterns,omitempty"`
 	Target   TargetPathConfig `yaml:"target"`
+	// OnConflict decides what happens when a destination already holds a
+	// different file: "error" (the default), "skip", "suffix", "keep-newer"
+	// or "quarantine" (into ConflictsDir).
+	OnConflict   string `yaml:"on_conflict,omitempty"`
+	ConflictsDir string `yaml:"conflicts_dir,omitempty"`
+	// Timezone is the IANA zone name (e.g. "Europe/Zagreb", "UTC") taken
+	// times are rendered in when the file doesn't record its own offset.
+	// Empty means the timezone of the machine FileFerry runs on.
+	Timezone string `yaml:"timezone,omitempty"`
+	// TakenPrecedence lists, in order of preference, which recorded times
+	// may stand in for the taken time (see TakenTimeSources). Empty means
+	// all of them, in the order listed there.
+	TakenPrecedence []string `yaml:"taken_precedence,omitempty"`
+	// DateSources lists, in order of preference, where the taken time may
+	// come from (see DateSources, or an external extractor's name). Empty
+	// means the file's metadata, then its filename; file timestamps only
+	// when listed.
+	DateSources []string `yaml:"date_sources,omitempty"`
+	// XMP decides how XMP metadata (a .xmp sidecar or the packet embedded
+	// in the file) combines with the file's own metadata; see XMPModes.
+	// Empty means "fallback".
+	XMP string `yaml:"xmp,omitempty"`
+	// Companions are the extensions (e.g. ".xmp", ".jpg", ".thm", ".aae",
+	// ".lrv") of files that travel with a file sharing their name stem:
+	// they are moved to its destination stem, keeping their extension.
+	Companions []string `yaml:"companions,omitempty"`
 }
 
+// TakenTimeSources are the accepted values of ProfileConfig.TakenPrecedence,
+// in their default order: the original capture time, the digitized time and
+// the modification time written by editors.
+var TakenTimeSources = []string{"original", "digitized", "modified"}
+
+// DateSources are the accepted values of ProfileConfig.DateSources besides
+// external extractor names: image metadata (EXIF, XMP), video container
+// metadata, filename patterns, and the file's modification and creation
+// (birth) times.
+var DateSources = []string{"exif", "container", "filename", "mtime", "birthtime"}
+
+// XMPModes are the accepted values of ProfileConfig.XMP: XMP fills only
+// what the file lacks ("fallback"), overrides it ("prefer") or is ignored.
+var XMPModes = []string{"fallback", "prefer", "ignore"}
+
+// ConflictPolicies are the accepted values of ProfileConfig.OnConflict.
+var ConflictPolicies = []string{"error", "skip", "suffix", "keep-newer", "quarantine"}
+
+// ExtractorConfig registers an external program as a metadata extractor for
+// files with the given extensions. The program gets the file's path as its
+// last argument, or "-" with the content on stdin for files without one
+// (MTP), and prints the metadata as JSON.
+type ExtractorConfig struct {
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+	// Extensions are the extensions (e.g. ".tif") of the files it reads.
+	Extensions []string `yaml:"extensions"`
+	// Priority orders it among the extractors; nil means
+	// DefaultExtractorPriority, ahead of the built-in ones (0), so what it
+	// reports wins. Below 0 it only fills what they leave empty.
+	Priority *int `yaml:"priority,omitempty"`
+	// Timeout bounds one run; 0 means DefaultExtractorTimeout.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// Defaults for ExtractorConfig.
+const (
+	DefaultExtractorPriority = 10
+	DefaultExtractorTimeout  = 30 * time.Second
+)
+
+// ReservedExtractorNames are the provenance and date_sources names FileFerry
+// uses itself, which external extractors can't take.
+var ReservedExtractorNames = []string{"image", "video", "exiftool", "ffprobe", "filename", "xmp", "exif", "container", "mtime", "birthtime"}
+
 type Config struct {
 	Profiles map[string]ProfileConfig `yaml:"profiles"`
+	// Extractors are external metadata extractors, used by every profile.
+	Extractors []ExtractorConfig `yaml:"extractors,omitempty"`
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -41,6 +160,31 @@ func LoadConfig(path string) (*Config, error) {
 	if err := dec.Decode(&cfg); err != nil {
 		return nil, err
 	}
+	for i, ex := range cfg.Extractors {
+		if ex.Name == "" {
+			return nil, fmt.Errorf("extractor %d: missing name", i+1)
+		}
+		if slices.Contains(ReservedExtractorNames, ex.Name) {
+			return nil, fmt.Errorf("extractor %q: name is reserved (%v)", ex.Name, ReservedExtractorNames)
+		}
+		if slices.ContainsFunc(cfg.Extractors[:i], func(prev ExtractorConfig) bool { return prev.Name == ex.Name }) {
+			return nil, fmt.Errorf("extractor %q: duplicate name", ex.Name)
+		}
+		if len(ex.Command) == 0 || ex.Command[0] == "" {
+			return nil, fmt.Errorf("extractor %q: missing command", ex.Name)
+		}
+		if len(ex.Extensions) == 0 {
+			return nil, fmt.Errorf("extractor %q: missing extensions", ex.Name)
+		}
+		for _, ext := range ex.Extensions {
+			if !isExtension(ext) {
+				return nil, fmt.Errorf("extractor %q: invalid extensions entry %q (expected an extension like \".tif\")", ex.Name, ext)
+			}
+		}
+		if ex.Timeout < 0 {
+			return nil, fmt.Errorf("extractor %q: negative timeout %s", ex.Name, ex.Timeout)
+		}
+	}
 	// Guard against the same file being processed twice: a (path, type) pair must
 	// not appear in more than one profile. The same path with disjoint types
 	// (e.g. RAW images in one profile, videos in another) is allowed.
@@ -49,10 +193,52 @@ func LoadConfig(path string) (*Config, error) {
 		if prof.Target.Path == "" {
 			return nil, fmt.Errorf("profile %q: missing target.path", profName)
 		}
+		if prof.Target.Sanitize != "" && !slices.Contains(SanitizeProfiles, prof.Target.Sanitize) {
+			return nil, fmt.Errorf("profile %q: unknown target.sanitize %q (expected one of %v)", profName, prof.Target.Sanitize, SanitizeProfiles)
+		}
+		if prof.OnConflict != "" && !slices.Contains(ConflictPolicies, prof.OnConflict) {
+			return nil, fmt.Errorf("profile %q: unknown on_conflict %q (expected one of %v)", profName, prof.OnConflict, ConflictPolicies)
+		}
+		if prof.OnConflict == "quarantine" && prof.ConflictsDir == "" {
+			return nil, fmt.Errorf("profile %q: on_conflict quarantine requires conflicts_dir", profName)
+		}
+		if prof.XMP != "" && !slices.Contains(XMPModes, prof.XMP) {
+			return nil, fmt.Errorf("profile %q: unknown xmp mode %q (expected one of %v)", profName, prof.XMP, XMPModes)
+		}
+		for _, ext := range prof.Companions {
+			if !isExtension(ext) {
+				return nil, fmt.Errorf("profile %q: invalid companions entry %q (expected an extension like \".xmp\")", profName, ext)
+			}
+		}
+		for i, src := range prof.TakenPrecedence {
+			if !slices.Contains(TakenTimeSources, src) {
+				return nil, fmt.Errorf("profile %q: unknown taken_precedence entry %q (expected one of %v)", profName, src, TakenTimeSources)
+			}
+			if slices.Contains(prof.TakenPrecedence[:i], src) {
+				return nil, fmt.Errorf("profile %q: duplicate taken_precedence entry %q", profName, src)
+			}
+		}
+		for i, src := range prof.DateSources {
+			isExtractor := slices.ContainsFunc(cfg.Extractors, func(ec ExtractorConfig) bool { return ec.Name == src })
+			if !slices.Contains(DateSources, src) && !isExtractor {
+				return nil, fmt.Errorf("profile %q: unknown date_sources entry %q (expected one of %v or an extractor name)", profName, src, DateSources)
+			}
+			if slices.Contains(prof.DateSources[:i], src) {
+				return nil, fmt.Errorf("profile %q: duplicate date_sources entry %q", profName, src)
+			}
+		}
+		if prof.Timezone != "" {
+			if _, err := time.LoadLocation(prof.Timezone); err != nil {
+				return nil, fmt.Errorf("profile %q: invalid timezone %q: %w", profName, prof.Timezone, err)
+			}
+		}
 		for _, src := range prof.Sources {
 			if src.Path == "" {
 				return nil, fmt.Errorf("profile %q: source path is empty", profName)
 			}
+			if src.ContainerTime != "" && src.ContainerTime != "utc" && src.ContainerTime != "local" {
+				return nil, fmt.Errorf("profile %q: source %q: unknown container_time %q (expected utc or local)", profName, src.Path, src.ContainerTime)
+			}
 			// Validate MTP device URLs up front for a clear error before scanning.
 			if mtp.IsURL(src.Path) {
 				if _, _, err := mtp.ParseURL(src.Path); err != nil {
@@ -78,6 +264,11 @@ func LoadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// isExtension reports whether s is a single file extension such as ".xmp".
+func isExtension(s string) bool {
+	return len(s) >= 2 && strings.HasPrefix(s, ".") && !strings.ContainsAny(s[1:], `./\`)
+}
+
 // LoadConfigPrefer tries to load a config file using the following order:
 //  1. the provided path if non-empty,
 //  2. ./config.yaml (current working directory),
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig_Valid(t *testing.T) {
@@ -143,6 +144,335 @@ func TestLoadConfig_MissingTargetPath(t *testing.T) {
 	}
 }
 
+func TestLoadConfig_Sanitize(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	yamlSanitize := `profiles:
+  Pictures:
+    sources:
+      - path: /path/to/pictures
+        types: [image]
+    target:
+      path: /organized/{meta.camera.model}/{file.name}
+      sanitize: fat
+`
+
+	if err := os.WriteFile(configPath, []byte(yamlSanitize), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() unexpected error: %v", err)
+	}
+	if got := cfg.Profiles["Pictures"].Target.Sanitize; got != "fat" {
+		t.Errorf("Expected target.sanitize 'fat', got %q", got)
+	}
+
+	yamlUnknown := strings.Replace(yamlSanitize, "sanitize: fat", "sanitize: hfs", 1)
+	if err := os.WriteFile(configPath, []byte(yamlUnknown), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+	_, err = LoadConfig(configPath)
+	if err == nil {
+		t.Fatal("LoadConfig() expected error for unknown target.sanitize, got nil")
+	}
+	if !strings.Contains(err.Error(), "unknown target.sanitize") {
+		t.Errorf("Expected error about unknown target.sanitize, got: %v", err)
+	}
+}
+
+func TestLoadConfig_Normalize(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	yamlNormalize := `profiles:
+  Pictures:
+    sources:
+      - path: /path/to/pictures
+        types: [image]
+    target:
+      path: /organized/{file.stem}.{file.extension}
+      normalize:
+        enabled: false
+        separators: "-"
+        directories: true
+`
+
+	if err := os.WriteFile(configPath, []byte(yamlNormalize), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() unexpected error: %v", err)
+	}
+	norm := cfg.Profiles["Pictures"].Target.Normalize
+	if norm.Enabled == nil || *norm.Enabled {
+		t.Errorf("Expected normalize.enabled false, got %v", norm.Enabled)
+	}
+	if norm.Separators != "-" || !norm.Directories {
+		t.Errorf("Unexpected normalize config: %+v", norm)
+	}
+}
+
+func TestLoadConfig_OnConflict(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	base := `profiles:
+  Pictures:
+    sources:
+      - path: /path/to/pictures
+        types: [image]
+    target:
+      path: /organized/{meta.taken.datetime}.{file.extension}
+`
+
+	tests := []struct {
+		name    string
+		extra   string
+		wantErr string
+	}{
+		{name: "suffix", extra: "    on_conflict: suffix\n"},
+		{name: "quarantine with dir", extra: "    on_conflict: quarantine\n    conflicts_dir: /organized/conflicts\n"},
+		{name: "unknown policy", extra: "    on_conflict: overwrite\n", wantErr: "unknown on_conflict"},
+		{name: "quarantine without dir", extra: "    on_conflict: quarantine\n", wantErr: "requires conflicts_dir"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(configPath, []byte(base+tt.extra), 0644); err != nil {
+				t.Fatalf("Failed to create test config: %v", err)
+			}
+			cfg, err := LoadConfig(configPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v; want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() unexpected error: %v", err)
+			}
+			if cfg.Profiles["Pictures"].OnConflict == "" {
+				t.Error("Expected on_conflict to be loaded")
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Timezone(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	tests := []struct {
+		name          string
+		timezone      string
+		containerTime string
+		wantErr       string
+	}{
+		{name: "zone and local container time", timezone: "Europe/Zagreb", containerTime: "local"},
+		{name: "UTC", timezone: "UTC", containerTime: "utc"},
+		{name: "unknown zone", timezone: "Mars/Olympus", wantErr: "invalid timezone"},
+		{name: "unknown container_time", containerTime: "gmt", wantErr: "unknown container_time"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := "profiles:\n  Videos:\n    sources:\n      - path: /path/to/videos\n        types: [video]\n"
+			if tt.containerTime != "" {
+				yaml += "        container_time: " + tt.containerTime + "\n"
+			}
+			yaml += "    target:\n      path: /organized/{meta.taken.year}/{file.name}\n"
+			if tt.timezone != "" {
+				yaml += "    timezone: " + tt.timezone + "\n"
+			}
+			if err := os.WriteFile(configPath, []byte(yaml), 0644); err != nil {
+				t.Fatalf("Failed to create test config: %v", err)
+			}
+			cfg, err := LoadConfig(configPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v; want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() unexpected error: %v", err)
+			}
+			prof := cfg.Profiles["Videos"]
+			if prof.Timezone != tt.timezone || prof.Sources[0].ContainerTime != tt.containerTime {
+				t.Errorf("loaded timezone %q, container_time %q", prof.Timezone, prof.Sources[0].ContainerTime)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_TakenPrecedence(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	base := `profiles:
+  Pictures:
+    sources:
+      - path: /path/to/pictures
+        types: [image]
+    target:
+      path: /organized/{meta.taken.datetime}.{file.extension}
+`
+
+	tests := []struct {
+		name    string
+		extra   string
+		wantErr string
+	}{
+		{name: "valid order", extra: "    taken_precedence: [digitized, original]\n"},
+		{name: "unknown entry", extra: "    taken_precedence: [original, created]\n", wantErr: "unknown taken_precedence entry"},
+		{name: "duplicate entry", extra: "    taken_precedence: [original, original]\n", wantErr: "duplicate taken_precedence entry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(configPath, []byte(base+tt.extra), 0644); err != nil {
+				t.Fatalf("Failed to create test config: %v", err)
+			}
+			cfg, err := LoadConfig(configPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v; want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() unexpected error: %v", err)
+			}
+			if got := cfg.Profiles["Pictures"].TakenPrecedence; len(got) != 2 || got[0] != "digitized" {
+				t.Errorf("TakenPrecedence = %v; want [digitized original]", got)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_DateSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	base := `extractors:
+  - name: scanner
+    command: [scanmeta]
+    extensions: [.tif]
+profiles:
+  Pictures:
+    sources:
+      - path: /path/to/pictures
+        types: [image]
+    target:
+      path: /organized/{meta.taken.datetime}.{file.extension}
+`
+
+	tests := []struct {
+		name    string
+		extra   string
+		wantErr string
+	}{
+		{name: "valid order", extra: "    date_sources: [exif, filename, mtime, birthtime]\n"},
+		{name: "extractor name", extra: "    date_sources: [scanner, exif]\n"},
+		{name: "unknown entry", extra: "    date_sources: [exif, ctime]\n", wantErr: "unknown date_sources entry"},
+		{name: "duplicate entry", extra: "    date_sources: [mtime, mtime]\n", wantErr: "duplicate date_sources entry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(configPath, []byte(base+tt.extra), 0644); err != nil {
+				t.Fatalf("Failed to create test config: %v", err)
+			}
+			cfg, err := LoadConfig(configPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v; want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() unexpected error: %v", err)
+			}
+			if got := cfg.Profiles["Pictures"].DateSources; len(got) < 2 {
+				t.Errorf("DateSources = %v; want the configured list", got)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Companions(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	write := func(companions string) {
+		t.Helper()
+		content := `profiles:
+  Pictures:
+    sources:
+      - path: /path/to/pictures
+        types: [image.raw]
+    companions: ` + companions + `
+    target:
+      path: /organized/{file.name}
+`
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test config: %v", err)
+		}
+	}
+
+	write("[.xmp, .JPG, .lrv]")
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() unexpected error: %v", err)
+	}
+	if got := cfg.Profiles["Pictures"].Companions; len(got) != 3 || got[1] != ".JPG" {
+		t.Errorf("Companions = %v; want [.xmp .JPG .lrv]", got)
+	}
+
+	for _, bad := range []string{"[xmp]", "[.]", "[.cr3.xmp]"} {
+		write(bad)
+		if _, err := LoadConfig(configPath); err == nil || !strings.Contains(err.Error(), "invalid companions entry") {
+			t.Errorf("companions %s: error = %v; want invalid companions entry", bad, err)
+		}
+	}
+}
+
+func TestLoadConfig_XMP(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	base := `profiles:
+  Pictures:
+    sources:
+      - path: /path/to/pictures
+        types: [image]
+    target:
+      path: /organized/{meta.rating}/{file.name}
+`
+	if err := os.WriteFile(configPath, []byte(base+"    xmp: prefer\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() unexpected error: %v", err)
+	}
+	if got := cfg.Profiles["Pictures"].XMP; got != "prefer" {
+		t.Errorf("XMP = %q; want prefer", got)
+	}
+
+	if err := os.WriteFile(configPath, []byte(base+"    xmp: always\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+	if _, err := LoadConfig(configPath); err == nil || !strings.Contains(err.Error(), "unknown xmp mode") {
+		t.Errorf("LoadConfig() error = %v; want unknown xmp mode", err)
+	}
+}
+
 func TestLoadConfig_EmptySourcePath(t *testing.T) {
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "config.yaml")
@@ -315,6 +645,28 @@ func TestLoadConfig_OptionalFields(t *testing.T) {
 	}
 }
 
+func TestSourceConfig_Label(t *testing.T) {
+	tests := []struct {
+		name string
+		src  SourceConfig
+		want string
+	}{
+		{name: "explicit name", src: SourceConfig{Name: "Phone", Path: "/path/to/pictures"}, want: "Phone"},
+		{name: "last path element", src: SourceConfig{Path: "/path/to/pictures"}, want: "pictures"},
+		{name: "trailing slash", src: SourceConfig{Path: "/path/to/pictures/"}, want: "pictures"},
+		{name: "mtp url", src: SourceConfig{Path: "mtp://Pixel 9 Pro/Internal shared storage/DCIM/Camera"}, want: "Camera"},
+		{name: "windows path", src: SourceConfig{Path: `D:\Photos\Inbox`}, want: "Inbox"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.src.Label(); got != tt.want {
+				t.Errorf("Label() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestLoadConfigPrefer_PreferredPath(t *testing.T) {
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "my-config.yaml")
@@ -514,3 +866,57 @@ func TestLoadConfigPrefer_InvalidPreferredFallsBackToCurrent(t *testing.T) {
 		t.Error("Expected to fall back to Current profile from current directory")
 	}
 }
+
+func TestLoadConfig_Extractors(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	write := func(extractors string) {
+		t.Helper()
+		content := `profiles:
+  Scans:
+    sources:
+      - path: /path/to/scans
+        types: [.tif]
+    target:
+      path: /organized/{meta.custom.batch}/{file.name}
+extractors:
+` + extractors
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test config: %v", err)
+		}
+	}
+
+	write(`  - name: scanner
+    command: [/usr/local/bin/scanmeta, --json]
+    extensions: [.tif]
+    priority: -5
+    timeout: 5s
+`)
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() unexpected error: %v", err)
+	}
+	if len(cfg.Extractors) != 1 {
+		t.Fatalf("Extractors = %+v; want one", cfg.Extractors)
+	}
+	ex := cfg.Extractors[0]
+	if ex.Name != "scanner" || len(ex.Command) != 2 || ex.Priority == nil || *ex.Priority != -5 || ex.Timeout != 5*time.Second {
+		t.Errorf("extractor = %+v; want scanner, 2-part command, priority -5, timeout 5s", ex)
+	}
+
+	tests := map[string]string{
+		"  - command: [scanmeta]\n    extensions: [.tif]\n":                                                                     "missing name",
+		"  - name: exiftool\n    command: [scanmeta]\n    extensions: [.tif]\n":                                                 "reserved",
+		"  - name: a\n    extensions: [.tif]\n":                                                                                 "missing command",
+		"  - name: a\n    command: [scanmeta]\n":                                                                                "missing extensions",
+		"  - name: a\n    command: [scanmeta]\n    extensions: [tif]\n":                                                         "invalid extensions entry",
+		"  - name: a\n    command: [scanmeta]\n    extensions: [.tif]\n  - name: a\n    command: [x]\n    extensions: [.jpg]\n": "duplicate name",
+	}
+	for extractors, want := range tests {
+		write(extractors)
+		if _, err := LoadConfig(configPath); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("extractors %q: error = %v; want %q", extractors, err, want)
+		}
+	}
+}
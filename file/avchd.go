@@ -0,0 +1,177 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// AVCHD camcorders (.MTS, .M2TS) write MPEG transport streams of H.264
+// video. The recording date isn't in the transport stream but in the video:
+// every GOP starts with an SEI message holding a "MDPM" (modified digital
+// video pack metadata) block, tagged with a Sony-assigned UUID. The first one
+// is a few packets into the file, so the stream is read only until it is
+// found.
+
+// mdpmUUID and "MDPM" start the SEI user data holding the AVCHD metadata.
+var mdpmUUID = []byte{0x17, 0xee, 0x8c, 0x60, 0xf8, 0x4d, 0x11, 0xd9, 0x8c, 0xd6, 0x08, 0x00, 0x20, 0x0c, 0x9a, 0x66, 'M', 'D', 'P', 'M'}
+
+// maxAVCHDScan bounds how much of a transport stream is read looking for the
+// MDPM block.
+const maxAVCHDScan = 4 << 20
+
+// maxPESHead bounds how much of each PES packet is searched: the SEI
+// messages lead the access unit, right after its parameter sets.
+const maxPESHead = 4 << 10
+
+// MDPM tags read by readAVCHDMetadata. Each tag carries 4 bytes.
+const (
+	mdpmDate = 0x18 // time zone, then year and month in BCD
+	mdpmTime = 0x19 // day, hour, minute and second in BCD
+	mdpmMake = 0xe0 // maker code, 2 bytes
+)
+
+// mdpmMakers maps the maker codes of the MDPM make tag to names.
+var mdpmMakers = map[uint16]string{
+	0x0103: "Panasonic",
+	0x0108: "Sony",
+	0x1011: "Canon",
+	0x1104: "JVC",
+}
+
+// readAVCHDMetadata fills meta from the MDPM block in an AVCHD transport
+// stream: the recording time and the camera maker.
+func readAVCHDMetadata(r io.Reader, meta *FileMetadata) {
+	tags, ok := findMDPM(io.LimitReader(r, maxAVCHDScan))
+	if !ok {
+		return
+	}
+	if tm, basis := mdpmDateTime(tags[mdpmDate], tags[mdpmTime]); tm != nil {
+		meta.TakenTime, meta.TakenBasis = tm, basis
+	}
+	if m, ok := tags[mdpmMake]; ok && meta.CameraMaker == "" {
+		meta.CameraMaker = mdpmMakers[binary.BigEndian.Uint16(m[:2])]
+	}
+}
+
+// findMDPM demultiplexes a transport stream (188-byte packets, or 192 with
+// the M2TS timestamp prefix) until it finds an MDPM block, and returns its
+// tags. The head of each PID's current PES packet is collected, so a block
+// split over TS packets is still found.
+func findMDPM(r io.Reader) (map[byte][4]byte, bool) {
+	var first [192]byte
+	if _, err := io.ReadFull(r, first[:]); err != nil {
+		return nil, false
+	}
+	var packetSize, prefix int
+	switch {
+	case first[0] == 0x47 && first[188] == 0x47:
+		packetSize = 188
+	case first[4] == 0x47:
+		packetSize, prefix = 192, 4
+	default:
+		return nil, false
+	}
+	r = io.MultiReader(bytes.NewReader(first[:]), r)
+
+	payloads := make(map[uint16][]byte)
+	pkt := make([]byte, packetSize)
+	for {
+		if _, err := io.ReadFull(r, pkt); err != nil {
+			return nil, false
+		}
+		p := pkt[prefix:]
+		if p[0] != 0x47 {
+			return nil, false
+		}
+		pid := binary.BigEndian.Uint16(p[1:3]) & 0x1fff
+		start := p[1]&0x40 != 0
+		adaptation := p[3] >> 4 & 0x3
+		if pid == 0 || pid == 0x1fff || adaptation&0x1 == 0 {
+			continue
+		}
+		body := p[4:]
+		if adaptation&0x2 != 0 {
+			if int(body[0])+1 > len(body) {
+				continue
+			}
+			body = body[1+int(body[0]):]
+		}
+		if start {
+			payloads[pid] = payloads[pid][:0]
+		} else if len(payloads[pid]) >= maxPESHead {
+			continue
+		}
+		payloads[pid] = append(payloads[pid], body...)
+		if tags, ok := parseMDPM(payloads[pid]); ok {
+			return tags, true
+		}
+	}
+}
+
+// parseMDPM looks for an MDPM block in b and returns its tags, or false if b
+// doesn't hold a complete one (yet).
+func parseMDPM(b []byte) (map[byte][4]byte, bool) {
+	i := bytes.Index(b, mdpmUUID)
+	if i < 0 {
+		return nil, false
+	}
+	b = unescapeNAL(b[i+len(mdpmUUID):])
+	if len(b) < 1 || len(b) < 1+int(b[0])*5 {
+		return nil, false
+	}
+	tags := make(map[byte][4]byte, b[0])
+	for j := range int(b[0]) {
+		e := b[1+j*5:]
+		tags[e[0]] = [4]byte(e[1:5])
+	}
+	return tags, true
+}
+
+// unescapeNAL removes the emulation prevention bytes H.264 inserts into NAL
+// units (00 00 03 → 00 00).
+func unescapeNAL(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// mdpmDateTime decodes the MDPM date and time tags. The first byte of the
+// date tag is the camera's time zone: bit 0x20 the sign, bits 0x1e the hours,
+// bit 0x01 an extra half hour and bit 0x40 daylight saving time (an hour more
+// than the zone's offset). Cameras that record no zone (0xff) give floating
+// times.
+func mdpmDateTime(date, clock [4]byte) (*time.Time, TimeBasis) {
+	s := fmt.Sprintf("%x%x", date[1:], clock[:])
+	tm, err := time.Parse("20060102150405", s)
+	if err != nil {
+		return nil, TimeFloating
+	}
+	tz := date[0]
+	if tz == 0xff {
+		return &tm, TimeFloating
+	}
+	offset := int(tz>>1&0x0f)*3600 + int(tz&0x01)*1800
+	if tz&0x20 != 0 {
+		offset = -offset
+	}
+	if tz&0x40 != 0 {
+		offset += 3600
+	}
+	tm = wallClockIn(tm, time.FixedZone("", offset))
+	return &tm, TimeZoned
+}
@@ -0,0 +1,133 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// mdpmSEI returns an H.264 SEI NAL unit holding an MDPM block with the given
+// tags, with emulation prevention bytes inserted as an encoder would.
+func mdpmSEI(tags [][5]byte) []byte {
+	block := append(append([]byte(nil), mdpmUUID...), byte(len(tags)))
+	for _, tag := range tags {
+		block = append(block, tag[:]...)
+	}
+	raw := append([]byte{0x06, 0x05, byte(len(block))}, block...)
+	raw = append(raw, 0x80)
+	var nal []byte
+	zeros := 0
+	for _, c := range raw {
+		if zeros >= 2 && c <= 3 {
+			nal = append(nal, 3)
+			zeros = 0
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		nal = append(nal, c)
+	}
+	return append([]byte{0, 0, 0, 1}, nal...)
+}
+
+// buildTS returns a transport stream with a PAT packet and a video PES
+// carrying payload, split over as many packets as it takes. With m2ts every
+// packet gets the 4-byte timestamp prefix.
+func buildTS(payload []byte, m2ts bool) []byte {
+	var out []byte
+	packet := func(pid uint16, start bool, body []byte) {
+		p := make([]byte, 188)
+		for i := range p {
+			p[i] = 0xff
+		}
+		p[0] = 0x47
+		binary.BigEndian.PutUint16(p[1:], pid)
+		if start {
+			p[1] |= 0x40
+		}
+		if len(body) < 184 {
+			// Stuff the rest with an adaptation field.
+			p[3] = 0x30
+			p[4] = byte(183 - len(body))
+			if p[4] > 0 {
+				p[5] = 0
+			}
+			copy(p[188-len(body):], body)
+		} else {
+			p[3] = 0x10
+			copy(p[4:], body)
+		}
+		if m2ts {
+			out = append(out, 0, 0, 0, 0)
+		}
+		out = append(out, p...)
+	}
+	packet(0, true, []byte{0, 0, 0xb0, 0x0d, 0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0, 0, 0, 0, 0})
+	pes := append([]byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0, 0}, payload...)
+	for start := true; len(pes) > 0; start = false {
+		n := min(len(pes), 184)
+		packet(0x1011, start, pes[:n])
+		pes = pes[n:]
+	}
+	return out
+}
+
+func TestReadAVCHDMetadata(t *testing.T) {
+	tags := [][5]byte{
+		{mdpmDate, 0x02, 0x20, 0x24, 0x06}, // +01:00, 2024-06
+		{mdpmTime, 0x01, 0x14, 0x30, 0x05}, // 1st, 14:30:05
+		{0x70, 0x00, 0x00, 0x01, 0x00},     // needs an emulation prevention byte
+		{mdpmMake, 0x01, 0x08, 0x00, 0x00}, // Sony
+	}
+	// The access unit delimiter, SPS and PPS lead; the SEI straddles two TS
+	// packets.
+	au := append([]byte{0, 0, 0, 1, 0x09, 0xf0}, bytes.Repeat([]byte{0xaa}, 150)...)
+	au = append(au, mdpmSEI(tags)...)
+	au = append(au, bytes.Repeat([]byte{0xbb}, 1000)...)
+
+	for _, m2ts := range []bool{false, true} {
+		meta := &FileMetadata{}
+		readAVCHDMetadata(bytes.NewReader(buildTS(au, m2ts)), meta)
+		want := time.Date(2024, 6, 1, 14, 30, 5, 0, time.FixedZone("", 3600))
+		if meta.TakenTime == nil || !meta.TakenTime.Equal(want) || meta.TakenBasis != TimeZoned || meta.TakenTime.Format("-0700") != "+0100" {
+			t.Errorf("m2ts=%v: TakenTime = %v (%v); want %v, zoned", m2ts, meta.TakenTime, meta.TakenBasis, want)
+		}
+		if meta.CameraMaker != "Sony" {
+			t.Errorf("m2ts=%v: CameraMaker = %q; want Sony", m2ts, meta.CameraMaker)
+		}
+	}
+
+	meta := &FileMetadata{}
+	readAVCHDMetadata(bytes.NewReader(buildTS(bytes.Repeat([]byte{0xaa}, 1000), false)), meta)
+	if meta.TakenTime != nil {
+		t.Errorf("without MDPM: TakenTime = %v; want none", meta.TakenTime)
+	}
+}
+
+func TestMDPMDateTime(t *testing.T) {
+	clock := [4]byte{0x01, 0x14, 0x30, 0x05}
+	tests := []struct {
+		tz        byte
+		wantBasis TimeBasis
+		wantZone  string
+	}{
+		{0x02, TimeZoned, "+0100"},
+		{0x42, TimeZoned, "+0200"}, // DST
+		{0x2a, TimeZoned, "-0500"},
+		{0x6a, TimeZoned, "-0400"}, // DST
+		{0x0b, TimeZoned, "+0530"},
+		{0xff, TimeFloating, "+0000"},
+	}
+	for _, tt := range tests {
+		tm, basis := mdpmDateTime([4]byte{tt.tz, 0x20, 0x24, 0x06}, clock)
+		if tm == nil || basis != tt.wantBasis || tm.Format("-0700") != tt.wantZone || tm.Format("2006-01-02 15:04:05") != "2024-06-01 14:30:05" {
+			t.Errorf("tz %#x: %v (%v); want 2024-06-01 14:30:05 %s (%v)", tt.tz, tm, basis, tt.wantZone, tt.wantBasis)
+		}
+	}
+	if tm, _ := mdpmDateTime([4]byte{0x02, 0x20, 0x24, 0x13}, clock); tm != nil {
+		t.Errorf("invalid month: %v; want nil", tm)
+	}
+}
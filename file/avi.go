@@ -0,0 +1,134 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// AVI is RIFF: a tree of chunks whose LIST chunks hold more chunks. Point-
+// and-shoot cameras record the capture time as text in an IDIT chunk of the
+// hdrl list, and some (Fujifilm, Pentax, Nikon) add EXIF in the video
+// stream's strd chunk. Both precede the movi list with the media data, where
+// reading stops, so AVI is read as a stream.
+
+// maxAVIChunk bounds the chunks read into memory; the ones read here are
+// short.
+const maxAVIChunk = 1 << 20
+
+// readAVIMetadata fills meta from an AVI file's header lists: the taken time
+// (EXIF in strd, else IDIT, both camera wall clock), camera make and model
+// (EXIF) and the software (the INFO list's ISFT).
+func readAVIMetadata(r io.Reader, meta *FileMetadata) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || string(hdr[:4]) != "RIFF" || string(hdr[8:]) != "AVI " {
+		return
+	}
+	var idit string
+	var x exifTags
+	for {
+		var ch [8]byte
+		if _, err := io.ReadFull(r, ch[:]); err != nil {
+			break
+		}
+		id := string(ch[:4])
+		size := int64(binary.LittleEndian.Uint32(ch[4:]))
+		if id == "LIST" {
+			// Step into the list: its chunks follow its type.
+			var typ [4]byte
+			if _, err := io.ReadFull(r, typ[:]); err != nil || string(typ[:]) == "movi" {
+				break
+			}
+			continue
+		}
+		padded := size + size&1
+		if (id != "IDIT" && id != "strd" && id != "ISFT") || size > maxAVIChunk {
+			if _, err := io.CopyN(io.Discard, r, padded); err != nil {
+				break
+			}
+			continue
+		}
+		data := make([]byte, padded)
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+		data = data[:size]
+		switch id {
+		case "IDIT":
+			idit = riffString(data)
+		case "strd":
+			if x == nil {
+				x = aviStrdExif(data)
+			}
+		case "ISFT":
+			if meta.Software == "" {
+				meta.Software = riffString(data)
+			}
+		}
+	}
+
+	if x != nil {
+		meta.TakenTime, meta.TakenBasis = exifTime(x, exifOriginal)
+		if meta.CameraMaker == "" {
+			meta.CameraMaker, _ = exifString(x, exif.Make)
+		}
+		if meta.CameraModel == "" {
+			meta.CameraModel, _ = exifString(x, exif.Model)
+		}
+	}
+	if meta.TakenTime == nil {
+		if tm := parseIDIT(idit); tm != nil {
+			meta.TakenTime, meta.TakenBasis = tm, TimeFloating
+		}
+	}
+}
+
+// riffString decodes a RIFF text chunk, which is NUL-terminated and often
+// padded with newlines.
+func riffString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// aviStrdExif decodes the EXIF some cameras store in a stream's strd chunk:
+// an "AVIF" tag and 4 more bytes, then a little-endian IFD0 at offset 8 whose
+// offsets count from the chunk's start. Replacing those 8 bytes with a TIFF
+// header makes it a TIFF exif.Decode reads.
+func aviStrdExif(b []byte) exifTags {
+	if len(b) < 8 || string(b[:4]) != "AVIF" {
+		return nil
+	}
+	tiff := append([]byte("II*\x00\x08\x00\x00\x00"), b[8:]...)
+	return decodeExif(bytes.NewReader(tiff))
+}
+
+// iditLayouts are the IDIT date formats cameras write: the C asctime form
+// most use ("SUN SEP 05 13:38:36 2004") and EXIF-like ones.
+var iditLayouts = []string{
+	"Mon Jan 2 15:04:05 2006",
+	"2006:01:02 15:04:05",
+	"2006/01/02 15:04:05",
+	"2006/01/02/ 15:04",
+	"2006-01-02 15:04:05",
+}
+
+// parseIDIT parses an IDIT chunk's date, a camera wall-clock reading, or
+// returns nil if it isn't one. Month and day names match in any case.
+func parseIDIT(s string) *time.Time {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return nil
+	}
+	for _, layout := range iditLayouts {
+		if tm, err := time.Parse(layout, s); err == nil {
+			return &tm
+		}
+	}
+	return nil
+}
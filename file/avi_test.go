@@ -0,0 +1,92 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// riffChunk returns a RIFF chunk, padded to an even length.
+func riffChunk(id string, data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	b := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func riffList(typ string, chunks ...[]byte) []byte {
+	return riffChunk("LIST", append([][]byte{[]byte(typ)}, chunks...)...)
+}
+
+// buildAVI returns an AVI whose hdrl holds the given IDIT date and, if strd
+// is set, a video stream with that strd chunk, followed by size bytes of
+// media data.
+func buildAVI(idit string, strd []byte, size int) []byte {
+	strl := [][]byte{riffChunk("strh", make([]byte, 56)), riffChunk("strf", make([]byte, 40))}
+	if strd != nil {
+		strl = append(strl, riffChunk("strd", strd))
+	}
+	hdrl := riffList("hdrl", riffChunk("avih", make([]byte, 56)), riffList("strl", strl...), riffChunk("IDIT", []byte(idit+"\n\x00")))
+	info := riffList("INFO", riffChunk("ISFT", []byte("CanonMVI06\x00")))
+	movi := riffList("movi", riffChunk("00dc", make([]byte, size)))
+	return riffChunk("RIFF", []byte("AVI "), hdrl, info, movi)
+}
+
+func TestReadAVIMetadata(t *testing.T) {
+	t.Run("IDIT", func(t *testing.T) {
+		file := buildAVI("SUN SEP 05 13:38:36 2004", nil, 1<<16)
+		r := &countingReader{r: bytes.NewReader(file)}
+		meta := &FileMetadata{}
+		readAVIMetadata(r, meta)
+		if want := time.Date(2004, 9, 5, 13, 38, 36, 0, time.UTC); meta.TakenTime == nil || !meta.TakenTime.Equal(want) || meta.TakenBasis != TimeFloating {
+			t.Errorf("TakenTime = %v (%v); want %v, floating", meta.TakenTime, meta.TakenBasis, want)
+		}
+		if meta.Software != "CanonMVI06" {
+			t.Errorf("Software = %q; want CanonMVI06", meta.Software)
+		}
+		if r.n > int64(len(file)-1<<16) {
+			t.Errorf("read %d of %d bytes; want reading to stop at the movi list", r.n, len(file))
+		}
+	})
+
+	t.Run("strd EXIF", func(t *testing.T) {
+		ifd0, exifIFD := rawFixtureTIFFs("FUJIFILM", "FinePix F31fd")
+		tiff := buildTIFF(ifd0, exifIFD)
+		strd := append([]byte("AVIF\x00\x00\x00\x00"), tiff[8:]...)
+		meta := &FileMetadata{}
+		readAVIMetadata(bytes.NewReader(buildAVI("2004:09:05 13:38:36", strd, 16)), meta)
+		if want := time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC); meta.TakenTime == nil || !meta.TakenTime.Equal(want) {
+			t.Errorf("TakenTime = %v; want the EXIF %v over IDIT", meta.TakenTime, want)
+		}
+		if meta.CameraMaker != "FUJIFILM" || meta.CameraModel != "FinePix F31fd" {
+			t.Errorf("camera = %q %q; want FUJIFILM FinePix F31fd", meta.CameraMaker, meta.CameraModel)
+		}
+	})
+
+	t.Run("not an AVI", func(t *testing.T) {
+		meta := &FileMetadata{}
+		readAVIMetadata(bytes.NewReader([]byte("RIFF\x04\x00\x00\x00WAVE")), meta)
+		if meta.TakenTime != nil {
+			t.Errorf("TakenTime = %v; want none", meta.TakenTime)
+		}
+	})
+}
+
+func TestParseIDIT(t *testing.T) {
+	want := time.Date(2004, 9, 5, 13, 38, 36, 0, time.UTC)
+	for _, s := range []string{"SUN SEP 05 13:38:36 2004", "Sun Sep  5 13:38:36 2004", "2004:09:05 13:38:36", "2004/09/05 13:38:36"} {
+		if got := parseIDIT(s); got == nil || !got.Equal(want) {
+			t.Errorf("parseIDIT(%q) = %v; want %v", s, got, want)
+		}
+	}
+	if got := parseIDIT("2004/09/05/ 13:38"); got == nil || !got.Equal(want.Truncate(time.Minute)) {
+		t.Errorf("parseIDIT(minutes) = %v; want %v", got, want.Truncate(time.Minute))
+	}
+	if got := parseIDIT("yesterday"); got != nil {
+		t.Errorf("parseIDIT(yesterday) = %v; want nil", got)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+
+package file
+
+import "time"
+
+// birthTime is not implemented on this platform: files have no birth time.
+func birthTime(path string) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package file
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// birthTime returns when the file at path was created, as NTFS records it.
+func birthTime(path string) (time.Time, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	data, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, data.CreationTime.Nanoseconds()), true
+}
@@ -0,0 +1,336 @@
+package file
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+// MetadataCache remembers what the extractors read from each file, and its
+// content hashes, across runs. Entries are keyed by source and path and are
+// valid while the file's size and modification time are unchanged (MTP
+// entries, whose times aren't reliable, by size alone). It is a single gob
+// file, read by OpenMetadataCache and rewritten by Save.
+type MetadataCache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	dirty   bool
+	hits    int
+	misses  int
+}
+
+// cacheVersion changes whenever extraction changes what it reads, which
+// invalidates every cached entry.
+const cacheVersion = 1
+
+// cacheFile is the on-disk form of a MetadataCache.
+type cacheFile struct {
+	Version int
+	Entries map[string]*cacheEntry
+}
+
+// cacheEntry is what is cached for one file.
+type cacheEntry struct {
+	// Local is the file's path when it is a local file, for Prune to check.
+	Local string
+	Size  int64
+	// ModTime is the file's modification time, zero for MTP entries.
+	ModTime time.Time
+	// Extracted is set once the extractors have run on the file; Meta is
+	// what they found, nil if nothing. Extractors identifies the extractors
+	// that ran (see extractorsFingerprint): the metadata is reused only with
+	// the same.
+	Extracted   bool
+	Extractors  string
+	Meta        *FileMetadata
+	EmbeddedXMP *FileMetadata
+	// Hashes are the content digests computed so far, by algorithm.
+	Hashes   map[string]string
+	LastUsed time.Time
+}
+
+// DefaultCachePath is where the metadata cache is kept: the user's cache
+// directory (e.g. ~/.cache/fileferry/metadata.cache).
+func DefaultCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fileferry", "metadata.cache"), nil
+}
+
+// OpenMetadataCache reads the cache at path. A missing file, or one written
+// by a FileFerry that extracted differently, gives an empty cache.
+func OpenMetadataCache(path string) (*MetadataCache, error) {
+	c := &MetadataCache{path: path, entries: make(map[string]*cacheEntry)}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open metadata cache: %w", err)
+	}
+	defer f.Close()
+	var cf cacheFile
+	if err := gob.NewDecoder(f).Decode(&cf); err != nil {
+		// A damaged cache is rebuilt rather than failing the run.
+		c.dirty = true
+		return c, nil
+	}
+	if cf.Version != cacheVersion {
+		c.dirty = true
+		return c, nil
+	}
+	for _, e := range cf.Entries {
+		e.Meta.fixZones()
+		e.EmbeddedXMP.fixZones()
+	}
+	if cf.Entries != nil {
+		c.entries = cf.Entries
+	}
+	return c, nil
+}
+
+// Save writes the cache back if it changed. The file is replaced atomically,
+// so an interrupted run leaves the previous cache intact.
+func (c *MetadataCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("save metadata cache: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".metadata-*.cache")
+	if err != nil {
+		return fmt.Errorf("save metadata cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(cacheFile{Version: cacheVersion, Entries: c.entries}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save metadata cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save metadata cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("save metadata cache: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
+
+// Stats returns how many files' metadata was reused from the cache, and how
+// many had the extractors run on them.
+func (c *MetadataCache) Stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Len returns the number of cached files.
+func (c *MetadataCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Prune drops the entries of local files that are gone or have changed, and
+// of any file not seen since olderThan ago (0 keeps them regardless of age).
+// It returns how many entries were dropped.
+func (c *MetadataCache) Prune(olderThan time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := time.Now().Add(-olderThan)
+	dropped := 0
+	for key, e := range c.entries {
+		stale := olderThan > 0 && e.LastUsed.Before(cutoff)
+		if !stale && e.Local != "" {
+			fi, err := os.Stat(e.Local)
+			stale = err != nil || fi.Size() != e.Size || !fi.ModTime().Equal(e.ModTime)
+		}
+		if stale {
+			delete(c.entries, key)
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		c.dirty = true
+	}
+	return dropped
+}
+
+// cacheKey identifies an entry of a source: by local path, or by its path
+// relative to the source root for MTP entries.
+func cacheKey(entry Entry, src ffcfg.SourceConfig, sf *sourceFile) (key, local string) {
+	if lp, ok := entry.(localPathProvider); ok {
+		local = lp.LocalPath()
+		return src.Path + "\x00" + local, local
+	}
+	return src.Path + "\x00" + sf.RelPath, ""
+}
+
+// cacheModTime is the modification time an entry is validated with: zero for
+// entries without a local path.
+func cacheModTime(entry Entry, local string) time.Time {
+	if local == "" {
+		return time.Time{}
+	}
+	return entry.ModTime()
+}
+
+// lastUsedResolution is how stale an entry's LastUsed may get before a
+// cache hit updates it.
+const lastUsedResolution = 24 * time.Hour
+
+// lookup returns a copy of the cached entry for the file, if there is one
+// still valid for it.
+func (c *MetadataCache) lookup(entry Entry, src ffcfg.SourceConfig, sf *sourceFile) (cacheEntry, bool) {
+	key, local := cacheKey(entry, src, sf)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || e.Size != entry.Size() || !e.ModTime.Equal(cacheModTime(entry, local)) {
+		return cacheEntry{}, false
+	}
+	// LastUsed only needs to be as precise as Prune's age, so a hit doesn't
+	// rewrite the whole cache on every run.
+	if now := time.Now(); now.Sub(e.LastUsed) >= lastUsedResolution {
+		e.LastUsed = now
+		c.dirty = true
+	}
+	found := *e
+	found.Hashes = maps.Clone(e.Hashes)
+	return found, true
+}
+
+// cachedMetadata returns what the extractors read from the file on an earlier
+// run with the same external extractors, and whether there was such a run.
+// The metadata is nil if they found none.
+func (c *MetadataCache) cachedMetadata(e cacheEntry, extractors string) (*FileMetadata, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !e.Extracted || e.Extractors != extractors {
+		return nil, false
+	}
+	c.hits++
+	if e.Meta == nil {
+		return nil, true
+	}
+	meta := cloneMetadata(e.Meta)
+	meta.embeddedXMP = cloneMetadata(e.EmbeddedXMP)
+	return meta, true
+}
+
+// entryFor returns the entry to record the file in, replacing one for an
+// earlier version of it. c.mu must be held.
+func (c *MetadataCache) entryFor(entry Entry, src ffcfg.SourceConfig, sf *sourceFile) *cacheEntry {
+	key, local := cacheKey(entry, src, sf)
+	modTime := cacheModTime(entry, local)
+	e, ok := c.entries[key]
+	if !ok || e.Size != entry.Size() || !e.ModTime.Equal(modTime) {
+		e = &cacheEntry{Local: local, Size: entry.Size(), ModTime: modTime}
+		c.entries[key] = e
+	}
+	e.LastUsed = time.Now()
+	c.dirty = true
+	return e
+}
+
+// storeMetadata records what the extractors read from the file, nil if they
+// found nothing.
+func (c *MetadataCache) storeMetadata(entry Entry, src ffcfg.SourceConfig, sf *sourceFile, extractors string, meta *FileMetadata) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses++
+	e := c.entryFor(entry, src, sf)
+	e.Extracted, e.Extractors = true, extractors
+	e.Meta, e.EmbeddedXMP = nil, nil
+	if meta != nil {
+		e.Meta = cloneMetadata(meta)
+		e.Meta.embeddedXMP = nil
+		e.EmbeddedXMP = cloneMetadata(meta.embeddedXMP)
+	}
+}
+
+// storeHashes records content digests of the file.
+func (c *MetadataCache) storeHashes(entry Entry, src ffcfg.SourceConfig, sf *sourceFile, hashes map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entryFor(entry, src, sf)
+	if e.Hashes == nil {
+		e.Hashes = make(map[string]string, len(hashes))
+	}
+	maps.Copy(e.Hashes, hashes)
+}
+
+// cachedHashes returns the digests for algos from hashes, if it has them all.
+func cachedHashes(hashes map[string]string, algos []string) (map[string]string, bool) {
+	found := make(map[string]string, len(algos))
+	for _, algo := range algos {
+		sum, ok := hashes[algo]
+		if !ok {
+			return nil, false
+		}
+		found[algo] = sum
+	}
+	return found, true
+}
+
+// cloneMetadata copies m deeply enough that changing either copy's fields,
+// maps or keywords doesn't affect the other.
+func cloneMetadata(m *FileMetadata) *FileMetadata {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	c.Keywords = slices.Clone(m.Keywords)
+	c.Custom = maps.Clone(m.Custom)
+	c.Provenance = maps.Clone(m.Provenance)
+	return &c
+}
+
+// extractorsFingerprint identifies the extractors registered with r, which
+// cached metadata depends on along with cacheVersion: their names,
+// priorities and types, and for external extractors their configuration.
+func extractorsFingerprint(r *ExtractorRegistry) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var b strings.Builder
+	for _, re := range r.extractors {
+		fmt.Fprintf(&b, "%q %s %T", re.name, strconv.Itoa(re.priority), re.ex)
+		if p, ok := re.ex.(pluginExtractor); ok {
+			fmt.Fprintf(&b, " %q %q", p.cfg.Command, p.cfg.Extensions)
+		}
+		b.WriteByte(';')
+	}
+	return b.String()
+}
+
+// fixZones gives the zoned times decoded from the cache a fixed zone again:
+// decoding puts times whose offset matches the machine's zone in time.Local.
+func (m *FileMetadata) fixZones() {
+	if m == nil {
+		return
+	}
+	for _, t := range []*time.Time{m.TakenTime, m.DigitizedTime, m.ModifiedTime} {
+		if t != nil && t.Location() == time.Local {
+			_, off := t.Zone()
+			*t = t.In(time.FixedZone("", off))
+		}
+	}
+}
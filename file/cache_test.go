@@ -0,0 +1,268 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+// countingExtractor is a stubExtractor that counts its Extract calls.
+type countingExtractor struct {
+	stubExtractor
+	calls *atomic.Int32
+}
+
+func (c countingExtractor) Extract(e Entry) (*FileMetadata, error) {
+	c.calls.Add(1)
+	return c.stubExtractor.Extract(e)
+}
+
+func TestMetadataCache(t *testing.T) {
+	// A time in the machine's zone comes back from gob in time.Local; the
+	// cache must hand it back in a fixed zone like the extractors do.
+	_, off := time.Now().Zone()
+	taken := time.Date(2024, 1, 15, 14, 30, 45, 0, time.FixedZone("", off))
+	calls := &atomic.Int32{}
+	extractors := &ExtractorRegistry{}
+	extractors.Register("count", PriorityBuiltin, countingExtractor{
+		stubExtractor: stubExtractor{ext: ".xyz", meta: FileMetadata{TakenTime: &taken, TakenBasis: TimeZoned, CameraModel: "X1", Keywords: []string{"a"}}},
+		calls:         calls,
+	})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "clip.xyz")
+	mustWrite(t, path, "content")
+	cachePath := filepath.Join(dir, "cache", "metadata.cache")
+	cfg := &ffcfg.Config{Profiles: map[string]ffcfg.ProfileConfig{
+		"P": {Target: ffcfg.TargetPathConfig{Path: filepath.Join(dir, "out", "{meta.taken.date}_{meta.camera.model}_{file.hash.md5}.{file.extension}")}},
+	}}
+	src := ffcfg.SourceConfig{Path: dir}
+
+	process := func(cache *MetadataCache) File {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := processFile(&localEntry{path: path, info: fi}, src, "P", cfg, extractors, cache)
+		if f.Error != nil {
+			t.Fatalf("processFile: %v", f.Error)
+		}
+		return f
+	}
+
+	cache, err := OpenMetadataCache(cachePath)
+	if err != nil {
+		t.Fatalf("OpenMetadataCache: %v", err)
+	}
+	first := process(cache)
+	// The result must not share state with the cache.
+	first.Metadata.Keywords[0] = "changed"
+	first.Metadata.Provenance["camera.model"] = "changed"
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	cache, err = OpenMetadataCache(cachePath)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	second := process(cache)
+	if calls.Load() != 1 {
+		t.Errorf("extractor ran %d times; want once, then the cache", calls.Load())
+	}
+	if second.NewPath != first.NewPath {
+		t.Errorf("cached NewPath = %q; want %q", second.NewPath, first.NewPath)
+	}
+	if got := second.Metadata.TakenTime; got == nil || !got.Equal(taken) || got.Location() == time.Local {
+		t.Errorf("cached TakenTime = %v (%v); want %v in a fixed zone", got, got.Location(), taken)
+	}
+	if second.Metadata.Keywords[0] != "a" || second.Metadata.Provenance["camera.model"] != "count" {
+		t.Errorf("cached metadata = %v %v; want it as extracted", second.Metadata.Keywords, second.Metadata.Provenance)
+	}
+	if sum, ok := second.Entry.(sha256Memo).knownSHA256(); ok {
+		t.Errorf("sha256 %s remembered; the template only hashes md5", sum)
+	}
+	if hits, misses := cache.Stats(); hits != 1 || misses != 0 {
+		t.Errorf("Stats = %d hits, %d misses; want 1, 0", hits, misses)
+	}
+
+	// A changed file is read again.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	process(cache)
+	if calls.Load() != 2 {
+		t.Errorf("extractor ran %d times after the file changed; want 2", calls.Load())
+	}
+
+	// So is one whose extractors changed, registered by a library user or
+	// configured as external extractors.
+	extractors.Register("other", PriorityBuiltin-10, stubExtractor{ext: ".abc"})
+	process(cache)
+	if calls.Load() != 3 {
+		t.Errorf("extractor ran %d times after an extractor was registered; want 3", calls.Load())
+	}
+	extractors.Register("other", PriorityBuiltin+10, stubExtractor{ext: ".abc"})
+	process(cache)
+	if calls.Load() != 4 {
+		t.Errorf("extractor ran %d times after an extractor's priority changed; want 4", calls.Load())
+	}
+	cfg.Extractors = []ffcfg.ExtractorConfig{{Name: "plugin", Command: []string{"false"}, Extensions: []string{".abc"}}}
+	before := extractorsFingerprint(extractorsFor(cfg))
+	cfg.Extractors[0].Command = []string{"true"}
+	if extractorsFingerprint(extractorsFor(cfg)) == before {
+		t.Error("fingerprint unchanged after an external extractor's command changed")
+	}
+}
+
+func TestMetadataCacheHitKeepsFile(t *testing.T) {
+	// Reusing entries doesn't rewrite the cache, unless their last use is
+	// too old to keep Prune's age accurate.
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "metadata.cache")
+	cache, err := OpenMetadataCache(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "a.jpg")
+	mustWrite(t, path, "content")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, src, sf := &localEntry{path: path, info: fi}, ffcfg.SourceConfig{Path: dir}, &sourceFile{}
+	cache.storeMetadata(e, src, sf, "", nil)
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.lookup(e, src, sf); !ok || cache.dirty {
+		t.Errorf("lookup = %v, dirty = %v; want a hit that leaves the cache unchanged", ok, cache.dirty)
+	}
+	for _, ce := range cache.entries {
+		ce.LastUsed = time.Now().Add(-2 * lastUsedResolution)
+	}
+	if _, ok := cache.lookup(e, src, sf); !ok || !cache.dirty {
+		t.Errorf("lookup = %v, dirty = %v; want a stale last use refreshed", ok, cache.dirty)
+	}
+}
+
+func TestMetadataCacheRelPath(t *testing.T) {
+	// Entries without a local path, as on MTP, are keyed by their path in
+	// the source and validated by size alone.
+	cache, err := OpenMetadataCache(filepath.Join(t.TempDir(), "metadata.cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := ffcfg.SourceConfig{Path: "mtp://Pixel/DCIM"}
+	e := &fakeEntry{name: "a.xyz", bodies: [][]byte{[]byte("content")}, modTime: time.Now()}
+	sf := &sourceFile{RelPath: "Camera/a.xyz"}
+	cache.storeMetadata(e, src, sf, "", &FileMetadata{CameraModel: "X1"})
+
+	e.modTime = e.modTime.Add(time.Hour)
+	cached, ok := cache.lookup(e, src, sf)
+	if meta, found := cache.cachedMetadata(cached, ""); !ok || !found || meta.CameraModel != "X1" {
+		t.Errorf("lookup after a time change = %+v, %v; want the cached metadata", meta, ok)
+	}
+	if _, ok := cache.lookup(e, src, &sourceFile{RelPath: "Other/a.xyz"}); ok {
+		t.Error("lookup of another path found an entry")
+	}
+	e.bodies = [][]byte{[]byte("longer content")}
+	if _, ok := cache.lookup(e, src, sf); ok {
+		t.Error("lookup after a size change found an entry")
+	}
+}
+
+func TestMetadataCachePrune(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := OpenMetadataCache(filepath.Join(dir, "metadata.cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := func(name string) {
+		path := filepath.Join(dir, name)
+		mustWrite(t, path, name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.storeMetadata(&localEntry{path: path, info: fi}, ffcfg.SourceConfig{Path: dir}, &sourceFile{}, "", nil)
+	}
+	store("kept.jpg")
+	store("removed.jpg")
+	store("changed.jpg")
+	store("unused.jpg")
+	os.Remove(filepath.Join(dir, "removed.jpg"))
+	mustWrite(t, filepath.Join(dir, "changed.jpg"), "new content")
+	for _, e := range cache.entries {
+		if filepath.Base(e.Local) == "unused.jpg" {
+			e.LastUsed = time.Now().Add(-48 * time.Hour)
+		}
+	}
+
+	if n := cache.Prune(0); n != 2 || cache.Len() != 2 {
+		t.Errorf("Prune(0) dropped %d, left %d; want the removed and changed files dropped", n, cache.Len())
+	}
+	if n := cache.Prune(24 * time.Hour); n != 1 || cache.Len() != 1 {
+		t.Errorf("Prune(24h) dropped %d, left %d; want the unused file dropped too", n, cache.Len())
+	}
+}
+
+// memoEntry is a non-local entry that remembers its SHA-256, as MTP entries
+// do.
+type memoEntry struct {
+	*fakeEntry
+	hashMemo
+}
+
+func TestMetadataCacheHashesNotTrustedForMoves(t *testing.T) {
+	// An MTP file rewritten with content of the same size still matches its
+	// cache entry. Its cached hash may render the name, but must not stand
+	// in for the content when checking the destination: the source has to
+	// survive a destination holding its old content.
+	dir := t.TempDir()
+	cache, err := OpenMetadataCache(filepath.Join(dir, "metadata.cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := ffcfg.SourceConfig{Path: "mtp://Pixel/DCIM"}
+	cfg := &ffcfg.Config{Profiles: map[string]ffcfg.ProfileConfig{"P": {
+		Patterns: []string{"{meta.taken.date}.jpg"},
+		Target:   ffcfg.TargetPathConfig{Path: filepath.Join(dir, "out", "{file.hash:8}.{file.extension}")},
+	}}}
+
+	old := []byte("old content")
+	e := &memoEntry{fakeEntry: &fakeEntry{name: "2024-01-10.jpg", bodies: [][]byte{old}}}
+	f := processFile(e, src, "P", cfg, DefaultExtractors, cache)
+	if f.Error != nil {
+		t.Fatal(f.Error)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.NewPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, f.NewPath, string(old))
+
+	e = &memoEntry{fakeEntry: &fakeEntry{name: "2024-01-10.jpg", bodies: [][]byte{[]byte("new content")}}}
+	g := processFile(e, src, "P", cfg, DefaultExtractors, cache)
+	if g.Error != nil {
+		t.Fatal(g.Error)
+	}
+	if g.NewPath != f.NewPath {
+		t.Fatalf("NewPath = %q; want %q, rendered from the cached hash", g.NewPath, f.NewPath)
+	}
+	if _, ok := e.knownSHA256(); ok {
+		t.Error("the cached hash was remembered on the entry")
+	}
+	if outcome, err := MoveEntry(e, g.NewPath); err == nil || outcome == Deduplicated {
+		t.Errorf("MoveEntry = %v, %v; want the differing destination reported", outcome, err)
+	}
+	if e.deleted {
+		t.Error("the source was deleted")
+	}
+}
@@ -0,0 +1,202 @@
+package file
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// PNG and WebP store metadata in chunks rather than a JPEG-style APP1
+// segment, so goexif can't find it. These readers walk the chunks in order,
+// skipping image data, which keeps them sequential and suitable for streamed
+// (MTP) sources.
+
+// maxMetadataChunk bounds the metadata chunks read into memory. EXIF and XMP
+// blocks are kilobytes; anything larger is skipped rather than trusted.
+const maxMetadataChunk = 16 << 20
+
+// chunkMetadata is what a chunked image holds: an EXIF block (a TIFF
+// structure) for exif.Decode, an XMP packet for parseXMP, and metadata from
+// its other chunks.
+type chunkMetadata struct {
+	exif []byte
+	xmp  []byte
+	meta FileMetadata
+}
+
+// setExif records an EXIF chunk. Some writers keep the JPEG "Exif\0\0"
+// marker in front of the TIFF header; it is dropped.
+func (c *chunkMetadata) setExif(data []byte) {
+	if c.exif == nil && len(data) > 0 {
+		c.exif = bytes.TrimPrefix(data, []byte("Exif\x00\x00"))
+	}
+}
+
+// setXMP records an XMP chunk.
+func (c *chunkMetadata) setXMP(data []byte) {
+	if c.xmp == nil && len(data) > 0 {
+		c.xmp = data
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// readPNGMetadata reads a PNG's eXIf chunk, tIME (last modification, UTC)
+// and text chunks: XMP in "XML:com.adobe.xmp" and the "Creation Time"
+// keyword. Reading stops at IEND or at the end of r.
+func readPNGMetadata(r io.Reader) (*chunkMetadata, error) {
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil || !bytes.Equal(sig, pngSignature) {
+		return nil, errors.New("png: invalid signature")
+	}
+	c := &chunkMetadata{}
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			// A truncated file still yields the chunks read so far.
+			return c, nil
+		}
+		length := int64(binary.BigEndian.Uint32(hdr[:4]))
+		typ := string(hdr[4:])
+		if typ == "IEND" {
+			return c, nil
+		}
+		var data []byte
+		switch typ {
+		case "eXIf", "tIME", "tEXt", "iTXt":
+			var err error
+			if data, err = readMetadataChunk(r, length); err != nil {
+				return c, nil
+			}
+		default:
+			if _, err := io.CopyN(io.Discard, r, length); err != nil {
+				return c, nil
+			}
+		}
+		if _, err := io.CopyN(io.Discard, r, 4); err != nil { // CRC
+			return c, nil
+		}
+
+		switch typ {
+		case "eXIf":
+			c.setExif(data)
+		case "tIME":
+			if len(data) == 7 && c.meta.ModifiedTime == nil {
+				tm := time.Date(int(binary.BigEndian.Uint16(data)), time.Month(data[2]), int(data[3]),
+					int(data[4]), int(data[5]), int(data[6]), 0, time.UTC)
+				c.meta.ModifiedTime, c.meta.ModifiedBasis = &tm, TimeContainerUTC
+			}
+		case "tEXt":
+			if keyword, text, ok := bytes.Cut(data, []byte{0}); ok {
+				c.addPNGText(string(keyword), text)
+			}
+		case "iTXt":
+			if keyword, text, ok := parseITXt(data); ok {
+				c.addPNGText(keyword, text)
+			}
+		}
+	}
+}
+
+// addPNGText handles a PNG text chunk.
+func (c *chunkMetadata) addPNGText(keyword string, text []byte) {
+	switch keyword {
+	case "XML:com.adobe.xmp":
+		c.setXMP(text)
+	case "Creation Time":
+		if c.meta.TakenTime == nil {
+			c.meta.TakenTime, c.meta.TakenBasis = parsePNGTime(string(text))
+		}
+	}
+}
+
+// parseITXt splits an iTXt chunk into its keyword and (decompressed) text.
+func parseITXt(data []byte) (string, []byte, bool) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 2 {
+		return "", nil, false
+	}
+	compressed := rest[0] == 1
+	rest = rest[2:]
+	// Language tag and translated keyword precede the text.
+	for range 2 {
+		if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+			return "", nil, false
+		}
+	}
+	if !compressed {
+		return string(keyword), rest, true
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return "", nil, false
+	}
+	defer zr.Close()
+	text, err := io.ReadAll(io.LimitReader(zr, maxMetadataChunk))
+	if err != nil {
+		return "", nil, false
+	}
+	return string(keyword), text, true
+}
+
+// parsePNGTime parses a "Creation Time" text value. The PNG spec suggests
+// RFC 1123 dates, but writers also use ISO 8601 and the EXIF layout.
+func parsePNGTime(s string) (*time.Time, TimeBasis) {
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123} {
+		if tm, err := time.Parse(layout, s); err == nil {
+			return &tm, TimeZoned
+		}
+	}
+	return parseXMPDate(s)
+}
+
+// readWebPMetadata reads a WebP's RIFF EXIF and "XMP " chunks.
+func readWebPMetadata(r io.Reader) (*chunkMetadata, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || string(hdr[:4]) != "RIFF" || string(hdr[8:]) != "WEBP" {
+		return nil, errors.New("webp: invalid RIFF header")
+	}
+	c := &chunkMetadata{}
+	for {
+		var chdr [8]byte
+		if _, err := io.ReadFull(r, chdr[:]); err != nil {
+			return c, nil
+		}
+		typ := string(chdr[:4])
+		length := int64(binary.LittleEndian.Uint32(chdr[4:]))
+		padded := length + length&1
+		switch typ {
+		case "EXIF", "XMP ":
+			data, err := readMetadataChunk(r, length)
+			if err != nil {
+				return c, nil
+			}
+			if typ == "EXIF" {
+				c.setExif(data)
+			} else {
+				c.setXMP(data)
+			}
+			padded -= length
+		}
+		if _, err := io.CopyN(io.Discard, r, padded); err != nil {
+			return c, nil
+		}
+	}
+}
+
+// readMetadataChunk reads a chunk body of the given length. Bodies larger than
+// maxMetadataChunk are skipped and read as empty.
+func readMetadataChunk(r io.Reader, length int64) ([]byte, error) {
+	if length > maxMetadataChunk {
+		_, err := io.CopyN(io.Discard, r, length)
+		return nil, err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
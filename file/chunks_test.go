@@ -0,0 +1,136 @@
+package file
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+// pngChunk returns a PNG chunk with a valid CRC.
+func pngChunk(typ string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(append(b, typ...), data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+}
+
+func buildPNG(chunks ...[]byte) []byte {
+	ihdr := pngChunk("IHDR", []byte{0, 0, 0, 1, 0, 0, 0, 1, 8, 2, 0, 0, 0})
+	idat := pngChunk("IDAT", bytes.Repeat([]byte{0x55}, 64))
+	all := append([][]byte{pngSignature, ihdr}, chunks...)
+	all = append(all, idat, pngChunk("IEND", nil))
+	return bytes.Join(all, nil)
+}
+
+// compressedITXt returns an iTXt chunk body with zlib-compressed text.
+func compressedITXt(keyword, text string) []byte {
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write([]byte(text))
+	w.Close()
+	return append([]byte(keyword+"\x00\x01\x00\x00\x00"), z.Bytes()...)
+}
+
+// webpChunk returns a RIFF chunk, padded to an even length.
+func webpChunk(typ string, data []byte) []byte {
+	b := binary.LittleEndian.AppendUint32([]byte(typ), uint32(len(data)))
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func buildWebP(chunks ...[]byte) []byte {
+	body := append([]byte("WEBP"), webpChunk("VP8X", make([]byte, 10))...)
+	body = append(body, webpChunk("VP8 ", bytes.Repeat([]byte{0xaa}, 33))...)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
+
+func TestExtractImageMetadataChunked(t *testing.T) {
+	exifTIFF := buildTIFF([]tiffField{asciiField(0x010f, "Apple")}, []tiffField{asciiField(0x9003, "2024:01:15 14:30:45")})
+	tIME := []byte{0x07, 0xe8, 3, 1, 9, 0, 0} // 2024-03-01 09:00:00 UTC
+
+	tests := []struct {
+		name      string
+		data      []byte
+		maker     string
+		model     string
+		taken     string
+		digitized string
+		modified  string
+	}{
+		{
+			name:     "shot.png",
+			data:     buildPNG(pngChunk("eXIf", exifTIFF), pngChunk("tIME", tIME)),
+			maker:    "Apple",
+			taken:    "2024-01-15 14:30:45",
+			modified: "2024-03-01 09:00:00",
+		},
+		{
+			name:      "export.png",
+			data:      buildPNG(pngChunk("iTXt", compressedITXt("XML:com.adobe.xmp", testXMP))),
+			maker:     "Google",
+			model:     "Pixel 8",
+			taken:     "2024-01-15 14:30:45",
+			digitized: "2024-01-20 08:00:00",
+			modified:  "2024-03-01 09:00:00",
+		},
+		{
+			name:  "screenshot.png",
+			data:  buildPNG(pngChunk("tEXt", []byte("Creation Time\x00Mon, 15 Jan 2024 14:30:45 +0000"))),
+			taken: "2024-01-15 14:30:45",
+		},
+		{
+			name:      "photo.webp",
+			data:      buildWebP(webpChunk("EXIF", append([]byte("Exif\x00\x00"), exifTIFF...)), webpChunk("XMP ", []byte(testXMP))),
+			maker:     "Apple",
+			model:     "Pixel 8",
+			taken:     "2024-01-15 14:30:45",
+			digitized: "2024-01-20 08:00:00",
+			modified:  "2024-03-01 09:00:00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &fakeEntry{name: tt.name, bodies: [][]byte{tt.data}}
+			meta, err := extractImageMetadataFromEntry(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			applyXMP(meta, nil, XMPFallback)
+			if meta.CameraMaker != tt.maker || meta.CameraModel != tt.model {
+				t.Errorf("camera = %q %q; want %q %q", meta.CameraMaker, meta.CameraModel, tt.maker, tt.model)
+			}
+			format := func(tm *time.Time) string {
+				if tm == nil {
+					return ""
+				}
+				return tm.Format("2006-01-02 15:04:05")
+			}
+			if got := format(meta.TakenTime); got != tt.taken {
+				t.Errorf("TakenTime = %q; want %q", got, tt.taken)
+			}
+			if got := format(meta.DigitizedTime); got != tt.digitized {
+				t.Errorf("DigitizedTime = %q; want %q", got, tt.digitized)
+			}
+			if got := format(meta.ModifiedTime); got != tt.modified {
+				t.Errorf("ModifiedTime = %q; want %q", got, tt.modified)
+			}
+		})
+	}
+}
+
+func TestReadChunkedMetadataRejectsOtherFormats(t *testing.T) {
+	if _, err := readPNGMetadata(bytes.NewReader([]byte("GIF89a..."))); err == nil {
+		t.Error("readPNGMetadata accepted a GIF")
+	}
+	if _, err := readWebPMetadata(bytes.NewReader([]byte("RIFF\x04\x00\x00\x00WAVE"))); err == nil {
+		t.Error("readWebPMetadata accepted a WAV")
+	}
+}
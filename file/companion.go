@@ -0,0 +1,360 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Companion files travel with a primary file: a RAW's .xmp sidecar, the
+// camera's .JPG twin, a .THM thumbnail, Apple's .AAE edit file or a GoPro .LRV
+// proxy. They share the primary's name stem, take no part in metadata
+// extraction and are moved to the primary's destination stem, keeping their
+// own extension.
+
+// Companion is a file moved together with a File's entry.
+type Companion struct {
+	Entry   Entry
+	OldPath string
+	// NewPath is the primary's destination stem with the companion's
+	// extension.
+	NewPath string
+	// suffix is what follows the primary's stem in the companion's name
+	// (".xmp"), or its full name when appended is set (IMG_1234.CR3.xmp,
+	// as darktable names its sidecars).
+	suffix   string
+	appended bool
+}
+
+// path returns the companion's destination for a primary moved to
+// primaryDest.
+func (c Companion) path(primaryDest string) string {
+	if c.appended {
+		return primaryDest + c.suffix
+	}
+	return strings.TrimSuffix(primaryDest, filepath.Ext(primaryDest)) + c.suffix
+}
+
+// entryGroup is a primary entry and the companions found next to it.
+type entryGroup struct {
+	primary    Entry
+	companions []Companion
+}
+
+// groupCompanions groups scanned entries into primaries and their companions.
+// An entry whose extension is one of companionExts belongs to the entry in the
+// same directory whose name or name stem equals its own stem. A companion
+// without one is processed on its own if it matches types (a camera JPEG shot
+// without a RAW) and dropped otherwise. Without companionExts every entry is a
+// group of its own.
+func groupCompanions(entries []Entry, types, companionExts []string) []entryGroup {
+	isCompanion := func(name string) bool {
+		ext := filepath.Ext(name)
+		for _, c := range companionExts {
+			if strings.EqualFold(ext, c) {
+				return true
+			}
+		}
+		return false
+	}
+	key := func(e Entry, name string) string {
+		return filepath.Dir(e.DisplayPath()) + "\x00" + name
+	}
+
+	var groups []entryGroup
+	byName := make(map[string]int) // directory and name of a primary
+	byStem := make(map[string]int) // directory and name stem of a primary
+	addPrimary := func(e Entry) {
+		name := e.Name()
+		byName[key(e, name)] = len(groups)
+		k := key(e, strings.TrimSuffix(name, filepath.Ext(name)))
+		if _, ok := byStem[k]; !ok {
+			byStem[k] = len(groups)
+		}
+		groups = append(groups, entryGroup{primary: e})
+	}
+	// attach adds e to the group of its primary, if it has one.
+	attach := func(e Entry) bool {
+		name := e.Name()
+		ext := filepath.Ext(name)
+		stem := strings.TrimSuffix(name, ext)
+		c := Companion{Entry: e, OldPath: e.DisplayPath(), suffix: ext}
+		i, ok := byName[key(e, stem)]
+		if ok {
+			c.appended = true
+		} else if i, ok = byStem[key(e, stem)]; !ok {
+			return false
+		}
+		groups[i].companions = append(groups[i].companions, c)
+		return true
+	}
+
+	var companions []Entry
+	for _, e := range entries {
+		if isCompanion(e.Name()) {
+			companions = append(companions, e)
+		} else {
+			addPrimary(e)
+		}
+	}
+	// Companions without a primary that are wanted in their own right
+	// become primaries first, so their own companions (IMG_1234.JPG's
+	// IMG_1234.AAE) can attach to them.
+	var rest []Entry
+	for _, e := range companions {
+		if attach(e) {
+			continue
+		}
+		if isFileType(e.Name(), types) {
+			addPrimary(e)
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	for _, e := range rest {
+		attach(e)
+	}
+	return groups
+}
+
+// MoveGroupWithPolicy moves entry to destPath like MoveEntryWithPolicy, and
+// its companions with it as one unit. A conflict at any member's destination
+// is resolved by the policy for the whole group: it fails, is skipped, moves
+// to a suffixed name or into the quarantine directory together, or, with
+// keep-newer, replaces what it conflicts with if every conflicting member is
+// newer and is skipped otherwise.
+//
+// Every member is first copied next to its destination and verified; only
+// when all copies check out are they renamed into place and the sources
+// deleted, so a failed verification leaves the whole group in the source.
+//
+// The results hold the primary's outcome followed by each companion's.
+func MoveGroupWithPolicy(entry Entry, destPath string, companions []Companion, policy ConflictPolicy) ([]MoveResult, error) {
+	results, err := planGroup(entry, destPath, companions, policy)
+	if err != nil {
+		return results, err
+	}
+	members := []Entry{entry}
+	for _, c := range companions {
+		members = append(members, c.Entry)
+	}
+
+	staged := make([]string, len(members))
+	discard := func() {
+		for _, tmp := range staged {
+			if tmp != "" {
+				os.Remove(tmp)
+			}
+		}
+	}
+	for i, res := range results {
+		switch res.Outcome {
+		case Moved, Replaced, Quarantined:
+			tmp, err := stageEntry(members[i], res.Path)
+			if err != nil {
+				discard()
+				return results, err
+			}
+			staged[i] = tmp
+		}
+	}
+	// A failing rename can leave part of the group in place, but every
+	// source is still intact: the next run deduplicates what was moved.
+	for i, tmp := range staged {
+		if tmp == "" {
+			continue
+		}
+		staged[i] = ""
+		if err := finalizeStaged(tmp, results[i].Path); err != nil {
+			discard()
+			return results, err
+		}
+	}
+	for i, res := range results {
+		switch res.Outcome {
+		case Moved, Replaced, Quarantined:
+			err = deleteMoved(members[i], res.Path)
+		case Deduplicated:
+			if err = members[i].Delete(); err != nil {
+				err = fmt.Errorf("source %s is a duplicate of %s but failed to delete: %w", members[i].DisplayPath(), res.Path, err)
+			}
+		}
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// StrayCompanions returns the companions of a file that isn't at its
+// destination stem, for a file already at its target (ShouldOp false): the
+// group isn't moved then, but these still have to be, with
+// MoveEntryWithPolicy.
+func (f File) StrayCompanions() []Companion {
+	var stray []Companion
+	for _, c := range f.Companions {
+		if lp, ok := c.Entry.(localPathProvider); ok {
+			absSrc, _ := filepath.Abs(lp.LocalPath())
+			absDst, _ := filepath.Abs(c.NewPath)
+			if absSrc == absDst {
+				continue
+			}
+		}
+		stray = append(stray, c)
+	}
+	return stray
+}
+
+// PreviewGroupWithPolicy reports what MoveGroupWithPolicy would do, without
+// modifying any file.
+func PreviewGroupWithPolicy(entry Entry, destPath string, companions []Companion, policy ConflictPolicy) ([]MoveResult, error) {
+	return planGroup(entry, destPath, companions, policy)
+}
+
+// planGroup decides how a group move resolves without modifying anything.
+// The group is inspected at its destination as a whole: when any member's
+// destination holds a different file, the conflict policy applies to the
+// group, so its members are skipped, suffixed or quarantined together and
+// never split up.
+func planGroup(entry Entry, destPath string, companions []Companion, policy ConflictPolicy) ([]MoveResult, error) {
+	members := []Entry{entry}
+	for _, c := range companions {
+		members = append(members, c.Entry)
+	}
+	paths := func(primaryDest string) []string {
+		paths := []string{primaryDest}
+		for _, c := range companions {
+			paths = append(paths, c.path(primaryDest))
+		}
+		return paths
+	}
+
+	results := make([]MoveResult, len(members))
+	for i, path := range paths(destPath) {
+		results[i] = MoveResult{Outcome: Moved, Path: path}
+	}
+	states, differs, err := inspectGroup(members, results)
+	if err != nil {
+		return results, err
+	}
+	if differs == nil {
+		return groupResults(results, states, Moved, ""), nil
+	}
+
+	switch policy.Mode {
+	case "", ConflictError:
+		for i := range results {
+			results[i].Conflict = ConflictError
+		}
+		return results, differs
+	case ConflictSkip:
+		for i := range results {
+			results[i].Outcome, results[i].Conflict = Skipped, policy.Mode
+		}
+		return results, nil
+	case ConflictSuffix:
+		return suffixedGroup(members, destPath, paths, Moved, policy.Mode)
+	case ConflictKeepNewer:
+		// The group replaces what it conflicts with only if each of its
+		// differing members is newer than the file it would replace.
+		outcome := Replaced
+		for i, state := range states {
+			if state != destDiffers {
+				continue
+			}
+			newer, err := newerThanDestination(members[i], results[i].Path)
+			if err != nil {
+				return results, err
+			}
+			if !newer {
+				outcome = Skipped
+			}
+		}
+		if outcome == Skipped {
+			for i := range results {
+				results[i].Outcome, results[i].Conflict = Skipped, policy.Mode
+			}
+			return results, nil
+		}
+		return groupResults(results, states, Moved, policy.Mode), nil
+	case ConflictQuarantine:
+		if policy.QuarantineDir == "" {
+			return results, fmt.Errorf("destination %s differs from source %s and no conflicts directory is configured for quarantine", destPath, entry.DisplayPath())
+		}
+		quarantined := filepath.Join(policy.QuarantineDir, filepath.Base(destPath))
+		for i, path := range paths(quarantined) {
+			results[i] = MoveResult{Outcome: Quarantined, Path: path}
+		}
+		states, differs, err := inspectGroup(members, results)
+		if err != nil {
+			return results, err
+		}
+		if differs == nil {
+			return groupResults(results, states, Quarantined, policy.Mode), nil
+		}
+		return suffixedGroup(members, quarantined, paths, Quarantined, policy.Mode)
+	}
+	return results, fmt.Errorf("unknown conflict policy %q", policy.Mode)
+}
+
+// inspectGroup inspects the destination of each member at the path in its
+// result. differs describes the first member whose destination holds a
+// different file, or is nil if none does.
+func inspectGroup(members []Entry, results []MoveResult) (states []destState, differs error, err error) {
+	states = make([]destState, len(members))
+	for i, e := range members {
+		var d error
+		states[i], d, err = inspectDestination(e, results[i].Path)
+		if err != nil {
+			return states, nil, err
+		}
+		if d != nil && differs == nil {
+			differs = d
+			if i > 0 {
+				differs = fmt.Errorf("companion of %s: %w", members[0].DisplayPath(), d)
+			}
+		}
+	}
+	return states, differs, nil
+}
+
+// groupResults sets each result's outcome from its destination's state:
+// absent for a free destination, Deduplicated for one holding the same
+// content and Replaced for one holding a different file.
+func groupResults(results []MoveResult, states []destState, absent MoveOutcome, conflict string) []MoveResult {
+	for i, state := range states {
+		switch state {
+		case destAbsent:
+			results[i].Outcome = absent
+		case destSame:
+			results[i].Outcome = Deduplicated
+		case destDiffers:
+			results[i].Outcome = Replaced
+		}
+		results[i].Conflict = conflict
+	}
+	return results
+}
+
+// suffixedGroup finds the first of destPath-1, destPath-2, … where no
+// member's destination holds a different file, like freeSuffixedPath does
+// for a single entry.
+func suffixedGroup(members []Entry, destPath string, paths func(string) []string, absent MoveOutcome, conflict string) ([]MoveResult, error) {
+	ext := filepath.Ext(destPath)
+	stem := strings.TrimSuffix(destPath, ext)
+	results := make([]MoveResult, len(members))
+	for n := 1; n <= maxConflictSuffix; n++ {
+		for i, path := range paths(fmt.Sprintf("%s-%d%s", stem, n, ext)) {
+			results[i] = MoveResult{Outcome: absent, Path: path}
+		}
+		states, differs, err := inspectGroup(members, results)
+		if err != nil {
+			return results, err
+		}
+		if differs == nil {
+			return groupResults(results, states, absent, conflict), nil
+		}
+	}
+	return results, fmt.Errorf("no free name for %s after %d suffixes", destPath, maxConflictSuffix)
+}
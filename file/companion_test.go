@@ -0,0 +1,295 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+func TestGroupCompanions(t *testing.T) {
+	var entries []Entry
+	for _, name := range []string{
+		"IMG_0001.CR3", "IMG_0001.JPG", "IMG_0001.xmp", "IMG_0001.CR3.xmp",
+		"IMG_0002.JPG", "IMG_0002.AAE",
+		"IMG_0003.THM",
+		"GOPR0004.MP4", "GOPR0004.LRV",
+	} {
+		entries = append(entries, &fakeEntry{name: name, bodies: [][]byte{nil}})
+	}
+	groups := groupCompanions(entries, []string{"image", "image.raw", "video"}, []string{".xmp", ".jpg", ".thm", ".aae", ".lrv"})
+
+	got := make(map[string][]string)
+	for _, g := range groups {
+		var names []string
+		for _, c := range g.companions {
+			names = append(names, c.Entry.Name()+" "+c.path("/out/2024/renamed."+filepath.Ext(g.primary.Name())[1:]))
+		}
+		got[g.primary.Name()] = names
+	}
+	want := map[string][]string{
+		"IMG_0001.CR3": {
+			"IMG_0001.JPG /out/2024/renamed.JPG",
+			"IMG_0001.xmp /out/2024/renamed.xmp",
+			"IMG_0001.CR3.xmp /out/2024/renamed.CR3.xmp",
+		},
+		"IMG_0002.JPG": {"IMG_0002.AAE /out/2024/renamed.AAE"},
+		"GOPR0004.MP4": {"GOPR0004.LRV /out/2024/renamed.LRV"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("groups = %v; want %v", got, want)
+	}
+	for primary, names := range want {
+		if !slices.Equal(got[primary], names) {
+			t.Errorf("companions of %s = %q; want %q", primary, got[primary], names)
+		}
+	}
+
+	if groups := groupCompanions(entries, []string{"image"}, nil); len(groups) != len(entries) {
+		t.Errorf("without companions: %d groups; want one per entry (%d)", len(groups), len(entries))
+	}
+}
+
+func TestMoveGroupWithPolicy(t *testing.T) {
+	raw := []byte("raw bytes")
+	sidecar := []byte("<x:xmpmeta/>")
+
+	t.Run("moves every member", func(t *testing.T) {
+		dir := t.TempDir()
+		e := &fakeEntry{name: "IMG_0001.CR3", bodies: [][]byte{raw}}
+		c := &fakeEntry{name: "IMG_0001.xmp", bodies: [][]byte{sidecar}}
+		comps := []Companion{{Entry: c, suffix: ".xmp"}}
+		results, err := MoveGroupWithPolicy(e, filepath.Join(dir, "a.cr3"), comps, ConflictPolicy{})
+		if err != nil {
+			t.Fatalf("MoveGroupWithPolicy: %v", err)
+		}
+		if len(results) != 2 || results[0].Outcome != Moved || results[1].Outcome != Moved || results[1].Path != filepath.Join(dir, "a.xmp") {
+			t.Errorf("results = %+v; want both Moved, companion to a.xmp", results)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dir, "a.xmp")); string(got) != string(sidecar) {
+			t.Errorf("companion content = %q; want %q", got, sidecar)
+		}
+		if !e.deleted || !c.deleted {
+			t.Errorf("deleted = %v, %v; want both sources deleted", e.deleted, c.deleted)
+		}
+	})
+
+	t.Run("a failed verification moves nothing", func(t *testing.T) {
+		dir := t.TempDir()
+		e := &fakeEntry{name: "IMG_0001.CR3", bodies: [][]byte{raw}}
+		// The companion reads differently the second time, so its copy
+		// fails verification.
+		c := &fakeEntry{name: "IMG_0001.xmp", bodies: [][]byte{sidecar, []byte("<x:xmpmeta>changed</x:xmpmeta>")}}
+		comps := []Companion{{Entry: c, suffix: ".xmp"}}
+		if _, err := MoveGroupWithPolicy(e, filepath.Join(dir, "a.cr3"), comps, ConflictPolicy{}); err == nil {
+			t.Fatal("expected a verification error")
+		}
+		if e.deleted || c.deleted {
+			t.Errorf("deleted = %v, %v; want both sources kept", e.deleted, c.deleted)
+		}
+		if left, _ := os.ReadDir(dir); len(left) != 0 {
+			t.Errorf("destination holds %d files; want none", len(left))
+		}
+	})
+
+	t.Run("companions follow a suffixed primary", func(t *testing.T) {
+		dir := t.TempDir()
+		mustWrite(t, filepath.Join(dir, "a.cr3"), "another picture")
+		mustWrite(t, filepath.Join(dir, "a.xmp"), "another sidecar")
+		e := &fakeEntry{name: "IMG_0001.CR3", bodies: [][]byte{raw}}
+		c := &fakeEntry{name: "IMG_0001.xmp", bodies: [][]byte{sidecar}}
+		comps := []Companion{{Entry: c, suffix: ".xmp"}}
+		results, err := MoveGroupWithPolicy(e, filepath.Join(dir, "a.cr3"), comps, ConflictPolicy{Mode: ConflictSuffix})
+		if err != nil {
+			t.Fatalf("MoveGroupWithPolicy: %v", err)
+		}
+		if results[0].Path != filepath.Join(dir, "a-1.cr3") || results[1].Path != filepath.Join(dir, "a-1.xmp") {
+			t.Errorf("paths = %s, %s; want a-1.cr3, a-1.xmp", results[0].Path, results[1].Path)
+		}
+	})
+
+	t.Run("skipped primary keeps its companions", func(t *testing.T) {
+		dir := t.TempDir()
+		mustWrite(t, filepath.Join(dir, "a.cr3"), "another picture")
+		e := &fakeEntry{name: "IMG_0001.CR3", bodies: [][]byte{raw}}
+		c := &fakeEntry{name: "IMG_0001.xmp", bodies: [][]byte{sidecar}}
+		comps := []Companion{{Entry: c, suffix: ".xmp"}}
+		results, err := MoveGroupWithPolicy(e, filepath.Join(dir, "a.cr3"), comps, ConflictPolicy{Mode: ConflictSkip})
+		if err != nil {
+			t.Fatalf("MoveGroupWithPolicy: %v", err)
+		}
+		if results[1].Outcome != Skipped || c.deleted {
+			t.Errorf("companion result = %+v, deleted = %v; want Skipped and kept", results[1], c.deleted)
+		}
+	})
+
+	t.Run("differing companion destination fails the group", func(t *testing.T) {
+		dir := t.TempDir()
+		mustWrite(t, filepath.Join(dir, "a.xmp"), "another sidecar")
+		e := &fakeEntry{name: "IMG_0001.CR3", bodies: [][]byte{raw}}
+		c := &fakeEntry{name: "IMG_0001.xmp", bodies: [][]byte{sidecar}}
+		comps := []Companion{{Entry: c, suffix: ".xmp"}}
+		if _, err := MoveGroupWithPolicy(e, filepath.Join(dir, "a.cr3"), comps, ConflictPolicy{}); err == nil {
+			t.Fatal("expected a conflict error")
+		}
+		if e.deleted {
+			t.Error("primary was deleted")
+		}
+		if _, err := os.Stat(filepath.Join(dir, "a.cr3")); !os.IsNotExist(err) {
+			t.Errorf("primary was copied despite the companion conflict: %v", err)
+		}
+	})
+}
+
+func TestMoveGroupCompanionConflict(t *testing.T) {
+	// Only the companion's destination holds a different file; the policy
+	// resolves the conflict for the whole group.
+	newer := time.Now().Add(time.Hour)
+	tests := []struct {
+		name      string
+		policy    ConflictPolicy
+		modTime   time.Time
+		wantErr   bool
+		outcomes  []MoveOutcome
+		paths     []string // relative to the test directory
+		wantMoved bool
+	}{
+		{name: "error", policy: ConflictPolicy{}, wantErr: true},
+		{name: "skip", policy: ConflictPolicy{Mode: ConflictSkip}, outcomes: []MoveOutcome{Skipped, Skipped}, paths: []string{"a.cr3", "a.xmp"}},
+		{name: "suffix", policy: ConflictPolicy{Mode: ConflictSuffix}, outcomes: []MoveOutcome{Moved, Moved}, paths: []string{"a-1.cr3", "a-1.xmp"}, wantMoved: true},
+		{name: "quarantine", policy: ConflictPolicy{Mode: ConflictQuarantine, QuarantineDir: "conflicts"}, outcomes: []MoveOutcome{Quarantined, Quarantined}, paths: []string{"conflicts/a.cr3", "conflicts/a.xmp"}, wantMoved: true},
+		{name: "keep-newer replaces", policy: ConflictPolicy{Mode: ConflictKeepNewer}, modTime: newer, outcomes: []MoveOutcome{Moved, Replaced}, paths: []string{"a.cr3", "a.xmp"}, wantMoved: true},
+		{name: "keep-newer skips", policy: ConflictPolicy{Mode: ConflictKeepNewer}, outcomes: []MoveOutcome{Skipped, Skipped}, paths: []string{"a.cr3", "a.xmp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			mustWrite(t, filepath.Join(dir, "a.xmp"), "another sidecar")
+			if tt.policy.QuarantineDir != "" {
+				tt.policy.QuarantineDir = filepath.Join(dir, tt.policy.QuarantineDir)
+			}
+			e := &fakeEntry{name: "IMG_0001.CR3", bodies: [][]byte{[]byte("raw bytes")}, modTime: tt.modTime}
+			c := &fakeEntry{name: "IMG_0001.xmp", bodies: [][]byte{[]byte("<x:xmpmeta/>")}, modTime: tt.modTime}
+			comps := []Companion{{Entry: c, suffix: ".xmp"}}
+
+			results, err := MoveGroupWithPolicy(e, filepath.Join(dir, "a.cr3"), comps, tt.policy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected a conflict error")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("MoveGroupWithPolicy: %v", err)
+				}
+				for i, res := range results {
+					if want := filepath.Join(dir, filepath.FromSlash(tt.paths[i])); res.Outcome != tt.outcomes[i] || res.Path != want {
+						t.Errorf("results[%d] = %+v; want %v at %s", i, res, tt.outcomes[i], want)
+					}
+				}
+			}
+			if e.deleted != tt.wantMoved || c.deleted != tt.wantMoved {
+				t.Errorf("deleted = %v, %v; want both %v", e.deleted, c.deleted, tt.wantMoved)
+			}
+			want := "another sidecar"
+			if len(tt.outcomes) > 1 && tt.outcomes[1] == Replaced {
+				want = "<x:xmpmeta/>"
+			}
+			if got, _ := os.ReadFile(filepath.Join(dir, "a.xmp")); string(got) != want {
+				t.Errorf("a.xmp = %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestStrayCompanions(t *testing.T) {
+	dir := t.TempDir()
+	local := func(rel string) *localEntry {
+		path := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		mustWrite(t, path, rel)
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &localEntry{path: path, info: fi}
+	}
+	primary := local("2024-06-01.cr3")
+	f := File{
+		OldPath: primary.path,
+		Entry:   primary,
+		Companions: []Companion{
+			{Entry: local("2024-06-01.xmp"), suffix: ".xmp"},
+			{Entry: local("old/2024-06-01.aae"), suffix: ".aae"},
+			{Entry: &fakeEntry{name: "2024-06-01.thm", bodies: [][]byte{nil}}, suffix: ".thm"},
+		},
+	}
+	setOp(&f, primary, primary.path)
+	if f.ShouldOp {
+		t.Fatal("ShouldOp = true; want the primary in place")
+	}
+
+	var got []string
+	for _, c := range f.StrayCompanions() {
+		got = append(got, c.Entry.Name())
+	}
+	if want := []string{"2024-06-01.aae", "2024-06-01.thm"}; !slices.Equal(got, want) {
+		t.Errorf("StrayCompanions = %v; want %v", got, want)
+	}
+
+	// Moving one puts it next to the primary.
+	stray := f.StrayCompanions()[0]
+	if _, err := MoveEntryWithPolicy(stray.Entry, stray.NewPath, f.Conflict); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "2024-06-01.aae")); string(got) != "old/2024-06-01.aae" {
+		t.Errorf("moved companion = %q", got)
+	}
+}
+
+func TestFileIteratorCompanions(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"IMG_0001.dng", "IMG_0001.xmp", "IMG_0001.JPG", "IMG_0002.JPG", "notes.xmp"} {
+		mustWrite(t, filepath.Join(tmpDir, name), name)
+	}
+	cfg := &ffcfg.Config{
+		Profiles: map[string]ffcfg.ProfileConfig{
+			"test": {
+				Sources:    []ffcfg.SourceConfig{{Path: tmpDir, Types: []string{"image", "image.raw"}}},
+				Companions: []string{".xmp", ".jpg"},
+				Target:     ffcfg.TargetPathConfig{Path: "/target/{file.name}"},
+			},
+		},
+	}
+
+	got := make(map[string][]string)
+	for f := range FileIterator(cfg) {
+		if f.Error != nil {
+			t.Fatalf("%s: %v", f.OldPath, f.Error)
+		}
+		var comps []string
+		for _, c := range f.Companions {
+			comps = append(comps, filepath.ToSlash(c.NewPath))
+		}
+		got[filepath.Base(f.OldPath)] = comps
+	}
+	want := map[string][]string{
+		"IMG_0001.dng": {"/target/IMG_0001.xmp", "/target/IMG_0001.JPG"},
+		"IMG_0002.JPG": nil,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("files = %v; want %v", got, want)
+	}
+	for name, comps := range want {
+		c := got[name]
+		slices.Sort(c)
+		slices.Sort(comps)
+		if !slices.Equal(c, comps) {
+			t.Errorf("companions of %s = %v; want %v", name, c, comps)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package file
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// applyDateSources picks the taken time by the profile's date_sources: the
+// first source listed that has a time wins. meta is the merged metadata with
+// its taken time resolved (see takenByPrecedence) and nameMeta what filename
+// patterns gave, whose time the file's content may have overridden in meta.
+// Without date_sources meta is returned as is.
+func applyDateSources(meta, nameMeta *FileMetadata, entry Entry, sources []string) *FileMetadata {
+	if len(sources) == 0 {
+		return meta
+	}
+	var content string
+	if meta != nil && meta.TakenTime != nil {
+		content = dateSourceOf(meta.Provenance["taken"], entry, sources)
+	}
+	for _, src := range sources {
+		var tm *time.Time
+		var basis TimeBasis
+		switch {
+		case src == content:
+			return meta
+		case src == "filename":
+			if nameMeta != nil && nameMeta.TakenTime != nil {
+				tm, basis = nameMeta.TakenTime, nameMeta.TakenBasis
+			}
+		case src == "mtime" || src == "birthtime":
+			if t, ok := fileTime(entry, src); ok {
+				tm, basis = &t, TimeInstant
+			}
+		}
+		if tm != nil {
+			resolved := withTakenTime(meta, entry, tm, basis)
+			resolved.setProvenance("taken", src)
+			return resolved
+		}
+	}
+	if meta == nil || meta.TakenTime == nil {
+		return meta
+	}
+	resolved := withTakenTime(meta, entry, nil, TimeFloating)
+	delete(resolved.Provenance, "taken")
+	return resolved
+}
+
+// withTakenTime returns a copy of meta (or of empty metadata for the entry)
+// with the given taken time.
+func withTakenTime(meta *FileMetadata, entry Entry, tm *time.Time, basis TimeBasis) *FileMetadata {
+	var resolved FileMetadata
+	if meta != nil {
+		resolved = *meta
+		resolved.Provenance = maps.Clone(meta.Provenance)
+	} else {
+		resolved.Extension = normalizeExt(filepath.Ext(entry.Name()))
+	}
+	resolved.TakenTime, resolved.TakenBasis = tm, basis
+	return &resolved
+}
+
+// dateSourceOf returns the date_sources entry a time attributed to
+// provenance counts as. Extractors not named in sources count as exif for
+// images and container for videos.
+func dateSourceOf(provenance string, entry Entry, sources []string) string {
+	switch provenance {
+	case "image", "exiftool", "xmp":
+		return "exif"
+	case "video", "ffprobe":
+		return "container"
+	case "filename", "mtime", "birthtime":
+		return provenance
+	}
+	if provenance != "" && slices.Contains(sources, provenance) {
+		return provenance
+	}
+	if isFileType(entry.Name(), []string{"video"}) {
+		return "container"
+	}
+	return "exif"
+}
+
+// filenameFirst reports whether sources let a taken time from the filename
+// stand without reading the file's content: no content source (exif,
+// container or an extractor) comes before filename, or none is listed.
+func filenameFirst(sources []string) bool {
+	for _, src := range sources {
+		switch src {
+		case "filename":
+			return true
+		case "mtime", "birthtime":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// fileTime returns the entry's modification ("mtime") or creation
+// ("birthtime") time, if known. Birth times are only available for local
+// files, on filesystems and platforms that record them.
+func fileTime(entry Entry, source string) (time.Time, bool) {
+	switch source {
+	case "mtime":
+		t := entry.ModTime()
+		return t, !t.IsZero()
+	case "birthtime":
+		if lp, ok := entry.(localPathProvider); ok {
+			return birthTime(lp.LocalPath())
+		}
+	}
+	return time.Time{}, false
+}
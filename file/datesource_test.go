@@ -0,0 +1,109 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+func TestApplyDateSources(t *testing.T) {
+	exifTime := time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC)
+	nameTime := time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)
+	mtime := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	content := &FileMetadata{TakenTime: &exifTime, Provenance: map[string]string{"taken": "image"}}
+	name := &FileMetadata{TakenTime: &nameTime, Provenance: map[string]string{"taken": "filename"}}
+	photo := &fakeEntry{name: "IMG_1.jpg", bodies: [][]byte{nil}, modTime: mtime}
+	clip := &fakeEntry{name: "clip.mp4", bodies: [][]byte{nil}, modTime: mtime}
+
+	tests := []struct {
+		name      string
+		meta      *FileMetadata
+		entry     Entry
+		sources   []string
+		noName    bool
+		want      *time.Time
+		wantBasis TimeBasis
+		wantProv  string
+	}{
+		{name: "default keeps the metadata", meta: content, entry: photo, want: &exifTime, wantProv: "image"},
+		{name: "exif first", meta: content, entry: photo, sources: []string{"exif", "filename"}, want: &exifTime, wantProv: "image"},
+		{name: "filename over exif", meta: content, entry: photo, sources: []string{"filename", "exif"}, want: &nameTime, wantProv: "filename"},
+		{name: "container skips image metadata", meta: content, entry: photo, sources: []string{"container", "mtime"}, want: &mtime, wantBasis: TimeInstant, wantProv: "mtime"},
+		{name: "unknown extractor counts by file type", meta: &FileMetadata{TakenTime: &exifTime, Provenance: map[string]string{"taken": "custom"}}, entry: clip, sources: []string{"container"}, want: &exifTime, wantProv: "custom"},
+		{name: "mtime fills an empty file", entry: photo, sources: []string{"exif", "mtime"}, want: &mtime, wantBasis: TimeInstant, wantProv: "mtime"},
+		{name: "no listed source has a time", meta: content, entry: photo, sources: []string{"filename"}, noName: true},
+		{name: "unknown mtime", entry: &fakeEntry{name: "a.jpg", bodies: [][]byte{nil}}, sources: []string{"mtime"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nameMeta := name
+			if tt.noName {
+				nameMeta = nil
+			}
+			got := applyDateSources(tt.meta, nameMeta, tt.entry, tt.sources)
+			var tm *time.Time
+			if got != nil {
+				tm = got.TakenTime
+			}
+			if (tm == nil) != (tt.want == nil) || tm != nil && (!tm.Equal(*tt.want) || got.TakenBasis != tt.wantBasis || got.Provenance["taken"] != tt.wantProv) {
+				t.Fatalf("taken = %v; want %v", got, tt.want)
+			}
+			if tt.meta == nil && got != nil && got.Extension != "jpg" {
+				t.Errorf("Extension = %q; want it defaulted from the name", got.Extension)
+			}
+		})
+	}
+	if content.Provenance["taken"] != "image" {
+		t.Errorf("applyDateSources changed its input's provenance: %v", content.Provenance)
+	}
+}
+
+func TestProcessFileDateSources(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.jpg")
+	mustWrite(t, path, "not really a JPEG")
+	mtime := time.Date(2023, 7, 4, 18, 15, 0, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := func(sources []string) *ffcfg.Config {
+		return &ffcfg.Config{Profiles: map[string]ffcfg.ProfileConfig{"P": {
+			Timezone:    "Europe/Zagreb",
+			DateSources: sources,
+			Target:      ffcfg.TargetPathConfig{Path: filepath.Join(dir, "out", "{meta.taken.datetime}_{file.mtime.date}.{file.extension}")},
+		}}}
+	}
+
+	// Without file times in date_sources, a file without metadata is skipped.
+	f := processFile(&localEntry{path: path, info: fi}, ffcfg.SourceConfig{}, "P", profile(nil), DefaultExtractors, nil)
+	if _, ok := f.Error.(*UnpopulatedTokensError); !ok {
+		t.Fatalf("without date_sources: error = %v; want unpopulated tokens", f.Error)
+	}
+
+	f = processFile(&localEntry{path: path, info: fi}, ffcfg.SourceConfig{}, "P", profile([]string{"exif", "filename", "mtime"}), DefaultExtractors, nil)
+	if f.Error != nil {
+		t.Fatalf("with mtime: %v", f.Error)
+	}
+	if want := filepath.Join(dir, "out", "2023-07-04-20-15-00_2023-07-04.jpg"); f.NewPath != want {
+		t.Errorf("NewPath = %q; want %q (the mtime in the profile's timezone)", f.NewPath, want)
+	}
+	if f.Metadata.Provenance["taken"] != "mtime" {
+		t.Errorf("Provenance[taken] = %q; want mtime", f.Metadata.Provenance["taken"])
+	}
+
+	if _, ok := birthTime(path); ok {
+		f = processFile(&localEntry{path: path, info: fi}, ffcfg.SourceConfig{}, "P", profile([]string{"birthtime", "mtime"}), DefaultExtractors, nil)
+		if f.Error != nil || f.Metadata.Provenance["taken"] != "birthtime" {
+			t.Errorf("with birthtime: %v, %v; want the taken time from birthtime", f.Error, f.Metadata)
+		}
+	}
+}
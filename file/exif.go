@@ -0,0 +1,159 @@
+package file
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Tags goexif doesn't know, loaded by extraTagParser: the EXIF 2.31 offset
+// tags and the XMP packet TIFF-based files (DNG, most RAW) embed in IFD0.
+const (
+	exifOffsetTime          exif.FieldName = "OffsetTime"
+	exifOffsetTimeOriginal  exif.FieldName = "OffsetTimeOriginal"
+	exifOffsetTimeDigitized exif.FieldName = "OffsetTimeDigitized"
+	exifXMLPacket           exif.FieldName = "XMLPacket"
+)
+
+var extraFields = map[uint16]exif.FieldName{
+	0x02bc: exifXMLPacket,
+	0x9010: exifOffsetTime,
+	0x9011: exifOffsetTimeOriginal,
+	0x9012: exifOffsetTimeDigitized,
+}
+
+func init() {
+	exif.RegisterParsers(extraTagParser{})
+}
+
+// extraTagParser is a goexif parser that loads extraFields from IFD0 and the
+// EXIF sub-IFD. It never fails: a missing or broken sub-IFD simply leaves the
+// tags unset.
+type extraTagParser struct{}
+
+func (extraTagParser) Parse(x *exif.Exif) error {
+	if x.Tiff == nil || len(x.Tiff.Dirs) == 0 {
+		return nil
+	}
+	x.LoadTags(x.Tiff.Dirs[0], extraFields, false)
+	ptr, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	off, err := ptr.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(off, 0); err != nil {
+		return nil
+	}
+	if dir, _, err := tiff.DecodeDir(r, x.Tiff.Order); err == nil {
+		x.LoadTags(dir, extraFields, false)
+	}
+	return nil
+}
+
+// exifDateField names the tags making up one EXIF timestamp: the date itself
+// and its optional sub-second and UTC offset companions.
+type exifDateField struct {
+	date, subsec, offset exif.FieldName
+}
+
+var (
+	// exifOriginal is when the picture was taken.
+	exifOriginal = exifDateField{exif.DateTimeOriginal, exif.SubSecTimeOriginal, exifOffsetTimeOriginal}
+	// exifDigitized is when it was stored digitally (differs for scans).
+	exifDigitized = exifDateField{exif.DateTimeDigitized, exif.SubSecTimeDigitized, exifOffsetTimeDigitized}
+	// exifModified is when the file was last changed, typically by an editor.
+	exifModified = exifDateField{exif.DateTime, exif.SubSecTime, exifOffsetTime}
+)
+
+// exifTime returns the timestamp f recorded in x, including its sub-second
+// part, along with its basis: zoned when f's offset tag is present, floating
+// otherwise. Files that write only the modification date's companions
+// (SubSecTime, OffsetTime) get those applied to another date only when the
+// modification date (DateTime) is that same date: the companions describe
+// DateTime, and an edited file's offset may not be where it was taken.
+func exifTime(x exifTags, f exifDateField) (*time.Time, TimeBasis) {
+	s, ok := exifString(x, f.date)
+	if !ok {
+		return nil, TimeFloating
+	}
+	tm, err := time.Parse("2006:01:02 15:04:05", s)
+	if err != nil {
+		return nil, TimeFloating
+	}
+	subsecs := []exif.FieldName{f.subsec}
+	offsets := []exif.FieldName{f.offset}
+	if modified, ok := exifString(x, exifModified.date); ok && modified == s {
+		subsecs = append(subsecs, exifModified.subsec)
+		offsets = append(offsets, exifModified.offset)
+	}
+	for _, name := range subsecs {
+		if v, ok := exifString(x, name); ok {
+			if ns, ok := parseSubSec(v); ok {
+				tm = tm.Add(ns)
+				break
+			}
+		}
+	}
+	basis := TimeFloating
+	for _, name := range offsets {
+		if v, ok := exifString(x, name); ok {
+			if loc, ok := parseOffset(v); ok {
+				tm = wallClockIn(tm, loc)
+				basis = TimeZoned
+				break
+			}
+		}
+	}
+	return &tm, basis
+}
+
+// exifXMP returns the XMP packet embedded in a TIFF-based file's IFD0, if any.
+func exifXMP(x exifTags) []byte {
+	tag, err := x.Get(exifXMLPacket)
+	if err != nil || len(tag.Val) == 0 {
+		return nil
+	}
+	return tag.Val
+}
+
+// exifString returns the trimmed string value of an ASCII tag.
+func exifString(x exifTags, name exif.FieldName) (string, bool) {
+	tag, err := x.Get(name)
+	if err != nil {
+		return "", false
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return "", false
+	}
+	s = strings.Trim(s, " \x00")
+	return s, s != ""
+}
+
+// parseSubSec parses an EXIF SubSecTime value: the decimal digits following
+// the seconds ("12" is 0.12s), possibly padded with spaces or NULs.
+func parseSubSec(s string) (time.Duration, bool) {
+	s = strings.Trim(s, " \x00")
+	if s == "" || len(s) > 9 {
+		return 0, false
+	}
+	ns := 0
+	for i := 0; i < 9; i++ {
+		ns *= 10
+		if i >= len(s) {
+			continue
+		}
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+		ns += int(s[i] - '0')
+	}
+	return time.Duration(ns), true
+}
@@ -0,0 +1,207 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSubSec(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"5", 500 * time.Millisecond, true},
+		{"12", 120 * time.Millisecond, true},
+		{"045", 45 * time.Millisecond, true},
+		{"123456", 123456 * time.Microsecond, true},
+		{"12\x00 ", 120 * time.Millisecond, true},
+		{"", 0, false},
+		{"1a", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSubSec(tt.in)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseSubSec(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestExtractImageMetadataTakenTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		ifd0      []tiffField // after Make
+		exifIFD   []tiffField
+		wantBasis TimeBasis
+		want      string
+	}{
+		{
+			name:      "without offset",
+			exifIFD:   []tiffField{asciiField(0x9003, "2024:01:15 14:30:45")},
+			wantBasis: TimeFloating,
+			want:      "2024-01-15 14:30:45.000 +0000",
+		},
+		{
+			name: "with SubSecTimeOriginal",
+			exifIFD: []tiffField{
+				asciiField(0x9003, "2024:01:15 14:30:45"),
+				asciiField(0x9291, "07"),
+			},
+			wantBasis: TimeFloating,
+			want:      "2024-01-15 14:30:45.070 +0000",
+		},
+		{
+			name: "with the modification date's companions for the same date",
+			exifIFD: []tiffField{
+				asciiField(0x9003, "2024:01:15 14:30:45"),
+				asciiField(0x9010, "+02:00"),
+				asciiField(0x9290, "5"),
+			},
+			ifd0:      []tiffField{asciiField(0x0132, "2024:01:15 14:30:45")},
+			wantBasis: TimeZoned,
+			want:      "2024-01-15 14:30:45.500 +0200",
+		},
+		{
+			name: "with the modification date's companions for another date",
+			exifIFD: []tiffField{
+				asciiField(0x9003, "2024:01:15 14:30:45"),
+				asciiField(0x9010, "+02:00"),
+				asciiField(0x9290, "5"),
+			},
+			ifd0:      []tiffField{asciiField(0x0132, "2024:03:01 09:00:00")},
+			wantBasis: TimeFloating,
+			want:      "2024-01-15 14:30:45.000 +0000",
+		},
+		{
+			name: "with OffsetTimeOriginal",
+			exifIFD: []tiffField{
+				asciiField(0x9003, "2024:01:15 14:30:45"),
+				asciiField(0x9011, "-05:00"),
+			},
+			wantBasis: TimeZoned,
+			want:      "2024-01-15 14:30:45.000 -0500",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.tif")
+			mustWrite(t, path, string(buildTIFF(append([]tiffField{asciiField(0x010f, "Acme")}, tt.ifd0...), tt.exifIFD)))
+			meta, err := extractImageMetadata(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.TakenTime == nil {
+				t.Fatal("no taken time extracted")
+			}
+			if got := meta.TakenTime.Format("2006-01-02 15:04:05.000 -0700"); got != tt.want {
+				t.Errorf("TakenTime = %s; want %s", got, tt.want)
+			}
+			if meta.TakenBasis != tt.wantBasis {
+				t.Errorf("TakenBasis = %v; want %v", meta.TakenBasis, tt.wantBasis)
+			}
+		})
+	}
+}
+
+func TestExtractImageMetadataDates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edited.tif")
+	mustWrite(t, path, string(buildTIFF(
+		[]tiffField{asciiField(0x0132, "2024:03:01 09:00:00")},
+		[]tiffField{
+			asciiField(0x9003, "2024:01:15 14:30:45"),
+			asciiField(0x9004, "2024:01:20 08:00:00"),
+			asciiField(0x9010, "+01:00"),
+			asciiField(0x9012, "-03:00"),
+		},
+	)))
+	meta, err := extractImageMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const layout = "2006-01-02 15:04:05 -0700"
+	for _, tt := range []struct {
+		name string
+		tm   *time.Time
+		want string
+	}{
+		// OffsetTime describes DateTime, a different date here, so it
+		// doesn't apply: the taken time stays floating.
+		{"TakenTime", meta.TakenTime, "2024-01-15 14:30:45 +0000"},
+		{"DigitizedTime", meta.DigitizedTime, "2024-01-20 08:00:00 -0300"},
+		{"ModifiedTime", meta.ModifiedTime, "2024-03-01 09:00:00 +0100"},
+	} {
+		if tt.tm == nil {
+			t.Errorf("%s not extracted", tt.name)
+			continue
+		}
+		if got := tt.tm.Format(layout); got != tt.want {
+			t.Errorf("%s = %s; want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// tiffField is one IFD entry for buildTIFF.
+type tiffField struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func asciiField(tag uint16, s string) tiffField {
+	return tiffField{tag: tag, typ: 2, count: uint32(len(s) + 1), data: append([]byte(s), 0)}
+}
+
+// buildTIFF returns a little-endian TIFF with the given IFD0 entries and, when
+// exifIFD is non-empty, an EXIF sub-IFD linked from IFD0. Entries must be
+// sorted by tag.
+func buildTIFF(ifd0, exifIFD []tiffField) []byte {
+	le := binary.LittleEndian
+	ifdSize := func(n int) int { return 2 + 12*n + 4 }
+
+	if len(exifIFD) > 0 {
+		ifd0 = append(append([]tiffField(nil), ifd0...), tiffField{tag: 0x8769, typ: 4, count: 1, data: make([]byte, 4)})
+	}
+	ifd0Off := 8
+	exifOff := ifd0Off + ifdSize(len(ifd0))
+	dataOff := exifOff
+	if len(exifIFD) > 0 {
+		dataOff += ifdSize(len(exifIFD))
+	}
+	if len(exifIFD) > 0 {
+		le.PutUint32(ifd0[len(ifd0)-1].data, uint32(exifOff))
+	}
+
+	var buf, data bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, le, uint32(ifd0Off))
+	writeIFD := func(fields []tiffField) {
+		binary.Write(&buf, le, uint16(len(fields)))
+		for _, f := range fields {
+			binary.Write(&buf, le, f.tag)
+			binary.Write(&buf, le, f.typ)
+			binary.Write(&buf, le, f.count)
+			if len(f.data) <= 4 {
+				v := make([]byte, 4)
+				copy(v, f.data)
+				buf.Write(v)
+				continue
+			}
+			binary.Write(&buf, le, uint32(dataOff+data.Len()))
+			data.Write(f.data)
+			if data.Len()%2 == 1 {
+				data.WriteByte(0)
+			}
+		}
+		binary.Write(&buf, le, uint32(0))
+	}
+	writeIFD(ifd0)
+	if len(exifIFD) > 0 {
+		writeIFD(exifIFD)
+	}
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
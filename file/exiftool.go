@@ -0,0 +1,205 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exiftool is a Perl program whose startup costs far more than reading one
+// file's tags, so instead of a process per file FileFerry keeps a few running
+// with -stay_open: each reads argument lists from its stdin ("-@ -"), one
+// argument per line, runs them when it reads "-executeN" and then prints
+// "{readyN}" after the output.
+
+// exiftoolTimeout bounds a single exiftool request. A process that doesn't
+// answer in time is killed and replaced.
+const exiftoolTimeout = 30 * time.Second
+
+var errExiftoolUnavailable = errors.New("exiftool: not installed")
+
+// exiftoolPool shares up to size exiftool processes between the iterator's
+// workers. Processes start on first use and are restarted after a crash or
+// timeout.
+type exiftoolPool struct {
+	name    string // executable, looked up in PATH
+	timeout time.Duration
+
+	lookOnce sync.Once
+	path     string
+	lookErr  error
+
+	idle  chan *exiftoolProc
+	slots chan struct{} // one per running process
+}
+
+func newExiftoolPool(name string, size int, timeout time.Duration) *exiftoolPool {
+	return &exiftoolPool{
+		name:    name,
+		timeout: timeout,
+		idle:    make(chan *exiftoolProc, size),
+		slots:   make(chan struct{}, size),
+	}
+}
+
+// exiftoolProcs is the pool metadata extraction uses.
+var exiftoolProcs = newExiftoolPool("exiftool", maxWorkers, exiftoolTimeout)
+
+// Run runs exiftool with args (one request, e.g. "-j", tags and a path) and
+// returns its output. A request whose process crashes is retried once on a
+// fresh process.
+func (p *exiftoolPool) Run(args ...string) ([]byte, error) {
+	p.lookOnce.Do(func() {
+		p.path, p.lookErr = exec.LookPath(p.name)
+	})
+	if p.lookErr != nil {
+		return nil, errExiftoolUnavailable
+	}
+	for _, a := range args {
+		if strings.ContainsAny(a, "\r\n") {
+			return nil, fmt.Errorf("exiftool: argument %q spans lines", a)
+		}
+	}
+
+	var err error
+	for range 2 {
+		var proc *exiftoolProc
+		if proc, err = p.get(); err != nil {
+			return nil, err
+		}
+		var out []byte
+		if out, err = proc.run(args, p.timeout); err == nil {
+			p.idle <- proc
+			return out, nil
+		}
+		proc.kill()
+		<-p.slots
+	}
+	return nil, err
+}
+
+// get returns an idle process, or starts one if fewer than size are running.
+func (p *exiftoolPool) get() (*exiftoolProc, error) {
+	select {
+	case proc := <-p.idle:
+		return proc, nil
+	default:
+	}
+	select {
+	case proc := <-p.idle:
+		return proc, nil
+	case p.slots <- struct{}{}:
+		proc, err := startExiftool(p.path)
+		if err != nil {
+			<-p.slots
+			return nil, err
+		}
+		return proc, nil
+	}
+}
+
+// Close stops the idle processes. The pool stays usable: later requests start
+// new ones.
+func (p *exiftoolPool) Close() {
+	for {
+		select {
+		case proc := <-p.idle:
+			proc.close()
+			<-p.slots
+		default:
+			return
+		}
+	}
+}
+
+// exiftoolProc is one running "exiftool -stay_open True -@ -".
+type exiftoolProc struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	out   *bufio.Reader
+	seq   int
+}
+
+func startExiftool(path string) (*exiftoolProc, error) {
+	cmd := exec.Command(path, "-stay_open", "True", "-@", "-")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("exiftool: %w", err)
+	}
+	return &exiftoolProc{cmd: cmd, stdin: stdin, out: bufio.NewReader(stdout)}, nil
+}
+
+// run sends one request and reads its output up to the "{readyN}" line. On
+// timeout the process is killed, which ends the pending read.
+func (e *exiftoolProc) run(args []string, timeout time.Duration) ([]byte, error) {
+	e.seq++
+	var req bytes.Buffer
+	for _, a := range args {
+		req.WriteString(a)
+		req.WriteByte('\n')
+	}
+	fmt.Fprintf(&req, "-execute%d\n", e.seq)
+	if _, err := e.stdin.Write(req.Bytes()); err != nil {
+		return nil, fmt.Errorf("exiftool: write request: %w", err)
+	}
+
+	type response struct {
+		out []byte
+		err error
+	}
+	done := make(chan response, 1)
+	go func() {
+		out, err := e.readResponse(fmt.Sprintf("{ready%d}", e.seq))
+		done <- response{out, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-timer.C:
+		e.kill()
+		<-done
+		return nil, fmt.Errorf("exiftool: no response in %s", timeout)
+	}
+}
+
+func (e *exiftoolProc) readResponse(ready string) ([]byte, error) {
+	var out []byte
+	for {
+		line, err := e.out.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("exiftool: read response: %w", err)
+		}
+		if string(bytes.TrimRight(line, "\r\n")) == ready {
+			return out, nil
+		}
+		out = append(out, line...)
+	}
+}
+
+// close asks the process to exit and waits for it.
+func (e *exiftoolProc) close() {
+	io.WriteString(e.stdin, "-stay_open\nFalse\n")
+	e.stdin.Close()
+	e.cmd.Wait()
+}
+
+func (e *exiftoolProc) kill() {
+	e.cmd.Process.Kill()
+	e.stdin.Close()
+	e.cmd.Wait()
+}
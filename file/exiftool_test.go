@@ -0,0 +1,141 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExiftool is a shell script speaking exiftool's -stay_open protocol. It
+// logs each start to $FAKE_EXIFTOOL_LOG, answers with canned JSON, crashes on
+// the first request for a path containing "crash" and hangs on "hang".
+const fakeExiftool = `#!/bin/sh
+echo start >> "$FAKE_EXIFTOOL_LOG"
+file=""
+while IFS= read -r line; do
+	case "$line" in
+	-execute*)
+		case "$file" in
+		*crash*)
+			if [ ! -e "$FAKE_EXIFTOOL_LOG.crashed" ]; then
+				touch "$FAKE_EXIFTOOL_LOG.crashed"
+				exit 1
+			fi ;;
+		*hang*) exec sleep 60 ;;
+		esac
+		printf '[{"SourceFile":"%s","Make":"Canon","Software":"Firmware 1.0.2","DateTimeOriginal":"2024:01:15 14:30:45","GPSLatitude":45.815,"GPSLongitude":-15.9819}]\n' "$file"
+		printf '{ready%s}\n' "${line#-execute}"
+		file="" ;;
+	False) exit 0 ;;
+	-*) ;;
+	*) file="$line" ;;
+	esac
+done
+`
+
+// newFakeExiftoolPool returns a pool running fakeExiftool and the path of
+// its start log.
+func newFakeExiftoolPool(t *testing.T, size int, timeout time.Duration) (*exiftoolPool, string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "exiftool")
+	if err := os.WriteFile(script, []byte(fakeExiftool), 0755); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "starts.log")
+	t.Setenv("FAKE_EXIFTOOL_LOG", log)
+	p := newExiftoolPool(script, size, timeout)
+	t.Cleanup(p.Close)
+	return p, log
+}
+
+func starts(t *testing.T, log string) int {
+	t.Helper()
+	b, _ := os.ReadFile(log)
+	return strings.Count(string(b), "start")
+}
+
+func TestExiftoolPool(t *testing.T) {
+	t.Run("reuses processes", func(t *testing.T) {
+		p, log := newFakeExiftoolPool(t, 2, 5*time.Second)
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				out, err := p.Run("-j", "-Make", filepath.Join("/photos", "IMG_"+string(rune('a'+i))+".CR2"))
+				if err != nil || !strings.Contains(string(out), `"Make":"Canon"`) {
+					t.Errorf("Run = %q, %v; want the canned JSON", out, err)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := starts(t, log); n < 1 || n > 2 {
+			t.Errorf("started %d processes for 20 requests; want at most the pool size, 2", n)
+		}
+	})
+
+	t.Run("restarts after a crash", func(t *testing.T) {
+		p, log := newFakeExiftoolPool(t, 1, 5*time.Second)
+		if _, err := p.Run("-j", "/photos/crash.CR2"); err != nil {
+			t.Fatalf("Run: %v; want the request retried on a new process", err)
+		}
+		if _, err := p.Run("-j", "/photos/next.CR2"); err != nil {
+			t.Fatalf("Run after restart: %v", err)
+		}
+		if n := starts(t, log); n != 2 {
+			t.Errorf("started %d processes; want 2", n)
+		}
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		p, _ := newFakeExiftoolPool(t, 1, 200*time.Millisecond)
+		start := time.Now()
+		if _, err := p.Run("-j", "/photos/hang.CR2"); err == nil {
+			t.Fatal("Run: want a timeout error")
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("Run took %s; want it cut off by the timeout", d)
+		}
+		if _, err := p.Run("-j", "/photos/next.CR2"); err != nil {
+			t.Errorf("Run after timeout: %v", err)
+		}
+	})
+
+	t.Run("rejects multi-line arguments", func(t *testing.T) {
+		p, _ := newFakeExiftoolPool(t, 1, 5*time.Second)
+		if _, err := p.Run("-j", "/photos/a\n-execute.CR2"); err == nil {
+			t.Error("Run: want an error for an argument with a newline")
+		}
+	})
+
+	t.Run("not installed", func(t *testing.T) {
+		p := newExiftoolPool("fileferry-no-such-exiftool", 1, time.Second)
+		if _, err := p.Run("-j", "/photos/a.CR2"); err != errExiftoolUnavailable {
+			t.Errorf("Run = %v; want errExiftoolUnavailable", err)
+		}
+	})
+}
+
+func TestExtractImageMetadataWithExiftoolPool(t *testing.T) {
+	p, _ := newFakeExiftoolPool(t, 1, 5*time.Second)
+	defer func(orig *exiftoolPool) { exiftoolProcs = orig }(exiftoolProcs)
+	exiftoolProcs = p
+
+	meta := extractImageMetadataWithExiftool("/photos/IMG_0001.CR2")
+	if meta == nil {
+		t.Fatal("extractImageMetadataWithExiftool = nil")
+	}
+	if want := time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC); meta.TakenTime == nil || !meta.TakenTime.Equal(want) {
+		t.Errorf("TakenTime = %v; want %v", meta.TakenTime, want)
+	}
+	if meta.CameraMaker != "Canon" || meta.Software != "Firmware 1.0.2" {
+		t.Errorf("maker, software = %q, %q; want Canon, Firmware 1.0.2", meta.CameraMaker, meta.Software)
+	}
+	if meta.Location == nil || *meta.Location != (GeoLocation{Latitude: 45.815, Longitude: -15.9819}) {
+		t.Errorf("Location = %+v; want 45.815, -15.9819", meta.Location)
+	}
+}
@@ -0,0 +1,160 @@
+package file
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Extractor reads metadata from a file's content. FileFerry registers its
+// own for images and videos with DefaultExtractors; library users register
+// more for formats it doesn't read, or to read one differently.
+type Extractor interface {
+	// Supports reports whether Extract applies to the entry, typically by
+	// its extension. It must not read the content.
+	Supports(e Entry) bool
+	// Extract returns the metadata the entry records as far as this
+	// extractor knows, nil if none. Fields it can't read are left zero for
+	// lower-priority extractors to fill. Fields whose Provenance it doesn't
+	// set are attributed to the name it was registered under.
+	Extract(e Entry) (*FileMetadata, error)
+}
+
+// ExtractorWarning is the error an Extractor returns for a failure that
+// shouldn't fail the file, such as an external program crashing: the
+// registry reports it and carries on as if the extractor found nothing.
+type ExtractorWarning struct {
+	Err error
+}
+
+func (w *ExtractorWarning) Error() string { return w.Err.Error() }
+func (w *ExtractorWarning) Unwrap() error { return w.Err }
+
+// PriorityBuiltin is the priority of FileFerry's own extractors. Register an
+// extractor above it to override what they read, below it to fill only what
+// they leave empty.
+const PriorityBuiltin = 0
+
+// ExtractorRegistry runs the extractors registered with it in priority order.
+type ExtractorRegistry struct {
+	mu         sync.RWMutex
+	extractors []registeredExtractor
+}
+
+type registeredExtractor struct {
+	name     string
+	priority int
+	ex       Extractor
+}
+
+// DefaultExtractors is the registry metadata is read through, together with
+// the external extractors of the config (see extractorsFor).
+var DefaultExtractors = &ExtractorRegistry{}
+
+func init() {
+	DefaultExtractors.Register("image", PriorityBuiltin, imageExtractor{})
+	DefaultExtractors.Register("video", PriorityBuiltin, videoExtractor{})
+}
+
+// Register adds ex under name. Higher priorities run first; equal ones run in
+// registration order. Registering a name again replaces its extractor.
+func (r *ExtractorRegistry) Register(name string, priority int, ex Extractor) {
+	r.Unregister(name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.extractors = append(r.extractors, registeredExtractor{name: name, priority: priority, ex: ex})
+	slices.SortStableFunc(r.extractors, func(a, b registeredExtractor) int { return cmp.Compare(b.priority, a.priority) })
+}
+
+// clone returns a registry with the same extractors, to register more in
+// without affecting r.
+func (r *ExtractorRegistry) clone() *ExtractorRegistry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return &ExtractorRegistry{extractors: slices.Clone(r.extractors)}
+}
+
+// Unregister removes the extractor registered under name, if any.
+func (r *ExtractorRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.extractors = slices.DeleteFunc(r.extractors, func(re registeredExtractor) bool { return re.name == name })
+}
+
+// Extract runs every extractor supporting e, highest priority first, and
+// merges their results with fillMissing: each fills only the fields the ones
+// before it left empty. It returns nil when no extractor supports e or none
+// found anything, and stops at the first error other than an
+// ExtractorWarning; warnings are dropped. Extension defaults to the entry's.
+func (r *ExtractorRegistry) Extract(e Entry) (*FileMetadata, error) {
+	meta, _, err := r.extract(e)
+	return meta, err
+}
+
+// extract is Extract, also returning the extractors' warnings.
+func (r *ExtractorRegistry) extract(e Entry) (*FileMetadata, []error, error) {
+	r.mu.RLock()
+	extractors := slices.Clone(r.extractors)
+	r.mu.RUnlock()
+
+	var merged *FileMetadata
+	var warnings []error
+	for _, re := range extractors {
+		if !re.ex.Supports(e) {
+			continue
+		}
+		meta, err := re.ex.Extract(e)
+		var w *ExtractorWarning
+		if errors.As(err, &w) {
+			warnings = append(warnings, fmt.Errorf("%s extractor: %w", re.name, w.Err))
+			continue
+		}
+		if err != nil {
+			return nil, warnings, fmt.Errorf("%s extractor: %w", re.name, err)
+		}
+		if meta == nil {
+			continue
+		}
+		meta.attribute(re.name)
+		if merged == nil {
+			merged = meta
+			continue
+		}
+		fillMissing(merged, meta)
+		if merged.Extension == "" {
+			merged.Extension = meta.Extension
+		}
+		if merged.embeddedXMP == nil {
+			merged.embeddedXMP = meta.embeddedXMP
+		}
+	}
+	if merged != nil && merged.Extension == "" {
+		merged.Extension = normalizeExt(filepath.Ext(e.Name()))
+	}
+	return merged, warnings, nil
+}
+
+// imageExtractor reads EXIF (and embedded XMP) from images, RAW included.
+type imageExtractor struct{}
+
+func (imageExtractor) Supports(e Entry) bool {
+	return isFileType(e.Name(), []string{"image", "image.raw"})
+}
+
+func (imageExtractor) Extract(e Entry) (*FileMetadata, error) {
+	return extractImageMetadataFromEntry(e)
+}
+
+// videoExtractor reads video container metadata.
+type videoExtractor struct{}
+
+func (videoExtractor) Supports(e Entry) bool {
+	return isFileType(e.Name(), []string{"video"})
+}
+
+func (videoExtractor) Extract(e Entry) (*FileMetadata, error) {
+	return extractVideoMetadataFromEntry(e)
+}
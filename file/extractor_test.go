@@ -0,0 +1,138 @@
+package file
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+// stubExtractor supports entries with the given extension and returns a copy
+// of meta for them.
+type stubExtractor struct {
+	ext  string
+	meta FileMetadata
+	err  error
+}
+
+func (s stubExtractor) Supports(e Entry) bool {
+	return strings.EqualFold(filepath.Ext(e.Name()), s.ext)
+}
+
+func (s stubExtractor) Extract(e Entry) (*FileMetadata, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	m := s.meta
+	return &m, nil
+}
+
+func TestExtractorRegistry(t *testing.T) {
+	taken := time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC)
+	later := taken.Add(time.Hour)
+	e := &fakeEntry{name: "clip.xyz", bodies: [][]byte{nil}}
+
+	r := &ExtractorRegistry{}
+	r.Register("low", -1, stubExtractor{ext: ".xyz", meta: FileMetadata{TakenTime: &later, CameraMaker: "Low", Software: "low"}})
+	r.Register("high", 10, stubExtractor{ext: ".xyz", meta: FileMetadata{TakenTime: &taken, CameraMaker: "High", Extension: "xyz"}})
+	r.Register("other", 20, stubExtractor{ext: ".abc", meta: FileMetadata{CameraModel: "Other"}})
+
+	meta, err := r.Extract(e)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if !meta.TakenTime.Equal(taken) || meta.CameraMaker != "High" || meta.Software != "low" || meta.CameraModel != "" {
+		t.Errorf("merged = %v %q %q %q; want the high-priority time and maker, the low-priority software", meta.TakenTime, meta.CameraMaker, meta.Software, meta.CameraModel)
+	}
+	want := map[string]string{"taken": "high", "camera.maker": "high", "software": "low"}
+	for field, source := range want {
+		if meta.Provenance[field] != source {
+			t.Errorf("Provenance[%s] = %q; want %q", field, meta.Provenance[field], source)
+		}
+	}
+
+	// Registering a name again replaces its extractor.
+	r.Register("high", 10, stubExtractor{ext: ".xyz", meta: FileMetadata{CameraMaker: "Replaced"}})
+	if meta, _ := r.Extract(e); meta.CameraMaker != "Replaced" || !meta.TakenTime.Equal(later) {
+		t.Errorf("after re-registering: %q %v; want Replaced and the low-priority time", meta.CameraMaker, meta.TakenTime)
+	}
+
+	r.Unregister("high")
+	r.Unregister("low")
+	if meta, err := r.Extract(e); meta != nil || err != nil {
+		t.Errorf("no supporting extractor: %+v, %v; want nil, nil", meta, err)
+	}
+
+	r.Register("broken", 0, stubExtractor{ext: ".xyz", err: errors.New("boom")})
+	if _, err := r.Extract(e); err == nil || !strings.Contains(err.Error(), "broken extractor") {
+		t.Errorf("Extract error = %v; want it to name the broken extractor", err)
+	}
+}
+
+func TestExtractorRegistryExtremePriorities(t *testing.T) {
+	r := &ExtractorRegistry{}
+	r.Register("lowest", math.MinInt, stubExtractor{})
+	r.Register("high", 10, stubExtractor{})
+	r.Register("highest", math.MaxInt, stubExtractor{})
+	r.Register("low", -10, stubExtractor{})
+
+	var got []string
+	for _, re := range r.extractors {
+		got = append(got, re.name)
+	}
+	if want := []string{"highest", "high", "low", "lowest"}; !slices.Equal(got, want) {
+		t.Errorf("order = %v; want %v", got, want)
+	}
+}
+
+func TestFileIteratorCustomExtractor(t *testing.T) {
+	taken := time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC)
+	DefaultExtractors.Register("test.xyz", PriorityBuiltin, stubExtractor{ext: ".xyz", meta: FileMetadata{TakenTime: &taken}})
+	defer DefaultExtractors.Unregister("test.xyz")
+
+	tmpDir := t.TempDir()
+	mustWrite(t, filepath.Join(tmpDir, "scan.xyz"), "data")
+	cfg := &ffcfg.Config{
+		Profiles: map[string]ffcfg.ProfileConfig{
+			"test": {
+				Sources:  []ffcfg.SourceConfig{{Path: tmpDir, Types: []string{".xyz"}}},
+				Target:   ffcfg.TargetPathConfig{Path: "/target/{meta.taken.date}.{file.extension}"},
+				Timezone: "UTC",
+			},
+		},
+	}
+	var got []File
+	for f := range FileIterator(cfg) {
+		got = append(got, f)
+	}
+	if len(got) != 1 || got[0].Error != nil {
+		t.Fatalf("files = %+v; want one without error", got)
+	}
+	if want := filepath.Join("/target", "2024-01-15.xyz"); got[0].NewPath != want {
+		t.Errorf("NewPath = %q; want %q", got[0].NewPath, want)
+	}
+	if src := got[0].Metadata.Provenance["taken"]; src != "test.xyz" {
+		t.Errorf("Provenance[taken] = %q; want test.xyz", src)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	taken := time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC)
+	digitized := taken.Add(time.Minute)
+	meta := &FileMetadata{DigitizedTime: &digitized, CameraMaker: "Canon"}
+	meta.attribute("image")
+	fillMissing(meta, &FileMetadata{TakenTime: &taken, Provenance: map[string]string{"taken": "filename"}})
+	if meta.Provenance["taken"] != "filename" || meta.Provenance["camera.maker"] != "image" {
+		t.Errorf("Provenance = %v; want taken from filename, camera.maker from image", meta.Provenance)
+	}
+
+	resolved := takenByPrecedence(&FileMetadata{DigitizedTime: &digitized, Provenance: map[string]string{"digitized": "exiftool"}}, nil)
+	if resolved.Provenance["taken"] != "exiftool" {
+		t.Errorf("taken from digitized: Provenance[taken] = %q; want exiftool", resolved.Provenance["taken"])
+	}
+}
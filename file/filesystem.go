@@ -2,32 +2,307 @@ package file
 
 import (
 	"errors"
+	"fmt"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
 )
 
 var tokenPattern = regexp.MustCompile(`\{[^}]+\}`)
 
-func resolveTargetPath(tmpl string, meta *FileMetadata) (string, error) {
+// sourceFile describes the original file a target path is rendered for. It
+// backs the {file.name}, {file.stem}, {file.extension:original},
+// {file.mtime.*} and {source.*} tokens; with a nil *sourceFile those tokens
+// stay unpopulated.
+type sourceFile struct {
+	Name    string // original base filename, e.g. "IMG_1234.JPG"
+	Source  string // source label, see config.SourceConfig.Label
+	RelPath string // path relative to the source root, "/"-separated
+	// Hashes holds hex content digests by algorithm, computed only for the
+	// algorithms the target template refers to (see hashTokenAlgorithms).
+	Hashes map[string]string
+	// ModTime is the file's modification time in the profile's timezone,
+	// nil if the source doesn't know it.
+	ModTime *time.Time
+}
+
+// hashTokenPattern matches {file.hash}, {file.hash.<algo>} and their
+// {…:<length>} forms, capturing the algorithm.
+var hashTokenPattern = regexp.MustCompile(`\{file\.hash(?:\.([a-z0-9]+))?(?::[^}]*)?\}`)
+
+// hashTokenAlgorithms returns the supported hash algorithms referenced by the
+// template's {file.hash…} tokens, so content is only hashed when needed.
+func hashTokenAlgorithms(tmpl string) []string {
+	var algos []string
+	seen := make(map[string]bool)
+	for _, m := range hashTokenPattern.FindAllStringSubmatch(tmpl, -1) {
+		algo := m[1]
+		if algo == "" {
+			algo = "sha256"
+		}
+		if _, ok := hashAlgorithms[algo]; ok && !seen[algo] {
+			seen[algo] = true
+			algos = append(algos, algo)
+		}
+	}
+	return algos
+}
+
+// newSourceFile describes entry as found under src.
+func newSourceFile(entry Entry, src ffcfg.SourceConfig) *sourceFile {
+	sf := &sourceFile{Name: entry.Name(), Source: src.Label(), RelPath: entry.Name()}
+	if rp, ok := entry.(relPathProvider); ok {
+		sf.RelPath = rp.RelPath()
+	}
+	return sf
+}
+
+// pathOptions are the per-profile settings that shape a rendered target path.
+// The zero value applies the defaults.
+type pathOptions struct {
+	// sanitize are the target filesystem's rules; nil means the platform's
+	// (see defaultSanitizeRules).
+	sanitize *sanitizeRules
+	// normalize controls separator normalization.
+	normalize normalizeRules
+	// loc is the profile's timezone; nil means the machine's.
+	loc *time.Location
+	// takenOrder is the profile's taken_precedence, nil for the default.
+	takenOrder []string
+	// dateSources is the profile's date_sources, nil for the default.
+	dateSources []string
+	// containerLocal declares the source's container UTC times to be local
+	// wall-clock readings (see anchorTimes).
+	containerLocal bool
+}
+
+// location returns the timezone floating and container times are read in.
+func (o pathOptions) location() *time.Location {
+	if o.loc == nil {
+		return time.Local
+	}
+	return o.loc
+}
+
+// newPathOptions returns the path options configured for a profile.
+func newPathOptions(prof ffcfg.ProfileConfig) pathOptions {
+	norm := prof.Target.Normalize
+	var loc *time.Location
+	if prof.Timezone != "" {
+		// The config loader validated the name, so this can't fail.
+		loc, _ = time.LoadLocation(prof.Timezone)
+	}
+	return pathOptions{
+		loc:         loc,
+		takenOrder:  prof.TakenPrecedence,
+		dateSources: prof.DateSources,
+		sanitize:    sanitizeProfiles[prof.Target.Sanitize],
+		normalize: normalizeRules{
+			disabled:    norm.Enabled != nil && !*norm.Enabled,
+			separators:  norm.Separators,
+			directories: norm.Directories,
+		},
+	}
+}
+
+// resolveTargetPath renders a target template. Every {name} or {name:spec}
+// token with a known value is replaced; unknown tokens and tokens whose value
+// is unavailable (e.g. {meta.taken.year} without a taken time) are left as-is
+// so hasUnpopulatedTokens can flag the result.
+//
+// Token values are sanitized for the target filesystem before they are
+// inserted, so a value can't add path components; separators written in the
+// template itself are kept.
+func resolveTargetPath(tmpl string, meta *FileMetadata, sf *sourceFile, opts pathOptions) (string, error) {
 	if meta == nil {
 		return "", errors.New("no metadata")
 	}
-	path := tmpl
-	if meta.TakenTime != nil {
-		localTime := meta.TakenTime.Local()
-		path = strings.ReplaceAll(path, "{meta.taken.year}", localTime.Format("2006"))
-		path = strings.ReplaceAll(path, "{meta.taken.date}", localTime.Format("2006-01-02"))
-		path = strings.ReplaceAll(path, "{meta.taken.datetime}", localTime.Format("2006-01-02-15-04-05"))
+	rules := opts.sanitize
+	if rules == nil {
+		rules = defaultSanitizeRules()
 	}
-	path = strings.ReplaceAll(path, "{file.extension}", (meta.Extension))
-	path = strings.ReplaceAll(path, "{meta.camera.maker}", (meta.CameraMaker))
-	path = strings.ReplaceAll(path, "{meta.camera.model}", (meta.CameraModel))
+	path := tokenPattern.ReplaceAllStringFunc(tmpl, func(tok string) string {
+		name, spec, _ := strings.Cut(tok[1:len(tok)-1], ":")
+		v, ok := tokenValue(name, spec, meta, sf)
+		if !ok {
+			return tok
+		}
+		if name == "source.relpath" || name == "source.reldir" {
+			return rules.pathValue(v)
+		}
+		return rules.value(v)
+	})
 
-	path = normalizeSeparators(path)
+	path = opts.normalize.apply(path)
+	path = rules.components(path)
 	return path, nil
 }
 
+// tokenValue returns the value of a single template token, and whether it
+// could be populated.
+func tokenValue(name, spec string, meta *FileMetadata, sf *sourceFile) (string, bool) {
+	if kind, field, ok := timeTokenName(name); ok {
+		tm := meta.timeOf(kind)
+		if tm == nil {
+			return "", false
+		}
+		return timeTokenValue(field, spec, *tm)
+	}
+	if field, ok := strings.CutPrefix(name, "file.mtime."); ok {
+		if sf == nil || sf.ModTime == nil {
+			return "", false
+		}
+		return timeTokenValue(field, spec, *sf.ModTime)
+	}
+	if key, ok := strings.CutPrefix(name, "meta.custom."); ok {
+		v, found := meta.Custom[key]
+		return v, found && spec == ""
+	}
+	switch name {
+	case "meta.camera.maker":
+		return meta.CameraMaker, spec == ""
+	case "meta.camera.model":
+		return meta.CameraModel, spec == ""
+	case "meta.software":
+		return meta.Software, spec == ""
+	case "meta.gps.lat", "meta.gps.lon":
+		if meta.Location == nil || spec != "" {
+			return "", false
+		}
+		v := meta.Location.Latitude
+		if name == "meta.gps.lon" {
+			v = meta.Location.Longitude
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case "meta.rating":
+		if meta.Rating == nil || spec != "" {
+			return "", false
+		}
+		return strconv.Itoa(*meta.Rating), true
+	case "meta.keywords":
+		if len(meta.Keywords) == 0 {
+			return "", false
+		}
+		switch spec {
+		case "":
+			return strings.Join(meta.Keywords, ","), true
+		case "first":
+			return meta.Keywords[0], true
+		}
+		return "", false
+	case "file.extension":
+		switch spec {
+		case "":
+			return meta.Extension, true
+		case "original":
+			if sf == nil {
+				return "", false
+			}
+			return strings.TrimPrefix(filepath.Ext(sf.Name), "."), true
+		}
+	}
+
+	if sf == nil {
+		return "", false
+	}
+	if name == "file.hash" || strings.HasPrefix(name, "file.hash.") {
+		algo := strings.TrimPrefix(strings.TrimPrefix(name, "file.hash"), ".")
+		if algo == "" {
+			algo = "sha256"
+		}
+		sum, ok := sf.Hashes[algo]
+		if !ok {
+			return "", false
+		}
+		if spec == "" {
+			return sum, true
+		}
+		n, err := strconv.Atoi(spec)
+		if err != nil || n <= 0 {
+			return "", false
+		}
+		return sum[:min(n, len(sum))], true
+	}
+	if spec != "" {
+		return "", false
+	}
+	switch name {
+	case "file.name":
+		return sf.Name, true
+	case "file.stem":
+		return strings.TrimSuffix(sf.Name, filepath.Ext(sf.Name)), true
+	case "source.name":
+		return sf.Source, true
+	case "source.relpath":
+		return filepath.FromSlash(sf.RelPath), true
+	case "source.reldir":
+		dir := path.Dir(sf.RelPath)
+		if dir == "." {
+			return "", true
+		}
+		return filepath.FromSlash(dir), true
+	}
+	return "", false
+}
+
+// timeTokenName splits a time token name such as "meta.digitized.date" into
+// the time it refers to ("taken", "digitized", "modified") and its field.
+func timeTokenName(name string) (kind, field string, ok bool) {
+	rest, ok := strings.CutPrefix(name, "meta.")
+	if !ok {
+		return "", "", false
+	}
+	kind, field, ok = strings.Cut(rest, ".")
+	if !ok {
+		return "", "", false
+	}
+	switch kind {
+	case "taken", "digitized", "modified":
+		return kind, field, true
+	}
+	return "", "", false
+}
+
+// timeTokenValue renders one field of a time token ("year", "date",
+// "datetime", "ms", "tz") in t's own location; see anchorTimes for how that
+// location is chosen.
+func timeTokenValue(field, spec string, t time.Time) (string, bool) {
+	ms := fmt.Sprintf("%03d", t.Nanosecond()/int(time.Millisecond))
+	switch field {
+	case "datetime":
+		if spec == "ms" {
+			return t.Format("2006-01-02-15-04-05") + "-" + ms, true
+		}
+	case "tz":
+		switch spec {
+		case "":
+			return t.Format("-0700"), true
+		case "name":
+			return t.Format("MST"), true
+		}
+		return "", false
+	}
+	if spec != "" {
+		return "", false
+	}
+	switch field {
+	case "year":
+		return t.Format("2006"), true
+	case "date":
+		return t.Format("2006-01-02"), true
+	case "datetime":
+		return t.Format("2006-01-02-15-04-05"), true
+	case "ms":
+		return ms, true
+	}
+	return "", false
+}
+
 // hasUnpopulatedTokens checks if a path still contains unpopulated template tokens
 // It looks for patterns like {token.name} where braces are properly paired.
 // Note: This intentionally matches any {*} pattern, not just known template tokens,
@@ -37,21 +312,81 @@ func hasUnpopulatedTokens(path string) bool {
 	return tokenPattern.MatchString(path)
 }
 
+// normalizeRules control how separators in a rendered path are tidied up,
+// typically where tokens rendered empty (e.g. "{meta.camera.maker}_{seq}" with
+// no maker). The zero value is the default: collapse runs of "-" and "_" in the
+// file's name stem and trim them from its ends.
+type normalizeRules struct {
+	// disabled leaves names as rendered. Empty path segments are still
+	// collapsed ("/out//x.jpg" becomes "/out/x.jpg").
+	disabled bool
+	// separators are the characters collapsed and trimmed; empty means "-_".
+	separators string
+	// directories applies the rules to directory names too. A directory name
+	// that ends up empty is dropped.
+	directories bool
+}
+
+// defaultSeparators are collapsed when normalizeRules.separators is empty.
+const defaultSeparators = "-_"
+
+// normalizeSeparators applies the default normalizeRules to path.
 func normalizeSeparators(path string) string {
+	return normalizeRules{}.apply(path)
+}
+
+func (n normalizeRules) apply(path string) string {
 	dir := filepath.Dir(path)
 	base := filepath.Base(path)
-	ext := filepath.Ext(base)
-	name := strings.TrimSuffix(base, ext)
-	name = collapseSeparators(name, "-")
-	name = collapseSeparators(name, "_")
-
-	name = strings.Trim(name, "-_ ")
+	if !n.disabled {
+		ext := filepath.Ext(base)
+		base = n.name(strings.TrimSuffix(base, ext)) + ext
+		if n.directories {
+			dir = n.dirs(dir)
+		}
+	}
 
-	newBase := name + ext
 	if dir == "." || dir == "" {
-		return newBase
+		return base
+	}
+	return filepath.Join(dir, base)
+}
+
+// name collapses repeated separators in a single path element and trims them
+// (and spaces) from its ends.
+func (n normalizeRules) name(s string) string {
+	seps := n.separators
+	if seps == "" {
+		seps = defaultSeparators
+	}
+	for _, sep := range seps {
+		s = collapseSeparators(s, string(sep))
+	}
+	return strings.Trim(s, seps+" ")
+}
+
+// dirs normalizes every element of a directory path, dropping elements that
+// end up empty. The volume name and root are kept.
+func (n normalizeRules) dirs(dir string) string {
+	vol := filepath.VolumeName(dir)
+	parts := strings.Split(dir[len(vol):], string(filepath.Separator))
+	kept := parts[:0]
+	for i, p := range parts {
+		if i == 0 && p == "" {
+			kept = append(kept, p) // root
+			continue
+		}
+		if p != "." && p != ".." {
+			p = n.name(p)
+		}
+		if p != "" {
+			kept = append(kept, p)
+		}
 	}
-	return filepath.Join(dir, newBase)
+	if len(kept) == 1 && kept[0] == "" {
+		return vol + string(filepath.Separator)
+	}
+	return vol + strings.Join(kept, string(filepath.Separator))
 }
 
 func collapseSeparators(s, sep string) string {
@@ -72,6 +407,7 @@ var DefaultFileTypes = &FileTypeRegistry{
 		"image": {
 			// Standard image formats
 			".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".webp",
+			".heic", ".heif", ".avif", // HEIF containers (iPhone, modern Android)
 		},
 		"image.raw": {
 			// RAW image formats
@@ -89,6 +425,8 @@ var DefaultFileTypes = &FileTypeRegistry{
 		},
 		"video": {
 			".mp4", ".mov", ".avi", ".mkv", ".webm", ".flv", ".wmv",
+			".3gp", ".3g2", // 3GPP (older phones), an MP4 dialect
+			".mts", ".m2ts", // AVCHD camcorders
 		},
 	},
 }
@@ -97,10 +435,14 @@ func isFileType(path string, types []string) bool {
 	return DefaultFileTypes.IsFileType(path, types)
 }
 
-// IsFileType checks if a file matches any of the specified types using this registry
+// IsFileType checks if a file matches any of the specified types using this
+// registry. A type starting with "." matches that extension itself.
 func (r *FileTypeRegistry) IsFileType(path string, types []string) bool {
 	ext := strings.ToLower(filepath.Ext(path))
 	for _, t := range types {
+		if strings.HasPrefix(t, ".") && ext == strings.ToLower(t) {
+			return true
+		}
 		if extensions, exists := r.Categories[t]; exists {
 			for _, e := range extensions {
 				if ext == e {
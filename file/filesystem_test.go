@@ -2,6 +2,7 @@ package file
 
 import (
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -119,6 +120,67 @@ func TestNormalizeSeparators(t *testing.T) {
 	}
 }
 
+func TestNormalizeRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    normalizeRules
+		input    string
+		expected string
+	}{
+		{
+			name:     "disabled keeps double underscores",
+			rules:    normalizeRules{disabled: true},
+			input:    "/out/__MACOSX__photo.jpg",
+			expected: filepath.Join("/out", "__MACOSX__photo.jpg"),
+		},
+		{
+			name:     "disabled still collapses empty segments",
+			rules:    normalizeRules{disabled: true},
+			input:    "/out//x.jpg",
+			expected: filepath.Join("/out", "x.jpg"),
+		},
+		{
+			name:     "custom separator set",
+			rules:    normalizeRules{separators: "-"},
+			input:    "/out/--a__b--.jpg",
+			expected: filepath.Join("/out", "a__b.jpg"),
+		},
+		{
+			name:     "directories untouched by default",
+			rules:    normalizeRules{},
+			input:    "/out/Sony__/a--b.jpg",
+			expected: filepath.Join("/out", "Sony__", "a-b.jpg"),
+		},
+		{
+			name:     "directories normalized",
+			rules:    normalizeRules{directories: true},
+			input:    "/out/_Sony__A7/a--b.jpg",
+			expected: filepath.Join("/out", "Sony_A7", "a-b.jpg"),
+		},
+		{
+			name:     "directory left empty is dropped",
+			rules:    normalizeRules{directories: true},
+			input:    "/out/_/2024/x.jpg",
+			expected: filepath.Join("/out", "2024", "x.jpg"),
+		},
+		{
+			name:     "relative directories normalized",
+			rules:    normalizeRules{directories: true, separators: "_"},
+			input:    "a__b/c--d/x.jpg",
+			expected: filepath.Join("a_b", "c--d", "x.jpg"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.rules.apply(filepath.FromSlash(tt.input))
+			if result != tt.expected {
+				t.Errorf("apply(%q) = %q; want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestFileTypeRegistry_IsFileType(t *testing.T) {
 	registry := &FileTypeRegistry{
 		Categories: map[string][]string{
@@ -236,6 +298,18 @@ func TestIsFileType(t *testing.T) {
 			types:    []string{"image", "video"},
 			expected: true,
 		},
+		{
+			name:     "legacy video formats",
+			path:     "00012.MTS",
+			types:    []string{"video"},
+			expected: true,
+		},
+		{
+			name:     "3GP video",
+			path:     "VID_0001.3gp",
+			types:    []string{"video"},
+			expected: true,
+		},
 		{
 			name:     "not a supported type",
 			path:     "document.pdf",
@@ -277,7 +351,7 @@ func TestResolveTargetPath(t *testing.T) {
 				TakenTime: &testTime,
 				Extension: "jpg",
 			},
-			expected: filepath.Join("/organized", testTime.Local().Format("2006"), "file.jpg"),
+			expected: filepath.Join("/organized", testTime.Format("2006"), "file.jpg"),
 			wantErr:  false,
 		},
 		{
@@ -287,7 +361,7 @@ func TestResolveTargetPath(t *testing.T) {
 				TakenTime: &testTime,
 				Extension: "jpg",
 			},
-			expected: filepath.Join("/organized", testTime.Local().Format("2006-01-02"), "file.jpg"),
+			expected: filepath.Join("/organized", testTime.Format("2006-01-02"), "file.jpg"),
 			wantErr:  false,
 		},
 		{
@@ -297,7 +371,7 @@ func TestResolveTargetPath(t *testing.T) {
 				TakenTime: &testTime,
 				Extension: "jpg",
 			},
-			expected: filepath.Join("/organized", testTime.Local().Format("2006-01-02-15-04-05")+".jpg"),
+			expected: filepath.Join("/organized", testTime.Format("2006-01-02-15-04-05")+".jpg"),
 			wantErr:  false,
 		},
 		{
@@ -337,7 +411,7 @@ func TestResolveTargetPath(t *testing.T) {
 				Extension:   "jpg",
 				CameraMaker: "Sony",
 			},
-			expected: filepath.Join("/organized", testTime.Local().Format("2006"), "Sony", testTime.Local().Format("2006-01-02")+".jpg"),
+			expected: filepath.Join("/organized", testTime.Format("2006"), "Sony", testTime.Format("2006-01-02")+".jpg"),
 			wantErr:  false,
 		},
 		{
@@ -362,7 +436,7 @@ func TestResolveTargetPath(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := resolveTargetPath(tt.tmpl, tt.meta)
+			result, err := resolveTargetPath(tt.tmpl, tt.meta, nil, pathOptions{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("resolveTargetPath() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -374,6 +448,156 @@ func TestResolveTargetPath(t *testing.T) {
 	}
 }
 
+func TestResolveTargetPathSourceTokens(t *testing.T) {
+	meta := &FileMetadata{Extension: "jpg"}
+	sf := &sourceFile{Name: "IMG_1234.JPG", Source: "Camera", RelPath: "2024/trip/IMG_1234.JPG"}
+	// Digests of the content "test".
+	hashed := &sourceFile{Name: "IMG_1234.JPG", RelPath: "IMG_1234.JPG", Hashes: map[string]string{
+		"sha256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		"md5":    "098f6bcd4621d373cade4e832627b4f6",
+	}}
+	mtime := time.Date(2024, 3, 9, 8, 5, 7, 0, time.FixedZone("", 3600))
+	timed := &sourceFile{Name: "IMG_1234.JPG", RelPath: "IMG_1234.JPG", ModTime: &mtime}
+
+	tests := []struct {
+		name     string
+		tmpl     string
+		sf       *sourceFile
+		expected string
+	}{
+		{
+			name:     "original name",
+			tmpl:     "/out/{file.name}",
+			sf:       sf,
+			expected: filepath.Join("/out", "IMG_1234.JPG"),
+		},
+		{
+			name:     "stem with original extension",
+			tmpl:     "/out/{file.stem}.{file.extension:original}",
+			sf:       sf,
+			expected: filepath.Join("/out", "IMG_1234.JPG"),
+		},
+		{
+			name:     "stem with normalized extension",
+			tmpl:     "/out/{file.stem}.{file.extension}",
+			sf:       sf,
+			expected: filepath.Join("/out", "IMG_1234.jpg"),
+		},
+		{
+			name:     "source name",
+			tmpl:     "/out/{source.name}/{file.name}",
+			sf:       sf,
+			expected: filepath.Join("/out", "Camera", "IMG_1234.JPG"),
+		},
+		{
+			name:     "mirror source structure",
+			tmpl:     "/out/{source.relpath}",
+			sf:       sf,
+			expected: filepath.Join("/out", "2024", "trip", "IMG_1234.JPG"),
+		},
+		{
+			name:     "relative directory",
+			tmpl:     "/out/{source.reldir}/{file.stem}.{file.extension}",
+			sf:       sf,
+			expected: filepath.Join("/out", "2024", "trip", "IMG_1234.jpg"),
+		},
+		{
+			name:     "relative directory at source root collapses",
+			tmpl:     "/out/{source.reldir}/{file.name}",
+			sf:       &sourceFile{Name: "a.jpg", RelPath: "a.jpg"},
+			expected: filepath.Join("/out", "a.jpg"),
+		},
+		{
+			name:     "no source file leaves tokens",
+			tmpl:     "/out/{file.name}",
+			sf:       nil,
+			expected: filepath.Join("/out", "{file.name}"),
+		},
+		{
+			name:     "unknown specifier leaves token",
+			tmpl:     "/out/{file.stem:upper}",
+			sf:       sf,
+			expected: filepath.Join("/out", "{file.stem:upper}"),
+		},
+		{
+			name:     "full default hash",
+			tmpl:     "/out/{file.hash}.{file.extension}",
+			sf:       hashed,
+			expected: filepath.Join("/out", "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08.jpg"),
+		},
+		{
+			name:     "truncated default hash",
+			tmpl:     "/out/{file.stem}_{file.hash:8}.{file.extension}",
+			sf:       hashed,
+			expected: filepath.Join("/out", "IMG_1234_9f86d081.jpg"),
+		},
+		{
+			name:     "explicit algorithm",
+			tmpl:     "/out/{file.hash.sha256:8}-{file.hash.md5:6}",
+			sf:       hashed,
+			expected: filepath.Join("/out", "9f86d081-098f6b"),
+		},
+		{
+			name:     "hash not computed leaves token",
+			tmpl:     "/out/{file.hash.sha1:8}",
+			sf:       hashed,
+			expected: filepath.Join("/out", "{file.hash.sha1:8}"),
+		},
+		{
+			name:     "invalid hash length leaves token",
+			tmpl:     "/out/{file.hash:abc}",
+			sf:       hashed,
+			expected: filepath.Join("/out", "{file.hash:abc}"),
+		},
+		{
+			name:     "modification time",
+			tmpl:     "/out/{file.mtime.year}/{file.mtime.datetime}_{file.mtime.tz}.{file.extension}",
+			sf:       timed,
+			expected: filepath.Join("/out", "2024", "2024-03-09-08-05-07_+0100.jpg"),
+		},
+		{
+			name:     "unknown modification time leaves token",
+			tmpl:     "/out/{file.mtime.date}",
+			sf:       sf,
+			expected: filepath.Join("/out", "{file.mtime.date}"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := resolveTargetPath(tt.tmpl, meta, tt.sf, pathOptions{})
+			if err != nil {
+				t.Fatalf("resolveTargetPath() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("resolveTargetPath() = %q; want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHashTokenAlgorithms(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want []string
+	}{
+		{tmpl: "/out/{meta.taken.datetime}.{file.extension}", want: nil},
+		{tmpl: "/out/{file.hash}", want: []string{"sha256"}},
+		{tmpl: "/out/{file.hash:8}/{file.hash.sha256}", want: []string{"sha256"}},
+		{tmpl: "/out/{file.hash.md5:6}_{file.hash.sha1}", want: []string{"md5", "sha1"}},
+		{tmpl: "/out/{file.hash.crc99}", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			got := hashTokenAlgorithms(tt.tmpl)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("hashTokenAlgorithms(%q) = %v; want %v", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestHasUnpopulatedTokens(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,188 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// HEIF (.heic, .heif) and AVIF images are ISOBMFF containers like MP4, but
+// their EXIF block is an "item": the meta box's iinf lists items by type and
+// iloc says where each one's bytes are. go-mp4 doesn't know those boxes, so
+// this file reads just enough of them to hand the EXIF block to goexif.
+
+var errNoHEIFExif = errors.New("heif: no Exif item")
+
+// heifExifReader returns the EXIF data of a HEIF/AVIF image, starting at its
+// TIFF header, ready for exif.Decode.
+func heifExifReader(r io.ReaderAt, size int64) (io.Reader, error) {
+	top, err := bmffBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := findBMFFBox(top, "meta")
+	if !ok {
+		return nil, errNoHEIFExif
+	}
+	// meta is a full box: version and flags precede its children.
+	children, err := bmffBoxes(r, meta.start+4, meta.end)
+	if err != nil {
+		return nil, err
+	}
+	iinf, ok1 := findBMFFBox(children, "iinf")
+	iloc, ok2 := findBMFFBox(children, "iloc")
+	if !ok1 || !ok2 {
+		return nil, errNoHEIFExif
+	}
+	id, err := heifExifItemID(r, iinf)
+	if err != nil {
+		return nil, err
+	}
+	var idat *bmffBox
+	if b, ok := findBMFFBox(children, "idat"); ok {
+		idat = &b
+	}
+	item, err := heifItemReader(r, size, iloc, idat, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The item starts with the offset of the TIFF header within the rest
+	// of it (normally 6, skipping an "Exif\0\0" marker).
+	var skip [4]byte
+	if _, err := io.ReadFull(item, skip[:]); err != nil {
+		return nil, fmt.Errorf("heif: read Exif item: %w", err)
+	}
+	if _, err := io.CopyN(io.Discard, item, int64(binary.BigEndian.Uint32(skip[:]))); err != nil {
+		return nil, fmt.Errorf("heif: read Exif item: %w", err)
+	}
+	return item, nil
+}
+
+// heifExifItemID finds the ID of the Exif item in an iinf box.
+func heifExifItemID(r io.ReaderAt, iinf bmffBox) (uint32, error) {
+	b, err := readBMFFBox(r, iinf)
+	if err != nil {
+		return 0, err
+	}
+	p := bmffPayload{b: b}
+	version := p.u8()
+	p.skip(3)
+	if version == 0 {
+		p.u16()
+	} else {
+		p.u32()
+	}
+	if p.err != nil {
+		return 0, p.err
+	}
+	entries, err := bmffBoxes(r, iinf.start+int64(p.off), iinf.end)
+	if err != nil {
+		return 0, err
+	}
+	for _, infe := range entries {
+		if infe.typ != "infe" {
+			continue
+		}
+		b, err := readBMFFBox(r, infe)
+		if err != nil {
+			return 0, err
+		}
+		p := bmffPayload{b: b}
+		version := p.u8()
+		p.skip(3)
+		if version < 2 {
+			// Versions 0 and 1 predate item types and can't mark Exif.
+			continue
+		}
+		var id uint32
+		if version == 2 {
+			id = uint32(p.u16())
+		} else {
+			id = p.u32()
+		}
+		p.u16() // item_protection_index
+		typ := p.bytes(4)
+		if p.err == nil && string(typ) == "Exif" {
+			return id, nil
+		}
+	}
+	return 0, errNoHEIFExif
+}
+
+// heifItemReader returns the bytes of item id as located by an iloc box. Items
+// stored in the file (construction method 0) and in the meta box's idat
+// (method 1) are supported. fileSize bounds extents that run to the end of the
+// file.
+func heifItemReader(r io.ReaderAt, fileSize int64, iloc bmffBox, idat *bmffBox, id uint32) (io.Reader, error) {
+	b, err := readBMFFBox(r, iloc)
+	if err != nil {
+		return nil, err
+	}
+	p := bmffPayload{b: b}
+	version := p.u8()
+	p.skip(3)
+	sizes := p.u16()
+	offsetSize := int(sizes >> 12)
+	lengthSize := int(sizes >> 8 & 0xf)
+	baseOffsetSize := int(sizes >> 4 & 0xf)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xf)
+	}
+	var count uint32
+	if version < 2 {
+		count = uint32(p.u16())
+	} else {
+		count = p.u32()
+	}
+	for i := uint32(0); i < count && p.err == nil; i++ {
+		var itemID uint32
+		if version < 2 {
+			itemID = uint32(p.u16())
+		} else {
+			itemID = p.u32()
+		}
+		method := 0
+		if version == 1 || version == 2 {
+			method = int(p.u16() & 0xf)
+		}
+		p.u16() // data_reference_index
+		base := p.uint(baseOffsetSize)
+		extents := p.u16()
+		var readers []io.Reader
+		for j := uint16(0); j < extents && p.err == nil; j++ {
+			p.uint(indexSize)
+			off := int64(base + p.uint(offsetSize))
+			length := int64(p.uint(lengthSize))
+			if itemID != id {
+				continue
+			}
+			switch method {
+			case 0:
+				if length == 0 {
+					length = max(0, fileSize-off)
+				}
+			case 1:
+				if idat == nil {
+					return nil, errors.New("heif: Exif item refers to missing idat box")
+				}
+				off += idat.start
+				if length == 0 {
+					length = idat.end - off
+				}
+			default:
+				return nil, fmt.Errorf("heif: unsupported Exif item construction method %d", method)
+			}
+			readers = append(readers, io.NewSectionReader(r, off, length))
+		}
+		if itemID == id && p.err == nil {
+			return io.MultiReader(readers...), nil
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return nil, fmt.Errorf("heif: Exif item %d has no location", id)
+}
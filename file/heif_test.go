@@ -0,0 +1,120 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// isoBox returns an ISOBMFF box of type typ around payload.
+func isoBox(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+// fullBox returns the version/flags header of an ISOBMFF full box.
+func fullBox(version byte) []byte { return []byte{version, 0, 0, 0} }
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// heifExifPayload wraps TIFF data the way HEIF stores an Exif item.
+func heifExifPayload(tiff []byte) []byte {
+	return append(append(u32(6), "Exif\x00\x00"...), tiff...)
+}
+
+// buildHEIF returns a minimal HEIF file with an image item and an Exif item.
+// With inIdat the Exif item is stored in the meta box's idat (iloc version 1,
+// construction method 1); otherwise it follows the meta box in an mdat and is
+// located by file offset (iloc version 0).
+func buildHEIF(exifItem []byte, inIdat bool) []byte {
+	ftyp := isoBox("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+	iinf := isoBox("iinf", fullBox(0), u16(2),
+		isoBox("infe", fullBox(2), u16(1), u16(0), []byte("hvc1"), []byte{0}),
+		isoBox("infe", fullBox(2), u16(2), u16(0), []byte("Exif"), []byte{0}),
+	)
+	// The image item's extent is never read; it just has to be skipped.
+	imageExtent := [][]byte{u16(1), u16(0), u16(1), u32(0), u32(0)}
+
+	iloc := func(exifOffset uint32) []byte {
+		if inIdat {
+			return isoBox("iloc", fullBox(1), []byte{0x44, 0x00}, u16(2),
+				bytes.Join(append([][]byte{u16(1), u16(0)}, imageExtent[1:]...), nil),
+				u16(2), u16(1), u16(0), u16(1), u32(0), u32(uint32(len(exifItem))),
+			)
+		}
+		return isoBox("iloc", fullBox(0), []byte{0x44, 0x00}, u16(2),
+			bytes.Join(imageExtent, nil),
+			u16(2), u16(0), u16(1), u32(exifOffset), u32(uint32(len(exifItem))),
+		)
+	}
+	meta := func(exifOffset uint32) []byte {
+		children := [][]byte{fullBox(0), iinf, iloc(exifOffset)}
+		if inIdat {
+			children = append(children, isoBox("idat", exifItem))
+		}
+		return isoBox("meta", children...)
+	}
+	if inIdat {
+		return append(ftyp, meta(0)...)
+	}
+	// The mdat payload starts after ftyp, meta and the mdat header; meta's
+	// size doesn't depend on the offset value, so measure it first.
+	offset := uint32(len(ftyp) + len(meta(0)) + 8)
+	return bytes.Join([][]byte{ftyp, meta(offset), isoBox("mdat", exifItem)}, nil)
+}
+
+func TestHEIFExifReader(t *testing.T) {
+	tiff := buildTIFF([]tiffField{asciiField(0x010f, "Apple")}, []tiffField{asciiField(0x9003, "2024:01:15 14:30:45")})
+
+	for _, inIdat := range []bool{false, true} {
+		data := buildHEIF(heifExifPayload(tiff), inIdat)
+		r, err := heifExifReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("inIdat=%v: heifExifReader: %v", inIdat, err)
+		}
+		var got bytes.Buffer
+		if _, err := got.ReadFrom(r); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), tiff) {
+			t.Errorf("inIdat=%v: Exif data = %q; want the TIFF block", inIdat, got.Bytes())
+		}
+	}
+
+	noExif := isoBox("ftyp", []byte("avif"))
+	if _, err := heifExifReader(bytes.NewReader(noExif), int64(len(noExif))); !errors.Is(err, errNoHEIFExif) {
+		t.Errorf("heifExifReader without meta: err = %v; want errNoHEIFExif", err)
+	}
+
+	truncated := buildHEIF(heifExifPayload(tiff), false)[:40]
+	if _, err := heifExifReader(bytes.NewReader(truncated), int64(len(truncated))); err == nil {
+		t.Error("heifExifReader accepted a truncated file")
+	}
+}
+
+// TestExtractImageMetadataHEIC reads a HEIC over a streamed entry (as MTP
+// provides), which has no local path for exiftool to fall back on.
+func TestExtractImageMetadataHEIC(t *testing.T) {
+	tiff := buildTIFF([]tiffField{asciiField(0x010f, "Apple"), asciiField(0x0110, "iPhone 15")}, []tiffField{asciiField(0x9003, "2024:01:15 14:30:45")})
+	entry := &fakeEntry{name: "IMG_0001.HEIC", bodies: [][]byte{buildHEIF(heifExifPayload(tiff), false)}}
+
+	meta, err := extractImageMetadataFromEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.TakenTime == nil || meta.TakenTime.Format("2006-01-02 15:04:05") != "2024-01-15 14:30:45" {
+		t.Errorf("TakenTime = %v; want 2024-01-15 14:30:45", meta.TakenTime)
+	}
+	if meta.CameraMaker != "Apple" || meta.CameraModel != "iPhone 15" {
+		t.Errorf("camera = %q %q; want Apple iPhone 15", meta.CameraMaker, meta.CameraModel)
+	}
+	if meta.Extension != "heic" {
+		t.Errorf("Extension = %q; want heic", meta.Extension)
+	}
+	if !isFileType("IMG_0001.HEIC", []string{"image"}) {
+		t.Error("HEIC is not registered as an image type")
+	}
+}
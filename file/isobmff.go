@@ -0,0 +1,119 @@
+package file
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ISOBMFF (ISO base media file format) is the box structure shared by MP4,
+// HEIF/AVIF and Canon CR3. go-mp4 handles movies; these helpers cover the
+// still-image boxes it doesn't know.
+
+// bmffBox is an ISOBMFF box located within a file: its type, the offset of
+// its payload and the offset just past its end.
+type bmffBox struct {
+	typ        string
+	start, end int64
+}
+
+// bmffBoxes lists the boxes laid out back to back in r between off and end.
+func bmffBoxes(r io.ReaderAt, off, end int64) ([]bmffBox, error) {
+	var boxes []bmffBox
+	for off+8 <= end {
+		var hdr [16]byte
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		typ := string(hdr[4:8])
+		start := off + 8
+		switch size {
+		case 0: // extends to the end of the enclosing box
+			size = end - off
+		case 1: // 64-bit size follows the type
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			start += 8
+		}
+		if size < start-off || off+size > end {
+			return nil, fmt.Errorf("isobmff: box %q at %d has invalid size %d", typ, off, size)
+		}
+		boxes = append(boxes, bmffBox{typ: typ, start: start, end: off + size})
+		off += size
+	}
+	return boxes, nil
+}
+
+func findBMFFBox(boxes []bmffBox, typ string) (bmffBox, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return bmffBox{}, false
+}
+
+// maxBMFFBoxPayload bounds the metadata boxes read into memory (iinf, iloc,
+// …); real ones are a few kilobytes even for images with hundreds of tiles.
+const maxBMFFBoxPayload = 16 << 20
+
+func readBMFFBox(r io.ReaderAt, b bmffBox) ([]byte, error) {
+	n := b.end - b.start
+	if n > maxBMFFBoxPayload {
+		return nil, fmt.Errorf("isobmff: %s box too large (%d bytes)", b.typ, n)
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, b.start); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// bmffPayload reads big-endian fields from a box payload, recording the
+// first out-of-bounds read in err (after which reads return zero).
+type bmffPayload struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (p *bmffPayload) bytes(n int) []byte {
+	if p.err != nil {
+		return make([]byte, n)
+	}
+	if p.off+n > len(p.b) {
+		p.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	v := p.b[p.off : p.off+n]
+	p.off += n
+	return v
+}
+
+func (p *bmffPayload) skip(n int) { p.bytes(n) }
+func (p *bmffPayload) u8() uint8  { return p.bytes(1)[0] }
+func (p *bmffPayload) u16() uint16 {
+	return binary.BigEndian.Uint16(p.bytes(2))
+}
+func (p *bmffPayload) u32() uint32 {
+	return binary.BigEndian.Uint32(p.bytes(4))
+}
+
+// uint reads an n-byte field, n being 0, 4 or 8 (as iloc allows).
+func (p *bmffPayload) uint(n int) uint64 {
+	switch n {
+	case 0:
+		return 0
+	case 4:
+		return uint64(p.u32())
+	case 8:
+		return binary.BigEndian.Uint64(p.bytes(8))
+	}
+	if p.err == nil {
+		p.err = fmt.Errorf("isobmff: invalid field size %d", n)
+	}
+	return 0
+}
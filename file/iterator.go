@@ -1,14 +1,20 @@
 package file
 
 import (
+	"fmt"
 	"io"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sync"
 
 	ffcfg "github.com/dkarlovi/fileferry/config"
 )
 
+// maxWorkers caps the workers extracting metadata concurrently, and with them
+// the external tool processes they share.
+const maxWorkers = 8
+
 type File struct {
 	OldPath  string
 	NewPath  string
@@ -16,13 +22,27 @@ type File struct {
 	Metadata *FileMetadata
 	Entry    Entry
 	Error    error
+	// Conflict is the profile's policy for a NewPath already holding a
+	// different file; pass it to MoveEntryWithPolicy.
+	Conflict ConflictPolicy
+	// Companions are files moved along with Entry (see the profile's
+	// companions setting); pass them to MoveGroupWithPolicy.
+	Companions []Companion
+	// Warnings are failures of extractors that didn't stop the file from
+	// being processed (see ExtractorWarning). The iterator also reports
+	// them as "warning" ScanEvents.
+	Warnings []error
 }
 
 // FileIterator is a convenience wrapper returning only the file channel. It is
 // intended for local sources (whose Close is a no-op and whose entries do not
 // depend on an open session), so the source closer is intentionally dropped.
 func FileIterator(cfg *ffcfg.Config) <-chan File {
-	ch, _, _ := FileIteratorWithEvents(cfg, "")
+	ch, evCh, _ := FileIteratorWithEvents(cfg, "")
+	go func() {
+		for range evCh {
+		}
+	}()
 	return ch
 }
 
@@ -34,7 +54,7 @@ type ScanEvent struct {
 	Types     []string
 	Found     int    // number of files found (if >=0)
 	Error     error  // optional error that happened while scanning
-	EventType string // one of: "start", "found", "error"
+	EventType string // one of: "start", "found", "error", "warning"
 }
 
 // FileIteratorWithEvents returns a channel of Files, a channel of ScanEvent, and
@@ -46,14 +66,29 @@ type ScanEvent struct {
 // are read and moved, so callers MUST NOT call the io.Closer until all moves are
 // complete (defer it). If profileName is non-empty, only that profile is
 // processed.
+//
+// Files whose target path uses the {seq} token are numbered only after every
+// source has been scanned, so they are sent last.
 func FileIteratorWithEvents(cfg *ffcfg.Config, profileName string) (<-chan File, <-chan ScanEvent, io.Closer) {
+	return FileIteratorWithOptions(cfg, IteratorOptions{Profile: profileName})
+}
+
+// IteratorOptions configure FileIteratorWithOptions.
+type IteratorOptions struct {
+	// Profile, if non-empty, is the only profile processed.
+	Profile string
+	// Cache, if set, is consulted before reading a file's metadata and
+	// updated with what is read. The caller saves it once iteration is done.
+	Cache *MetadataCache
+}
+
+// FileIteratorWithOptions is FileIteratorWithEvents with more options.
+func FileIteratorWithOptions(cfg *ffcfg.Config, opts IteratorOptions) (<-chan File, <-chan ScanEvent, io.Closer) {
+	profileName, cache := opts.Profile, opts.Cache
 	ch := make(chan File, 100)
 	evCh := make(chan ScanEvent, 100)
 
-	workerCount := runtime.NumCPU()
-	if workerCount > 8 {
-		workerCount = 8
-	}
+	workerCount := min(runtime.NumCPU(), maxWorkers)
 
 	// Open all sources up front so the returned closer owns their lifetime,
 	// independent of when scanning finishes.
@@ -70,6 +105,14 @@ func FileIteratorWithEvents(cfg *ffcfg.Config, profileName string) (<-chan File,
 		}
 		for _, src := range prof.Sources {
 			source, err := OpenSource(src)
+			if err == nil {
+				// A pattern that doesn't compile would never match; report
+				// it rather than scan the source without it.
+				err = checkFilenamePatterns(append(slices.Clone(src.Filenames), prof.Patterns...))
+				if err != nil {
+					source.Close()
+				}
+			}
 			if err != nil {
 				openErrs = append(openErrs, File{OldPath: src.Path, Error: err})
 				// Remember the failing source so its error event is emitted in order.
@@ -98,6 +141,12 @@ func FileIteratorWithEvents(cfg *ffcfg.Config, profileName string) (<-chan File,
 		defer close(evCh)
 
 		filePaths := make(chan fileJob, workerCount*2)
+		extractors := extractorsFor(cfg)
+
+		// Files whose target uses {seq} can only be numbered once every file
+		// is planned, so they are held back and sent after scanning finishes.
+		var seqMu sync.Mutex
+		var seqFiles []File
 
 		var wg sync.WaitGroup
 		for i := 0; i < workerCount; i++ {
@@ -105,7 +154,18 @@ func FileIteratorWithEvents(cfg *ffcfg.Config, profileName string) (<-chan File,
 			go func() {
 				defer wg.Done()
 				for job := range filePaths {
-					ch <- processFile(job.entry, job.src, job.profile, cfg)
+					f := processFile(job.entry, job.src, job.profile, cfg, extractors, cache)
+					for _, w := range f.Warnings {
+						evCh <- ScanEvent{Profile: job.profile, SrcPath: job.src.Path, EventType: "warning", Error: w}
+					}
+					attachCompanions(&f, job.companions)
+					if f.Error == nil && hasSeqToken(f.NewPath) {
+						seqMu.Lock()
+						seqFiles = append(seqFiles, f)
+						seqMu.Unlock()
+						continue
+					}
+					ch <- f
 				}
 			}()
 		}
@@ -128,7 +188,8 @@ func FileIteratorWithEvents(cfg *ffcfg.Config, profileName string) (<-chan File,
 					continue
 				}
 
-				entries, err := o.source.Scan(o.src.Types, o.src.Recurse)
+				companionExts := cfg.Profiles[o.profile].Companions
+				entries, err := o.source.Scan(append(slices.Clone(o.src.Types), companionExts...), o.src.Recurse)
 				if err != nil {
 					evCh <- ScanEvent{Profile: o.profile, SrcPath: o.src.Path, EventType: "error", Error: err}
 					ch <- File{OldPath: o.src.Path, Error: err}
@@ -136,13 +197,19 @@ func FileIteratorWithEvents(cfg *ffcfg.Config, profileName string) (<-chan File,
 				}
 
 				evCh <- ScanEvent{Profile: o.profile, SrcPath: o.src.Path, Found: len(entries), EventType: "found"}
-				for _, e := range entries {
-					filePaths <- fileJob{entry: e, src: o.src, profile: o.profile}
+				for _, g := range groupCompanions(entries, o.src.Types, companionExts) {
+					filePaths <- fileJob{entry: g.primary, companions: g.companions, src: o.src, profile: o.profile}
 				}
 			}
 		}()
 
 		wg.Wait()
+		exiftoolProcs.Close()
+
+		assignSequence(seqFiles)
+		for _, f := range seqFiles {
+			ch <- f
+		}
 	}()
 
 	return ch, evCh, closer
@@ -153,16 +220,18 @@ type closerFunc func() error
 func (f closerFunc) Close() error { return f() }
 
 type fileJob struct {
-	entry   Entry
-	src     ffcfg.SourceConfig
-	profile string
+	entry      Entry
+	companions []Companion
+	src        ffcfg.SourceConfig
+	profile    string
 }
 
-func processFile(entry Entry, src ffcfg.SourceConfig, profileName string, cfg *ffcfg.Config) File {
+func processFile(entry Entry, src ffcfg.SourceConfig, profileName string, cfg *ffcfg.Config, extractors *ExtractorRegistry, cache *MetadataCache) File {
 	file := File{
 		OldPath: entry.DisplayPath(),
 		Entry:   entry,
 	}
+	sf := newSourceFile(entry, src)
 
 	var meta *FileMetadata
 	for _, pat := range src.Filenames {
@@ -181,71 +250,120 @@ func processFile(entry Entry, src ffcfg.SourceConfig, profileName string, cfg *f
 			}
 		}
 	}
+	if meta != nil {
+		meta.attribute("filename")
+	}
 
-	var targetTmpl string
+	var targetTmpl, xmpMode string
+	var opts pathOptions
 	if prof, ok := cfg.Profiles[profileName]; ok {
 		targetTmpl = prof.Target.Path
+		xmpMode = prof.XMP
+		opts = newPathOptions(prof)
+		file.Conflict = conflictPolicyFor(prof)
 	}
+	opts.containerLocal = src.ContainerTime == ContainerTimeLocal
 	if targetTmpl == "" {
 		file.Error = &TargetTemplateError{Path: entry.DisplayPath()}
 		return file
 	}
+	if mt := entry.ModTime(); !mt.IsZero() {
+		sf.ModTime = anchorTime(&mt, TimeInstant, opts)
+	}
+
+	// What an earlier run read from the file is reused while its size and
+	// modification time are unchanged.
+	var cached cacheEntry
+	var isCached bool
+	if cache != nil {
+		cached, isCached = cache.lookup(entry, src, sf)
+	}
+
+	// Content hashes are a full read of the file, so they are computed only
+	// when the template uses a {file.hash} token. A SHA-256 computed here is
+	// remembered on the entry and reused to verify the copy when it is moved.
+	// Cached hashes only render the tokens: they are never remembered, since
+	// a file changed without changing size (or, locally, time) would then be
+	// checked against its old content.
+	if algos := hashTokenAlgorithms(targetTmpl); len(algos) > 0 {
+		hashes, ok := cachedHashes(cached.Hashes, algos)
+		if !ok {
+			var err error
+			if hashes, err = hashEntryAlgorithms(entry, algos); err != nil {
+				file.Error = fmt.Errorf("hash %s: %w", entry.DisplayPath(), err)
+				return file
+			}
+			if cache != nil {
+				cache.storeHashes(entry, src, sf, hashes)
+			}
+		}
+		sf.Hashes = hashes
+	}
 
 	// Fast path: if the filename pattern alone already fills the target template,
 	// don't read the file's content. This matters over MTP, where opening a file
 	// streams it in full — reading EXIF from a multi-MB RAW just to learn a date
 	// the filename already carries would be wasteful.
-	if meta != nil {
-		if targetPath, err := resolveTargetPath(targetTmpl, meta); err == nil && !hasUnpopulatedTokens(targetPath) {
-			file.Metadata = meta
+	// With date_sources, that's only if the content can't take precedence,
+	// and with xmp: prefer the XMP (a sidecar or the embedded packet) always
+	// can.
+	nameMeta := meta
+	if filenameFirst(opts.dateSources) && xmpMode != XMPPrefer {
+		anchored := anchorTimes(applyDateSources(takenByPrecedence(meta, opts.takenOrder), nameMeta, entry, opts.dateSources), opts)
+		if targetPath, err := resolveTargetPath(targetTmpl, anchored, sf, opts); anchored != nil && err == nil && !hasUnpopulatedTokens(withoutSeqTokens(targetPath)) {
+			file.Metadata = anchored
 			setOp(&file, entry, targetPath)
 			return file
 		}
 	}
 
-	// Otherwise read metadata from the file content to fill the gaps. RAW images
-	// (image.raw) are TIFF-based, so EXIF extraction applies to them too.
+	// Otherwise read metadata from the file content to fill the gaps; what
+	// the content records wins over what the filename says.
 	var actualMeta *FileMetadata
-	var err error
-	if isFileType(entry.Name(), []string{"image", "image.raw"}) {
-		actualMeta, err = extractImageMetadataFromEntry(entry)
-	} else if isFileType(entry.Name(), []string{"video"}) {
-		actualMeta, err = extractVideoMetadataFromEntry(entry)
+	fingerprint := extractorsFingerprint(extractors)
+	found := false
+	if isCached {
+		actualMeta, found = cache.cachedMetadata(cached, fingerprint)
 	}
-	if err != nil {
-		file.Error = err
-		return file
+	if !found {
+		var warnings []error
+		var err error
+		actualMeta, warnings, err = extractors.extract(entry)
+		for _, w := range warnings {
+			file.Warnings = append(file.Warnings, fmt.Errorf("%s: %w", entry.DisplayPath(), w))
+		}
+		if err != nil {
+			file.Error = err
+			return file
+		}
+		// What a failing extractor would have read is unknown, so it is
+		// read again next time rather than cached without it.
+		if cache != nil && len(warnings) == 0 {
+			cache.storeMetadata(entry, src, sf, fingerprint, actualMeta)
+		}
 	}
-
 	if actualMeta != nil {
-		if meta == nil {
-			meta = actualMeta
-		} else {
-			if actualMeta.TakenTime != nil {
-				meta.TakenTime = actualMeta.TakenTime
-			}
-			if actualMeta.Extension != "" {
-				meta.Extension = actualMeta.Extension
-			}
-			if actualMeta.CameraMaker != "" {
-				meta.CameraMaker = actualMeta.CameraMaker
-			}
-			if actualMeta.CameraModel != "" {
-				meta.CameraModel = actualMeta.CameraModel
-			}
+		if meta != nil {
+			fillMissing(actualMeta, meta)
 		}
+		meta = actualMeta
+	}
+	if meta != nil {
+		applyXMP(meta, readXMPSidecar(entry), xmpMode)
 	}
 
+	meta = anchorTimes(applyDateSources(takenByPrecedence(meta, opts.takenOrder), nameMeta, entry, opts.dateSources), opts)
 	file.Metadata = meta
 
-	targetPath, err := resolveTargetPath(targetTmpl, meta)
+	targetPath, err := resolveTargetPath(targetTmpl, meta, sf, opts)
 	if err != nil {
 		file.Error = err
 		return file
 	}
 
-	// Check if the target path still contains unpopulated template tokens
-	if hasUnpopulatedTokens(targetPath) {
+	// Check if the target path still contains unpopulated template tokens. A
+	// {seq} token is expected to remain: the iterator resolves it later.
+	if hasUnpopulatedTokens(withoutSeqTokens(targetPath)) {
 		file.Error = &UnpopulatedTokensError{Path: entry.DisplayPath(), TargetPath: targetPath}
 		return file
 	}
@@ -254,11 +372,15 @@ func processFile(entry Entry, src ffcfg.SourceConfig, profileName string, cfg *f
 	return file
 }
 
-// setOp records the resolved destination and whether an actual move is needed.
-// For local entries, a file already at its target is a no-op; MTP entries have
-// no comparable filesystem path, so they always move.
+// setOp records the resolved destination, its companions' destinations and
+// whether an actual move is needed. For local entries, a file already at its
+// target is a no-op; MTP entries have no comparable filesystem path, so they
+// always move.
 func setOp(file *File, entry Entry, targetPath string) {
 	file.NewPath = targetPath
+	for i := range file.Companions {
+		file.Companions[i].NewPath = file.Companions[i].path(targetPath)
+	}
 	file.ShouldOp = true
 	if lp, ok := entry.(localPathProvider); ok {
 		absSrc, _ := filepath.Abs(lp.LocalPath())
@@ -267,6 +389,15 @@ func setOp(file *File, entry Entry, targetPath string) {
 	}
 }
 
+// attachCompanions records a file's companions and, once its destination is
+// resolved, theirs.
+func attachCompanions(file *File, companions []Companion) {
+	file.Companions = companions
+	if file.NewPath != "" {
+		setOp(file, file.Entry, file.NewPath)
+	}
+}
+
 type TargetTemplateError struct {
 	Path string
 }
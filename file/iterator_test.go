@@ -195,7 +195,7 @@ func TestProcessFile(t *testing.T) {
 			if statErr != nil {
 				t.Fatalf("Failed to stat test file: %v", statErr)
 			}
-			result := processFile(&localEntry{path: tt.filePath, info: fi}, tt.src, tt.profileName, tt.cfg)
+			result := processFile(&localEntry{path: tt.filePath, info: fi}, tt.src, tt.profileName, tt.cfg, DefaultExtractors, nil)
 
 			if tt.wantErr && result.Error == nil {
 				t.Error("processFile() expected error but got none")
@@ -0,0 +1,60 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// jpegXMPHeader starts the APP1 segment holding a JPEG's XMP packet.
+const jpegXMPHeader = "http://ns.adobe.com/xap/1.0/\x00"
+
+// readJPEGHeader reads a JPEG's segments up to the start of the image data
+// (SOS) and returns them, which is where all its metadata lives, along with
+// the XMP packet if one of them holds it. The returned header is a valid
+// input for exif.Decode, so the file is read once, sequentially.
+func readJPEGHeader(r io.Reader) (header, xmp []byte, err error) {
+	var buf bytes.Buffer
+	tr := io.TeeReader(r, &buf)
+	var soi [2]byte
+	if _, err := io.ReadFull(tr, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		return nil, nil, errors.New("jpeg: missing SOI marker")
+	}
+	for {
+		var m [2]byte
+		if _, err := io.ReadFull(tr, m[:]); err != nil {
+			return nil, nil, fmt.Errorf("jpeg: read marker: %w", err)
+		}
+		if m[0] != 0xff {
+			return nil, nil, fmt.Errorf("jpeg: invalid marker %#x", m)
+		}
+		for m[1] == 0xff { // fill bytes
+			if _, err := io.ReadFull(tr, m[1:]); err != nil {
+				return nil, nil, fmt.Errorf("jpeg: read marker: %w", err)
+			}
+		}
+		switch {
+		case m[1] == 0xda || m[1] == 0xd9: // SOS, EOI
+			return buf.Bytes(), xmp, nil
+		case m[1] >= 0xd0 && m[1] <= 0xd7 || m[1] == 0x01: // no payload
+			continue
+		}
+		var l [2]byte
+		if _, err := io.ReadFull(tr, l[:]); err != nil {
+			return nil, nil, fmt.Errorf("jpeg: read segment length: %w", err)
+		}
+		n := int(binary.BigEndian.Uint16(l[:])) - 2
+		if n < 0 || buf.Len()+n > maxMetadataChunk {
+			return nil, nil, errors.New("jpeg: invalid segment length")
+		}
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(tr, seg); err != nil {
+			return nil, nil, fmt.Errorf("jpeg: read segment: %w", err)
+		}
+		if m[1] == 0xe1 && xmp == nil && bytes.HasPrefix(seg, []byte(jpegXMPHeader)) {
+			xmp = seg[len(jpegXMPHeader):]
+		}
+	}
+}
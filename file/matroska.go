@@ -0,0 +1,186 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	mkvparse "github.com/remko/go-mkvparse"
+)
+
+// Matroska (.mkv, .webm) keeps its metadata in the Segment's Info and Tags
+// elements, which muxers write ahead of the media data (Clusters), except
+// that some put Tags at the end and point to them from the SeekHead.
+// mkvparse.Parse reads a file to its end, which for multi-GB recordings with
+// unknown-size Clusters (OBS) means every block; readMatroskaMetadata stops
+// as soon as the metadata is read.
+
+// errMKVDone stops mkvparse.Parse once the metadata has been read.
+var errMKVDone = errors.New("mkv: metadata read")
+
+// dateHandler captures the first DateUTC element of a Matroska file.
+type dateHandler struct {
+	mkvparse.DefaultHandler
+	found bool
+	tm    time.Time
+}
+
+func (h *dateHandler) HandleDate(id mkvparse.ElementID, v time.Time, info mkvparse.ElementInfo) error {
+	if id == mkvparse.DateUTCElement && !h.found {
+		h.found = true
+		h.tm = v
+	}
+	return nil
+}
+
+// mkvHandler reads a Matroska file's Info and Tags, and its SeekHead to find
+// Tags stored after the media data. It ends the parse with errMKVDone at the
+// first Cluster, or once Info and Tags have both been read.
+type mkvHandler struct {
+	dateHandler
+	segmentOffset int64 // where the Segment's data starts; seek positions are relative to it
+	seeks         map[mkvparse.ElementID]int64
+	seekID        mkvparse.ElementID
+	seekPos       int64
+	infoDone      bool
+	tagsDone      bool
+	writingApp    string
+	tagName       string
+	tags          map[string]string // first value of each tag, by upper-cased name
+}
+
+func (h *mkvHandler) HandleMasterBegin(id mkvparse.ElementID, info mkvparse.ElementInfo) (bool, error) {
+	switch id {
+	case mkvparse.SegmentElement:
+		h.segmentOffset = info.Offset
+		return true, nil
+	case mkvparse.SimpleTagElement:
+		h.tagName = ""
+		return true, nil
+	case mkvparse.SeekHeadElement, mkvparse.SeekElement, mkvparse.InfoElement, mkvparse.TagsElement, mkvparse.TagElement:
+		return true, nil
+	case mkvparse.ClusterElement:
+		// Media data: everything written ahead of it has been read.
+		return false, errMKVDone
+	}
+	return false, nil
+}
+
+func (h *mkvHandler) HandleMasterEnd(id mkvparse.ElementID, info mkvparse.ElementInfo) error {
+	switch id {
+	case mkvparse.SeekElement:
+		if h.seekID != 0 {
+			h.seeks[h.seekID] = h.seekPos
+		}
+		h.seekID, h.seekPos = 0, 0
+	case mkvparse.InfoElement:
+		h.infoDone = true
+	case mkvparse.TagsElement:
+		h.tagsDone = true
+	}
+	if h.infoDone && h.tagsDone {
+		return errMKVDone
+	}
+	return nil
+}
+
+func (h *mkvHandler) HandleString(id mkvparse.ElementID, v string, info mkvparse.ElementInfo) error {
+	switch id {
+	case mkvparse.WritingAppElement:
+		h.writingApp = strings.TrimSpace(v)
+	case mkvparse.TagNameElement:
+		h.tagName = strings.ToUpper(strings.TrimSpace(v))
+	case mkvparse.TagStringElement:
+		if _, seen := h.tags[h.tagName]; h.tagName != "" && !seen {
+			h.tags[h.tagName] = strings.TrimSpace(v)
+		}
+	}
+	return nil
+}
+
+func (h *mkvHandler) HandleInteger(id mkvparse.ElementID, v int64, info mkvparse.ElementInfo) error {
+	if id == mkvparse.SeekPositionElement {
+		h.seekPos = v
+	}
+	return nil
+}
+
+func (h *mkvHandler) HandleBinary(id mkvparse.ElementID, v []byte, info mkvparse.ElementInfo) error {
+	if id == mkvparse.SeekIDElement && len(v) <= 8 {
+		var b [8]byte
+		copy(b[8-len(v):], v)
+		h.seekID = mkvparse.ElementID(binary.BigEndian.Uint64(b[:]))
+	}
+	return nil
+}
+
+// readMatroskaMetadata fills meta from a Matroska file's Info and Tags: the
+// taken time (the DATE_RECORDED tag, else the DateUTC muxing date), camera
+// make and model tags and the encoding software. Reading stops before the
+// media data. Tags stored after it are read only when r can seek, so streamed
+// (MTP) sources are never read in full.
+func readMatroskaMetadata(r io.Reader, meta *FileMetadata) {
+	h := &mkvHandler{
+		seeks: make(map[mkvparse.ElementID]int64),
+		tags:  make(map[string]string),
+	}
+	// A damaged file still yields what was read before the damage.
+	_ = mkvparse.Parse(r, h)
+	if pos, ok := h.seeks[mkvparse.TagsElement]; ok && !h.tagsDone {
+		if rs, ok := r.(io.Seeker); ok {
+			if _, err := rs.Seek(h.segmentOffset+pos, io.SeekStart); err == nil {
+				// Parse just the Tags element: its end stops the parse.
+				h.infoDone = true
+				_ = mkvparse.Parse(r, h)
+			}
+		}
+	}
+
+	lookup := func(names ...string) string {
+		for _, n := range names {
+			if v := h.tags[n]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	if tm, basis := parseMatroskaDate(lookup("DATE_RECORDED")); tm != nil {
+		meta.TakenTime, meta.TakenBasis = tm, basis
+	} else if h.found {
+		tm := h.tm.UTC()
+		meta.TakenTime, meta.TakenBasis = &tm, TimeContainerUTC
+	}
+	if meta.CameraMaker == "" {
+		meta.CameraMaker = lookup("MAKE", "MANUFACTURER", strings.ToUpper(androidMake), strings.ToUpper(qtMake))
+	}
+	if meta.CameraModel == "" {
+		meta.CameraModel = lookup("MODEL", strings.ToUpper(androidModel), strings.ToUpper(qtModel))
+	}
+	if meta.Software == "" {
+		meta.Software = lookup("ENCODER")
+		if meta.Software == "" {
+			meta.Software = h.writingApp
+		}
+	}
+}
+
+// parseMatroskaDate parses a Matroska date tag ("2024-06-01 12:30:05.000",
+// possibly truncated to the date). The tagging spec defines these as UTC;
+// values carrying an explicit offset are zoned.
+func parseMatroskaDate(s string) (*time.Time, TimeBasis) {
+	if s == "" {
+		return nil, TimeFloating
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02 15:04", "2006-01-02"} {
+		if tm, err := time.Parse(layout, s); err == nil {
+			return &tm, TimeContainerUTC
+		}
+	}
+	tm, basis := parseXMPDate(s)
+	if tm != nil && basis == TimeFloating {
+		basis = TimeContainerUTC
+	}
+	return tm, basis
+}
@@ -0,0 +1,175 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	mkvparse "github.com/remko/go-mkvparse"
+)
+
+// ebml returns an EBML element with the given ID and payload, its size coded
+// on 8 bytes.
+func ebml(id uint32, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, id)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	b = append(b, 0x01)
+	b = append(b, binary.BigEndian.AppendUint64(nil, uint64(len(body)))[1:]...)
+	return append(b, body...)
+}
+
+// ebmlUnknownSize returns the header of a master element of unknown size, as
+// live recorders (OBS) write Segments and Clusters.
+func ebmlUnknownSize(id uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, id)
+	return append(b, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+}
+
+func mkvSimpleTag(name, value string) []byte {
+	return ebml(uint32(mkvparse.SimpleTagElement),
+		ebml(uint32(mkvparse.TagNameElement), []byte(name)),
+		ebml(uint32(mkvparse.TagStringElement), []byte(value)))
+}
+
+func mkvTags(kv ...string) []byte {
+	var simple [][]byte
+	for i := 0; i+1 < len(kv); i += 2 {
+		simple = append(simple, mkvSimpleTag(kv[i], kv[i+1]))
+	}
+	return ebml(uint32(mkvparse.TagsElement), ebml(uint32(mkvparse.TagElement), simple...))
+}
+
+func mkvInfo(dateUTC time.Time, writingApp string) []byte {
+	ns := dateUTC.Sub(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)).Nanoseconds()
+	return ebml(uint32(mkvparse.InfoElement),
+		ebml(uint32(mkvparse.DateUTCElement), binary.BigEndian.AppendUint64(nil, uint64(ns))),
+		ebml(uint32(mkvparse.WritingAppElement), []byte(writingApp)))
+}
+
+// mkvCluster returns a known-size Cluster with a SimpleBlock of n bytes.
+func mkvCluster(n int) []byte {
+	return ebml(uint32(mkvparse.ClusterElement),
+		ebml(uint32(mkvparse.TimecodeElement), []byte{0}),
+		ebml(uint32(mkvparse.SimpleBlockElement), make([]byte, n)))
+}
+
+var mkvHeader = ebml(uint32(mkvparse.EBMLElement), ebml(uint32(mkvparse.DocTypeElement), []byte("matroska")))
+
+// countingReader counts the bytes read through it. It is not an io.Seeker.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestReadMatroskaMetadata(t *testing.T) {
+	dateUTC := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clusters := bytes.Repeat(mkvCluster(4096), 64)
+
+	t.Run("tags ahead of the media data", func(t *testing.T) {
+		file := bytes.Join([][]byte{mkvHeader, ebml(uint32(mkvparse.SegmentElement),
+			mkvInfo(dateUTC, "obs-output module"),
+			mkvTags("DATE_RECORDED", "2024-06-01 10:30:05", "ENCODER", "Lavf60.16.100", "MAKE", "GoPro", "Model", "HERO12 Black"),
+			clusters)}, nil)
+		r := &countingReader{r: bytes.NewReader(file)}
+		meta := &FileMetadata{}
+		readMatroskaMetadata(r, meta)
+		if want := time.Date(2024, 6, 1, 10, 30, 5, 0, time.UTC); meta.TakenTime == nil || !meta.TakenTime.Equal(want) || meta.TakenBasis != TimeContainerUTC {
+			t.Errorf("TakenTime = %v (%v); want DATE_RECORDED %v, container UTC", meta.TakenTime, meta.TakenBasis, want)
+		}
+		if meta.Software != "Lavf60.16.100" || meta.CameraMaker != "GoPro" || meta.CameraModel != "HERO12 Black" {
+			t.Errorf("software, camera = %q, %q %q; want Lavf60.16.100, GoPro HERO12 Black", meta.Software, meta.CameraMaker, meta.CameraModel)
+		}
+		if r.n >= int64(len(file)-len(clusters)/2) {
+			t.Errorf("read %d of %d bytes; want reading to stop before the clusters", r.n, len(file))
+		}
+	})
+
+	// Tags written after the clusters, found through the SeekHead.
+	info := mkvInfo(dateUTC, "mkvmerge v80.0")
+	tags := mkvTags("ENCODER", "mkvmerge")
+	seekHeadSize := len(ebml(uint32(mkvparse.SeekHeadElement), ebml(uint32(mkvparse.SeekElement),
+		ebml(uint32(mkvparse.SeekIDElement), u32(uint32(mkvparse.TagsElement))),
+		ebml(uint32(mkvparse.SeekPositionElement), make([]byte, 8)))))
+	tagsPos := seekHeadSize + len(info) + len(clusters)
+	seekHead := ebml(uint32(mkvparse.SeekHeadElement), ebml(uint32(mkvparse.SeekElement),
+		ebml(uint32(mkvparse.SeekIDElement), u32(uint32(mkvparse.TagsElement))),
+		ebml(uint32(mkvparse.SeekPositionElement), binary.BigEndian.AppendUint64(nil, uint64(tagsPos)))))
+	trailing := bytes.Join([][]byte{mkvHeader, ebml(uint32(mkvparse.SegmentElement), seekHead, info, clusters, tags)}, nil)
+
+	t.Run("tags after the media data, seekable", func(t *testing.T) {
+		meta := &FileMetadata{}
+		readMatroskaMetadata(bytes.NewReader(trailing), meta)
+		if meta.Software != "mkvmerge" {
+			t.Errorf("Software = %q; want the trailing ENCODER tag", meta.Software)
+		}
+		if meta.TakenTime == nil || !meta.TakenTime.Equal(dateUTC) {
+			t.Errorf("TakenTime = %v; want DateUTC %v", meta.TakenTime, dateUTC)
+		}
+	})
+
+	t.Run("tags after the media data, streamed", func(t *testing.T) {
+		r := &countingReader{r: bytes.NewReader(trailing)}
+		meta := &FileMetadata{}
+		readMatroskaMetadata(r, meta)
+		if meta.Software != "mkvmerge v80.0" {
+			t.Errorf("Software = %q; want the WritingApp fallback", meta.Software)
+		}
+		if meta.TakenTime == nil || !meta.TakenTime.Equal(dateUTC) {
+			t.Errorf("TakenTime = %v; want DateUTC %v", meta.TakenTime, dateUTC)
+		}
+		if r.n >= int64(len(trailing)/2) {
+			t.Errorf("read %d of %d bytes; want reading to stop at the first cluster", r.n, len(trailing))
+		}
+	})
+}
+
+// liveMKV returns a stream shaped like an OBS recording of about size bytes:
+// an unknown-size Segment with Info and Tags, then unknown-size Clusters of
+// 1 MiB blocks. The clusters are generated as they are read.
+func liveMKV(size int64) io.Reader {
+	head := bytes.Join([][]byte{
+		mkvHeader, ebmlUnknownSize(uint32(mkvparse.SegmentElement)),
+		mkvInfo(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), "obs-output module"),
+		mkvTags("ENCODER", "Lavf60.16.100"),
+	}, nil)
+	cluster := append(ebmlUnknownSize(uint32(mkvparse.ClusterElement)),
+		ebml(uint32(mkvparse.SimpleBlockElement), make([]byte, 1<<20))...)
+	n := int(size / int64(len(cluster)))
+	readers := []io.Reader{bytes.NewReader(head)}
+	for range n {
+		readers = append(readers, bytes.NewReader(cluster))
+	}
+	return io.MultiReader(readers...)
+}
+
+// BenchmarkReadMatroskaMetadata compares reading the metadata of ever larger
+// recordings with the early exit (stays flat) against a full mkvparse.Parse
+// (grows with the file).
+func BenchmarkReadMatroskaMetadata(b *testing.B) {
+	for _, size := range []int64{16 << 20, 256 << 20, 2 << 30} {
+		b.Run(fmt.Sprintf("early-exit/%dMiB", size>>20), func(b *testing.B) {
+			for range b.N {
+				readMatroskaMetadata(liveMKV(size), &FileMetadata{})
+			}
+		})
+	}
+	for _, size := range []int64{16 << 20, 256 << 20} {
+		b.Run(fmt.Sprintf("full-parse/%dMiB", size>>20), func(b *testing.B) {
+			for range b.N {
+				_ = mkvparse.Parse(liveMKV(size), &dateHandler{})
+			}
+		})
+	}
+}
@@ -1,25 +1,128 @@
 package file
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	mp4 "github.com/abema/go-mp4"
-	mkvparse "github.com/remko/go-mkvparse"
+	ffcfg "github.com/dkarlovi/fileferry/config"
 	"github.com/rwcarlsen/goexif/exif"
 )
 
 type FileMetadata struct {
-	TakenTime   *time.Time
+	// TakenTime is when the picture or video was taken. Extractors fill it
+	// from the original capture time only; takenByPrecedence may then fill it
+	// from the other times as the profile allows.
+	TakenTime *time.Time
+	// TakenBasis says how TakenTime relates to a time zone; anchorTimes
+	// uses it to pin the time to the profile's timezone.
+	TakenBasis TimeBasis
+	// DigitizedTime is when the image was stored digitally (EXIF
+	// DateTimeDigitized), which differs from TakenTime for scans.
+	DigitizedTime  *time.Time
+	DigitizedBasis TimeBasis
+	// ModifiedTime is when the file was last changed according to its
+	// metadata (EXIF DateTime), typically written by editors.
+	ModifiedTime  *time.Time
+	ModifiedBasis TimeBasis
+
 	Extension   string
 	CameraMaker string
 	CameraModel string
+	// Software is what wrote the file: the camera firmware or editor (EXIF
+	// Software), or the encoder of a video.
+	Software string
+
+	// Location is where the picture or video was taken, nil if unknown.
+	Location *GeoLocation
+
+	// Rating is the XMP star rating (0–5, -1 for rejected), nil if unrated.
+	Rating *int
+	// Keywords are the XMP dc:subject keywords.
+	Keywords []string
+
+	// Custom are values external extractors report beyond the fields above,
+	// by key, for {meta.custom.<key>} tokens.
+	Custom map[string]string
+
+	// Provenance names where each field came from, by field ("taken",
+	// "camera.model", "custom.<key>", …; see metadataFields): the extractor that read it
+	// ("image", "video", or one registered with DefaultExtractors), or
+	// "exiftool", "ffprobe", "filename" or "xmp".
+	Provenance map[string]string
+
+	// embeddedXMP is the metadata of the XMP packet embedded in the file.
+	// It is kept apart for applyXMP to merge according to the profile's
+	// xmp setting, together with any sidecar.
+	embeddedXMP *FileMetadata
+}
+
+// GeoLocation is a position in decimal degrees.
+type GeoLocation struct {
+	Latitude, Longitude float64
+}
+
+// timeOf returns the time a token refers to: "taken", "digitized" or
+// "modified".
+func (m *FileMetadata) timeOf(kind string) *time.Time {
+	switch kind {
+	case "taken":
+		return m.TakenTime
+	case "digitized":
+		return m.DigitizedTime
+	case "modified":
+		return m.ModifiedTime
+	}
+	return nil
+}
+
+// takenByPrecedence returns a copy of meta whose TakenTime is the first of
+// the times named in order ("original", "digitized", "modified") that the
+// file records, or nil if it records none of them. A nil order means
+// ffcfg.TakenTimeSources, so edited photos without an original capture time
+// still get one, but never in place of it.
+func takenByPrecedence(meta *FileMetadata, order []string) *FileMetadata {
+	if meta == nil {
+		return nil
+	}
+	if order == nil {
+		order = ffcfg.TakenTimeSources
+	}
+	resolved := *meta
+	resolved.TakenTime, resolved.TakenBasis = nil, TimeFloating
+	resolved.Provenance = maps.Clone(meta.Provenance)
+	delete(resolved.Provenance, "taken")
+	for _, src := range order {
+		var tm *time.Time
+		var basis TimeBasis
+		field := src
+		switch src {
+		case "original":
+			tm, basis = meta.TakenTime, meta.TakenBasis
+			field = "taken"
+		case "digitized":
+			tm, basis = meta.DigitizedTime, meta.DigitizedBasis
+		case "modified":
+			tm, basis = meta.ModifiedTime, meta.ModifiedBasis
+		}
+		if tm != nil {
+			resolved.TakenTime, resolved.TakenBasis = tm, basis
+			if source, ok := meta.Provenance[field]; ok {
+				resolved.setProvenance("taken", source)
+			}
+			break
+		}
+	}
+	return &resolved
 }
 
 type FilenameMetaRule struct {
@@ -51,22 +154,33 @@ var FilenameMetaFormatVariants = map[string][]FilenameMetaFormat{
 var FilenameMetaRules = []FilenameMetaRule{
 	{Path: "meta.taken.date", Exp: `\d{4}-\d{2}-\d{2}`, Format: "2006-01-02"},
 	{Path: "meta.taken.time", Exp: `\d{2}-\d{2}-\d{2}`, Format: "15-04-05"},
+	// Milliseconds, added to the parsed time; on its own it populates nothing.
+	{Path: "meta.taken.ms", Exp: `\d{3}`},
+	// Seconds and milliseconds since the Unix epoch (see parseUnixTime).
+	{Path: "meta.taken.unix", Exp: `\d{10}`},
+	{Path: "meta.taken.unixms", Exp: `\d{13}`},
 }
 
-// handler to capture DateUTC element from Matroska files
-type dateHandler struct {
-	mkvparse.DefaultHandler
-	found bool
-	tm    time.Time
-}
+// minUnixTime is the earliest time a Unix timestamp in a filename may stand
+// for, and a day from now the latest, so that most other numbers of the same
+// length, such as phone numbers, aren't read as dates.
+var minUnixTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
 
-func (h *dateHandler) HandleDate(id mkvparse.ElementID, v time.Time, info mkvparse.ElementInfo) error {
-	if id == mkvparse.DateUTCElement && !h.found {
-		h.found = true
-		h.tm = v
-		// return nil and let parser finish or short-circuit by returning a special error? mkvparse doesn't define short-circuit error, so we capture and let it finish
+// parseUnixTime parses seconds (or, with ms, milliseconds) since the Unix
+// epoch, accepting only times from minUnixTime to a day after now.
+func parseUnixTime(s string, ms bool, now time.Time) (time.Time, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
 	}
-	return nil
+	tm := time.Unix(n, 0)
+	if ms {
+		tm = time.UnixMilli(n)
+	}
+	if tm.Before(minUnixTime) || tm.After(now.Add(24*time.Hour)) {
+		return time.Time{}, false
+	}
+	return tm, true
 }
 
 // normalizeExt lowercases an extension and strips a leading dot.
@@ -85,36 +199,40 @@ func extractImageMetadata(path string) (*FileMetadata, error) {
 	return extractImageMetadataFromEntry(&localEntry{path: path, info: fi})
 }
 
-// extractImageMetadataFromEntry reads image EXIF metadata by streaming the
-// entry's content. This works for JPEG and TIFF-based RAW (DNG, ARW, …) over
-// any source, including MTP. The exiftool fallback needs a real path, so it
+// extractImageMetadataFromEntry reads image EXIF metadata from the entry's
+// content. This works for JPEG, HEIF/AVIF and RAW (DNG, ARW, CR3, RAF, ORF,
+// RW2, …; see decodeEntryExif) over any source, including MTP. The exiftool fallback needs a real path, so it
 // runs only for entries that expose one (local files).
 func extractImageMetadataFromEntry(e Entry) (*FileMetadata, error) {
 	meta := &FileMetadata{Extension: normalizeExt(filepath.Ext(e.Name()))}
 
-	rc, err := e.Open()
-	if err == nil {
-		func() {
-			defer rc.Close()
-			x, err := exif.Decode(rc)
-			if err != nil {
-				return
-			}
-			if tm, err := x.DateTime(); err == nil {
-				localTm := tm.Local()
-				meta.TakenTime = &localTm
-			}
-			if maker, err := x.Get(exif.Make); err == nil {
-				if makerStr, err := maker.StringVal(); err == nil {
-					meta.CameraMaker = strings.TrimSpace(makerStr)
-				}
+	x, xmp, other := readEmbeddedMetadata(e)
+	if x != nil {
+		meta.TakenTime, meta.TakenBasis = exifTime(x, exifOriginal)
+		meta.DigitizedTime, meta.DigitizedBasis = exifTime(x, exifDigitized)
+		meta.ModifiedTime, meta.ModifiedBasis = exifTime(x, exifModified)
+		if maker, err := x.Get(exif.Make); err == nil {
+			if makerStr, err := maker.StringVal(); err == nil {
+				meta.CameraMaker = strings.TrimSpace(makerStr)
 			}
-			if model, err := x.Get(exif.Model); err == nil {
-				if modelStr, err := model.StringVal(); err == nil {
-					meta.CameraModel = strings.TrimSpace(modelStr)
-				}
+		}
+		if model, err := x.Get(exif.Model); err == nil {
+			if modelStr, err := model.StringVal(); err == nil {
+				meta.CameraModel = strings.TrimSpace(modelStr)
 			}
-		}()
+		}
+		meta.Software, _ = exifString(x, exif.Software)
+		if xmp == nil {
+			xmp = exifXMP(x)
+		}
+	}
+	// EXIF takes precedence over what the container says elsewhere (PNG
+	// text and tIME chunks).
+	if other != nil {
+		fillMissing(meta, other)
+	}
+	if xmp != nil {
+		meta.embeddedXMP, _ = parseXMP(xmp)
 	}
 
 	// Fallback to exiftool if direct EXIF reading failed or didn't get all data.
@@ -122,15 +240,8 @@ func extractImageMetadataFromEntry(e Entry) (*FileMetadata, error) {
 	if meta.TakenTime == nil || meta.CameraMaker == "" || meta.CameraModel == "" {
 		if lp, ok := e.(localPathProvider); ok {
 			if exiftoolMeta := extractImageMetadataWithExiftool(lp.LocalPath()); exiftoolMeta != nil {
-				if meta.TakenTime == nil && exiftoolMeta.TakenTime != nil {
-					meta.TakenTime = exiftoolMeta.TakenTime
-				}
-				if meta.CameraMaker == "" && exiftoolMeta.CameraMaker != "" {
-					meta.CameraMaker = exiftoolMeta.CameraMaker
-				}
-				if meta.CameraModel == "" && exiftoolMeta.CameraModel != "" {
-					meta.CameraModel = exiftoolMeta.CameraModel
-				}
+				exiftoolMeta.attribute("exiftool")
+				fillMissing(meta, exiftoolMeta)
 			}
 		}
 	}
@@ -138,10 +249,181 @@ func extractImageMetadataFromEntry(e Entry) (*FileMetadata, error) {
 	return meta, nil
 }
 
-// extractImageMetadataWithExiftool uses exiftool command as fallback for EXIF extraction
+// metadataFields are the FileMetadata fields merging considers, by the names
+// Provenance records them under.
+var metadataFields = []struct {
+	name string
+	has  func(m *FileMetadata) bool
+	copy func(dst, src *FileMetadata)
+}{
+	{"taken", func(m *FileMetadata) bool { return m.TakenTime != nil }, func(dst, src *FileMetadata) {
+		dst.TakenTime, dst.TakenBasis = src.TakenTime, src.TakenBasis
+	}},
+	{"digitized", func(m *FileMetadata) bool { return m.DigitizedTime != nil }, func(dst, src *FileMetadata) {
+		dst.DigitizedTime, dst.DigitizedBasis = src.DigitizedTime, src.DigitizedBasis
+	}},
+	{"modified", func(m *FileMetadata) bool { return m.ModifiedTime != nil }, func(dst, src *FileMetadata) {
+		dst.ModifiedTime, dst.ModifiedBasis = src.ModifiedTime, src.ModifiedBasis
+	}},
+	{"camera.maker", func(m *FileMetadata) bool { return m.CameraMaker != "" }, func(dst, src *FileMetadata) { dst.CameraMaker = src.CameraMaker }},
+	{"camera.model", func(m *FileMetadata) bool { return m.CameraModel != "" }, func(dst, src *FileMetadata) { dst.CameraModel = src.CameraModel }},
+	{"software", func(m *FileMetadata) bool { return m.Software != "" }, func(dst, src *FileMetadata) { dst.Software = src.Software }},
+	{"location", func(m *FileMetadata) bool { return m.Location != nil }, func(dst, src *FileMetadata) { dst.Location = src.Location }},
+	{"rating", func(m *FileMetadata) bool { return m.Rating != nil }, func(dst, src *FileMetadata) { dst.Rating = src.Rating }},
+	{"keywords", func(m *FileMetadata) bool { return len(m.Keywords) > 0 }, func(dst, src *FileMetadata) { dst.Keywords = src.Keywords }},
+}
+
+// fillMissing copies the fields src has and dst lacks, along with their
+// provenance. It is the one merge step: extractors, the registry, filename
+// patterns and XMP all combine metadata through it.
+func fillMissing(dst, src *FileMetadata) {
+	for _, f := range metadataFields {
+		if f.has(dst) || !f.has(src) {
+			continue
+		}
+		f.copy(dst, src)
+		if source, ok := src.Provenance[f.name]; ok {
+			dst.setProvenance(f.name, source)
+		}
+	}
+	for key, v := range src.Custom {
+		if _, ok := dst.Custom[key]; ok {
+			continue
+		}
+		if dst.Custom == nil {
+			dst.Custom = make(map[string]string)
+		}
+		dst.Custom[key] = v
+		if source, ok := src.Provenance["custom."+key]; ok {
+			dst.setProvenance("custom."+key, source)
+		}
+	}
+}
+
+// attribute records source as the provenance of the fields m has that don't
+// record one yet.
+func (m *FileMetadata) attribute(source string) {
+	for _, f := range metadataFields {
+		if _, ok := m.Provenance[f.name]; f.has(m) && !ok {
+			m.setProvenance(f.name, source)
+		}
+	}
+	for key := range m.Custom {
+		if _, ok := m.Provenance["custom."+key]; !ok {
+			m.setProvenance("custom."+key, source)
+		}
+	}
+}
+
+func (m *FileMetadata) setProvenance(field, source string) {
+	if m.Provenance == nil {
+		m.Provenance = make(map[string]string)
+	}
+	m.Provenance[field] = source
+}
+
+// readEmbeddedMetadata reads the metadata blocks embedded in an image entry:
+// its EXIF, its XMP packet and, for PNG, what its other chunks record. Each
+// is nil when absent or unreadable. JPEG, PNG, WebP and TIFF-based files are
+// read sequentially, as are RAF (via its embedded JPEG) and the ORF/RW2 TIFF
+// dialects (with their header patched). HEIF, AVIF and CR3 keep EXIF in boxes
+// of an ISOBMFF container, which needs random access to locate.
+func readEmbeddedMetadata(e Entry) (x exifTags, xmp []byte, other *FileMetadata) {
+	ext := normalizeExt(filepath.Ext(e.Name()))
+	switch ext {
+	case "heic", "heif", "avif", "cr3":
+		ra, size, cleanup, err := asReaderAt(e)
+		if err != nil {
+			return nil, nil, nil
+		}
+		defer cleanup()
+		if ext == "cr3" {
+			if m, err := cr3Exif(ra, size); err == nil {
+				x = m
+			}
+			return x, nil, nil
+		}
+		if r, err := heifExifReader(ra, size); err == nil {
+			x = decodeExif(r)
+		}
+		return x, nil, nil
+	}
+
+	rc, err := e.Open()
+	if err != nil {
+		return nil, nil, nil
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	switch ext {
+	case "jpg", "jpeg":
+		header, xmp, err := readJPEGHeader(rc)
+		if err != nil {
+			return nil, nil, nil
+		}
+		return decodeExif(bytes.NewReader(header)), xmp, nil
+	case "png", "webp":
+		var c *chunkMetadata
+		if ext == "png" {
+			c, err = readPNGMetadata(rc)
+		} else {
+			c, err = readWebPMetadata(rc)
+		}
+		if err != nil {
+			return nil, nil, nil
+		}
+		if len(c.exif) > 0 {
+			x = decodeExif(bytes.NewReader(c.exif))
+		}
+		return x, c.xmp, &c.meta
+	case "raf":
+		r, err = rafExifReader(rc)
+	case "orf", "rw2":
+		r, err = tiffVariantReader(rc)
+	}
+	if err != nil {
+		return nil, nil, nil
+	}
+	return decodeExif(r), nil, nil
+}
+
+// decodeExif decodes EXIF data, returning nil (rather than a typed nil
+// pointer) when that fails.
+func decodeExif(r io.Reader) exifTags {
+	x, err := exif.Decode(r)
+	if err != nil {
+		return nil
+	}
+	return x
+}
+
+// exiftoolDateTags names the exiftool tags making up each EXIF timestamp, in
+// the order date, sub-second, offset. exiftool calls DateTimeDigitized
+// "CreateDate" and DateTime "ModifyDate".
+var exiftoolDateTags = [3][3]string{
+	{"DateTimeOriginal", "SubSecTimeOriginal", "OffsetTimeOriginal"},
+	{"CreateDate", "SubSecTimeDigitized", "OffsetTimeDigitized"},
+	{"ModifyDate", "SubSecTime", "OffsetTime"},
+}
+
+// exiftoolTags are the tags requested from exiftool, all in one call. The "#"
+// suffix asks for GPS coordinates as signed decimal degrees.
+var exiftoolTags = func() []string {
+	var tags []string
+	for _, date := range exiftoolDateTags {
+		tags = append(tags, date[:]...)
+	}
+	return append(tags, "Make", "Model", "Software", "GPSLatitude#", "GPSLongitude#")
+}()
+
+// extractImageMetadataWithExiftool uses exiftool as fallback for EXIF
+// extraction. Requests go to the shared pool of exiftool processes.
 func extractImageMetadataWithExiftool(path string) *FileMetadata {
-	cmd := exec.Command("exiftool", "-j", "-CreateDate", "-Make", "-Model", path)
-	out, err := cmd.Output()
+	args := []string{"-j"}
+	for _, tag := range exiftoolTags {
+		args = append(args, "-"+tag)
+	}
+	out, err := exiftoolProcs.Run(append(args, path)...)
 	if err != nil {
 		return nil
 	}
@@ -153,22 +435,9 @@ func extractImageMetadataWithExiftool(path string) *FileMetadata {
 
 	data := result[0]
 	meta := &FileMetadata{}
-
-	// Extract creation date
-	if createDate, ok := data["CreateDate"].(string); ok && createDate != "" {
-		// Try different date formats that exiftool might return
-		layouts := []string{
-			"2006:01:02 15:04:05",
-			"2006-01-02 15:04:05",
-			time.RFC3339,
-		}
-		for _, layout := range layouts {
-			if tm, err := time.ParseInLocation(layout, createDate, time.Local); err == nil {
-				meta.TakenTime = &tm
-				break
-			}
-		}
-	}
+	meta.TakenTime, meta.TakenBasis = exiftoolTime(data, exiftoolDateTags[0])
+	meta.DigitizedTime, meta.DigitizedBasis = exiftoolTime(data, exiftoolDateTags[1])
+	meta.ModifiedTime, meta.ModifiedBasis = exiftoolTime(data, exiftoolDateTags[2])
 
 	// Extract camera maker
 	if make, ok := data["Make"].(string); ok {
@@ -180,9 +449,63 @@ func extractImageMetadataWithExiftool(path string) *FileMetadata {
 		meta.CameraModel = strings.TrimSpace(model)
 	}
 
+	if software, ok := data["Software"].(string); ok {
+		meta.Software = strings.TrimSpace(software)
+	}
+	lat, latOK := data["GPSLatitude"].(float64)
+	lon, lonOK := data["GPSLongitude"].(float64)
+	if latOK && lonOK {
+		meta.Location = &GeoLocation{Latitude: lat, Longitude: lon}
+	}
+
 	return meta
 }
 
+// exiftoolTime reads one timestamp from exiftool's JSON output; tags names
+// its date, sub-second and offset tags.
+func exiftoolTime(data map[string]interface{}, tags [3]string) (*time.Time, TimeBasis) {
+	date, ok := data[tags[0]].(string)
+	if !ok || date == "" {
+		return nil, TimeFloating
+	}
+	// Try different date formats that exiftool might return
+	layouts := []string{
+		"2006:01:02 15:04:05",
+		"2006-01-02 15:04:05",
+		time.RFC3339,
+	}
+	var tm time.Time
+	var err error
+	for _, layout := range layouts {
+		if tm, err = time.Parse(layout, date); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, TimeFloating
+	}
+
+	// exiftool emits digit strings without leading zeros as JSON numbers.
+	var subsec string
+	switch v := data[tags[1]].(type) {
+	case string:
+		subsec = v
+	case float64:
+		subsec = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if ns, ok := parseSubSec(subsec); ok {
+		tm = tm.Add(ns)
+	}
+
+	if offset, ok := data[tags[2]].(string); ok {
+		if loc, ok := parseOffset(strings.TrimSpace(offset)); ok {
+			tm = wallClockIn(tm, loc)
+			return &tm, TimeZoned
+		}
+	}
+	return &tm, TimeFloating
+}
+
 // extractVideoMetadata reads video metadata from a file on disk. Thin wrapper
 // around extractVideoMetadataFromEntry kept for direct path callers (and tests).
 func extractVideoMetadata(path string) (*FileMetadata, error) {
@@ -194,21 +517,46 @@ func extractVideoMetadata(path string) (*FileMetadata, error) {
 }
 
 // extractVideoMetadataFromEntry reads video container metadata for the entry.
-// The mp4/mkv box parsers need random access, so for streamed (MTP) sources the
-// content is spooled to a temp file via asReaderAt. The ffprobe fallback needs a
-// real path and runs only for entries that expose one (local files).
+// The MP4 box parser (MP4, QuickTime, 3GP) needs random access, so for
+// streamed (MTP) sources the content is spooled to a temp file via
+// asReaderAt; Matroska, AVI and AVCHD are read as streams. The ffprobe
+// fallback needs a real path and runs only for entries that expose one
+// (local files).
 func extractVideoMetadataFromEntry(e Entry) (*FileMetadata, error) {
 	ext := normalizeExt(filepath.Ext(e.Name()))
 	meta := &FileMetadata{Extension: ext}
 
-	if ra, size, cleanup, err := asReaderAt(e); err == nil {
-		defer cleanup()
-		parseVideoBoxes(ra, size, ext, meta)
+	switch ext {
+	case "mkv", "webm", "avi", "mts", "m2ts":
+		// These are read as streams that stop ahead of the media data (or,
+		// for AVCHD, at the first GOP), so streamed (MTP) sources aren't
+		// spooled.
+		rc, err := e.Open()
+		if err != nil {
+			break
+		}
+		switch ext {
+		case "mkv", "webm":
+			readMatroskaMetadata(rc, meta)
+		case "avi":
+			readAVIMetadata(rc, meta)
+		default:
+			readAVCHDMetadata(rc, meta)
+		}
+		rc.Close()
+	default:
+		if ra, size, cleanup, err := asReaderAt(e); err == nil {
+			defer cleanup()
+			parseVideoBoxes(ra, size, ext, meta)
+		}
 	}
 
 	if meta.TakenTime == nil {
 		if lp, ok := e.(localPathProvider); ok {
-			parseVideoWithFfprobe(lp.LocalPath(), meta)
+			probed := &FileMetadata{}
+			parseVideoWithFfprobe(lp.LocalPath(), probed)
+			probed.attribute("ffprobe")
+			fillMissing(meta, probed)
 		}
 	}
 
@@ -250,53 +598,47 @@ func asReaderAt(e Entry) (ra io.ReaderAt, size int64, cleanup func(), err error)
 	return tmp, n, func() { tmp.Close(); os.Remove(tmp.Name()) }, nil
 }
 
-// parseVideoBoxes fills meta.TakenTime from mp4/mkv container metadata.
+// parseVideoBoxes fills meta from MP4/QuickTime container metadata: the taken
+// time, camera make, model and location.
 func parseVideoBoxes(ra io.ReaderAt, size int64, ext string, meta *FileMetadata) {
 	rs := io.NewSectionReader(ra, 0, size)
 	switch ext {
-	case "mp4", "m4v", "mov":
-		boxes, err := mp4.ExtractBoxWithPayload(rs, nil, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeMvhd()})
-		if err == nil && len(boxes) > 0 {
-			if mvhd, ok := boxes[0].Payload.(*mp4.Mvhd); ok {
-				var creationSecs uint64
-				if mvhd.CreationTimeV1 != 0 {
-					creationSecs = uint64(mvhd.CreationTimeV1)
-				} else if mvhd.CreationTimeV0 != 0 {
-					creationSecs = uint64(mvhd.CreationTimeV0)
-				}
-				if creationSecs != 0 {
-					epoch1904 := time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
-					tm := epoch1904.Add(time.Duration(creationSecs) * time.Second).Local()
-					meta.TakenTime = &tm
-				}
-			}
-		}
-	case "mkv", "webm":
-		dh := &dateHandler{}
-		if err := mkvparse.Parse(rs, dh); err == nil && dh.found {
-			localTm := dh.tm.Local()
-			meta.TakenTime = &localTm
-		}
+	case "mp4", "m4v", "mov", "3gp", "3g2":
+		readQuickTimeMetadata(rs, meta)
 	}
 }
 
+// ffprobe, unlike exiftool, can't serve several files from one process, so
+// each file still costs a run. It is looked up in PATH once, so files aren't
+// probed at all without it, and a run that hangs (on a damaged file) is
+// killed after ffprobeTimeout.
+var ffprobePath = sync.OnceValues(func() (string, error) { return exec.LookPath("ffprobe") })
+
+const ffprobeTimeout = 30 * time.Second
+
 // parseVideoWithFfprobe fills meta from ffprobe output (camera maker/model and
 // creation time). Requires a real filesystem path.
 func parseVideoWithFfprobe(path string, meta *FileMetadata) {
-	cmd := exec.Command("ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", path)
+	ffprobe, err := ffprobePath()
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ffprobeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffprobe, "-v", "quiet", "-print_format", "json", "-show_entries", "format_tags", path)
 	out, err := cmd.Output()
 	if err != nil {
 		return
 	}
-	var ffprobe struct {
+	var probed struct {
 		Format struct {
 			Tags map[string]string `json:"tags"`
 		} `json:"format"`
 	}
-	if err := json.Unmarshal(out, &ffprobe); err != nil {
+	if err := json.Unmarshal(out, &probed); err != nil {
 		return
 	}
-	tags := ffprobe.Format.Tags
+	tags := probed.Format.Tags
 
 	lookup := func(keys ...string) string {
 		for _, k := range keys {
@@ -307,8 +649,12 @@ func parseVideoWithFfprobe(path string, meta *FileMetadata) {
 		return ""
 	}
 
-	meta.CameraMaker = lookup("com.android.manufacturer", "make", "manufacturer")
-	meta.CameraModel = lookup("com.android.model", "model")
+	if meta.CameraMaker == "" {
+		meta.CameraMaker = lookup("com.android.manufacturer", "make", "manufacturer")
+	}
+	if meta.CameraModel == "" {
+		meta.CameraModel = lookup("com.android.model", "model")
+	}
 
 	ct := lookup("creation_time")
 	if ct != "" {
@@ -319,76 +665,38 @@ func parseVideoWithFfprobe(path string, meta *FileMetadata) {
 		}
 		for _, layout := range layouts {
 			if tm, err := time.Parse(layout, ct); err == nil {
-				localTm := tm.Local()
-				meta.TakenTime = &localTm
+				meta.TakenTime = &tm
+				meta.TakenBasis = TimeContainerUTC
 				break
 			}
 		}
 	}
 }
 
+// parseMetadataFromFilenamePattern returns the metadata filename records by
+// pattern (see compileFilenamePattern), or nil if it doesn't match or gives
+// no taken time. A preset ("@android", see FilenamePresets) gives the
+// metadata of the first of its patterns that does.
 func parseMetadataFromFilenamePattern(filename, pattern string) *FileMetadata {
-	ext := filepath.Ext(filename)
-	input := filename
-	regexPattern := pattern
-	groupMap := make(map[string]string)
-	formatMap := make(map[string]string) // Maps token path to its time format
-
-	// First, handle tokens with format specifiers (e.g., {meta.taken.time:hhmmss})
-	tokenWithFormatRegex := regexp.MustCompile(`\{([^}:]+):([^}]+)\}`)
-	matches := tokenWithFormatRegex.FindAllStringSubmatch(pattern, -1)
-	for _, match := range matches {
-		fullToken := match[0]  // e.g., "{meta.taken.time:hhmmss}"
-		tokenPath := match[1]  // e.g., "meta.taken.time"
-		formatSpec := match[2] // e.g., "hhmmss"
-
-		// Check if this token supports format variants
-		if variants, ok := FilenameMetaFormatVariants[tokenPath]; ok {
-			// Find the matching format variant
-			for _, variant := range variants {
-				if variant.Specifier == formatSpec {
-					grp := strings.ReplaceAll(tokenPath, ".", "_")
-					regexPattern = strings.Replace(regexPattern, fullToken, "(?P<"+grp+">"+variant.Regex+")", 1)
-					groupMap[grp] = tokenPath
-					formatMap[tokenPath] = variant.TimeLayout
-					break
-				}
-			}
-		}
-	}
-
-	// Then, handle tokens without format specifiers (use default patterns)
-	for _, rule := range FilenameMetaRules {
-		tokenWithoutFormat := "{" + rule.Path + "}"
-		if strings.Contains(regexPattern, tokenWithoutFormat) {
-			grp := strings.ReplaceAll(rule.Path, ".", "_")
-			regexPattern = strings.ReplaceAll(regexPattern, tokenWithoutFormat, "(?P<"+grp+">"+rule.Exp+")")
-			groupMap[grp] = rule.Path
-			if rule.Format != "" {
-				formatMap[rule.Path] = rule.Format
+	if strings.HasPrefix(pattern, presetPrefix) {
+		patterns, _ := presetPatterns(pattern)
+		for _, p := range patterns {
+			if meta := parseMetadataFromFilenamePattern(filename, p); meta != nil {
+				return meta
 			}
 		}
+		return nil
 	}
-
-	regexPattern = "^" + regexPattern + "$"
-	re, err := regexp.Compile(regexPattern)
+	ext := filepath.Ext(filename)
+	p, err := compileFilenamePattern(pattern)
 	if err != nil {
 		return nil
 	}
-	match := re.FindStringSubmatch(input)
-	if match == nil {
+	groups, ok := p.match(filename)
+	if !ok {
 		return nil
 	}
-	groups := make(map[string]string)
-	for i, n := range re.SubexpNames() {
-		if i > 0 && n != "" {
-			if orig, ok := groupMap[n]; ok {
-				groups[orig] = match[i]
-			} else {
-				groups[n] = match[i]
-			}
-		}
-	}
+	formatMap := p.formats
 	meta := &FileMetadata{
 		Extension: strings.TrimPrefix(ext, "."),
 	}
@@ -414,7 +722,22 @@ func parseMetadataFromFilenamePattern(filename, pattern string) *FileMetadata {
 			}
 		}
 	}
+	for _, token := range []string{"meta.taken.unix", "meta.taken.unixms"} {
+		if v, ok := groups[token]; ok {
+			tm, ok := parseUnixTime(v, token == "meta.taken.unixms", time.Now())
+			if !ok {
+				return nil
+			}
+			meta.TakenTime, meta.TakenBasis = &tm, TimeInstant
+		}
+	}
 	if meta.TakenTime != nil {
+		if ms, ok := groups["meta.taken.ms"]; ok {
+			if n, err := strconv.Atoi(ms); err == nil {
+				tm := meta.TakenTime.Add(time.Duration(n) * time.Millisecond)
+				meta.TakenTime = &tm
+			}
+		}
 		return meta
 	}
 	return nil
@@ -7,6 +7,59 @@ import (
 	mkvparse "github.com/remko/go-mkvparse"
 )
 
+func TestTakenByPrecedence(t *testing.T) {
+	original := time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC)
+	digitized := time.Date(2024, 1, 20, 8, 0, 0, 0, time.UTC)
+	modified := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		meta  FileMetadata
+		order []string
+		want  *time.Time
+		basis TimeBasis
+	}{
+		{
+			name:  "default prefers original",
+			meta:  FileMetadata{TakenTime: &original, DigitizedTime: &digitized, ModifiedTime: &modified},
+			want:  &original,
+			basis: TimeFloating,
+		},
+		{
+			name:  "default falls back to modified",
+			meta:  FileMetadata{ModifiedTime: &modified, ModifiedBasis: TimeZoned},
+			want:  &modified,
+			basis: TimeZoned,
+		},
+		{
+			name:  "configured order",
+			meta:  FileMetadata{TakenTime: &original, DigitizedTime: &digitized, ModifiedTime: &modified},
+			order: []string{"digitized", "original"},
+			want:  &digitized,
+		},
+		{
+			name:  "order excluding modified",
+			meta:  FileMetadata{ModifiedTime: &modified},
+			order: []string{"original", "digitized"},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := takenByPrecedence(&tt.meta, tt.order)
+			if !timesEqual(got.TakenTime, tt.want) {
+				t.Errorf("TakenTime = %v; want %v", got.TakenTime, tt.want)
+			}
+			if got.TakenBasis != tt.basis {
+				t.Errorf("TakenBasis = %v; want %v", got.TakenBasis, tt.basis)
+			}
+			if got.ModifiedTime != tt.meta.ModifiedTime {
+				t.Errorf("ModifiedTime changed")
+			}
+		})
+	}
+}
+
 func TestParseMetadataFromFilenamePattern(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -95,12 +148,57 @@ func TestParseMetadataFromFilenamePattern(t *testing.T) {
 				Extension: "jpg",
 			},
 		},
+		{
+			name:     "pattern with milliseconds",
+			filename: "IMG_20230515_103045_120.jpg",
+			pattern:  "IMG_{meta.taken.date:yyyymmdd}_{meta.taken.time:hhmmss}_{meta.taken.ms}.jpg",
+			want: &FileMetadata{
+				TakenTime: timePtr(time.Date(2023, 5, 15, 10, 30, 45, 120*int(time.Millisecond), time.Local)),
+				Extension: "jpg",
+			},
+		},
 		{
 			name:     "no match - wrong hhmmss format",
 			filename: "2023-05-15 10-30-45.jpg",
 			pattern:  "{meta.taken.date} {meta.taken.time:hhmmss}.jpg",
 			want:     nil,
 		},
+		{
+			name:     "unix seconds",
+			filename: "photo_1700000000.jpg",
+			pattern:  "photo_{meta.taken.unix}.jpg",
+			want: &FileMetadata{
+				TakenTime: timePtr(time.Unix(1700000000, 0)),
+				Extension: "jpg",
+			},
+		},
+		{
+			name:     "unix milliseconds",
+			filename: "1700000000123.jpg",
+			pattern:  "{meta.taken.unixms}.jpg",
+			want: &FileMetadata{
+				TakenTime: timePtr(time.UnixMilli(1700000000123)),
+				Extension: "jpg",
+			},
+		},
+		{
+			name:     "no match - phone number as unix seconds",
+			filename: "0912345678.jpg",
+			pattern:  "{meta.taken.unix}.jpg",
+			want:     nil,
+		},
+		{
+			name:     "no match - unix seconds in the future",
+			filename: "9912345678.jpg",
+			pattern:  "{meta.taken.unix}.jpg",
+			want:     nil,
+		},
+		{
+			name:     "no match - unix milliseconds with too few digits",
+			filename: "170000000012.jpg",
+			pattern:  "{meta.taken.unixms}.jpg",
+			want:     nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -212,6 +310,30 @@ func timePtr(t time.Time) *time.Time {
 	return &t
 }
 
+func TestParseUnixTime(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		s      string
+		ms     bool
+		want   time.Time
+		wantOK bool
+	}{
+		{s: "1700000000", want: time.Unix(1700000000, 0), wantOK: true},
+		{s: "1700000000123", ms: true, want: time.UnixMilli(1700000000123), wantOK: true},
+		{s: "0946684800", want: time.Unix(946684800, 0), wantOK: true}, // 2000-01-01
+		{s: "0946684799"}, // just before
+		{s: "1717400000"}, // more than a day after now
+		{s: "1717243200000", ms: true, want: time.UnixMilli(1717243200000), wantOK: true},
+		{s: "0612345678"}, // a phone number
+	}
+	for _, tt := range tests {
+		got, ok := parseUnixTime(tt.s, tt.ms, now)
+		if ok != tt.wantOK || !got.Equal(tt.want) {
+			t.Errorf("parseUnixTime(%q, %v) = %v, %v; want %v, %v", tt.s, tt.ms, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
 func timesEqual(t1, t2 *time.Time) bool {
 	if t1 == nil && t2 == nil {
 		return true
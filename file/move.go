@@ -1,23 +1,22 @@
 package file
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
 )
 
-// MoveEntry moves a source entry to destPath on the local filesystem with a
-// copy → verify → delete strategy that is safe for MTP sources (which cannot be
-// renamed in place):
-//
-//  1. copy the content to a temporary file next to destPath, hashing as it goes;
-//  2. re-read the source and require its SHA-256 to equal the copy's, and its
-//     length to match the reported size;
-//  3. only then rename the temp file into place and delete the source.
-//
 // MoveOutcome describes how MoveEntry resolved a move.
 type MoveOutcome int
 
@@ -29,32 +28,281 @@ const (
 	// source was deleted without copying anything. This is not the happy path
 	// (it means a duplicate was downloaded), but it is not an error either.
 	Deduplicated
+	// Skipped means the destination holds a different file and the conflict
+	// policy chose to leave both files untouched.
+	Skipped
+	// Replaced means the destination held a different, older file, which was
+	// overwritten by the verified copy of the source (on_conflict: keep-newer).
+	Replaced
+	// Quarantined means the destination holds a different file, so the source
+	// was moved into the conflicts directory instead (on_conflict: quarantine).
+	Quarantined
+)
+
+// Conflict policies: what MoveEntryWithPolicy does when the destination
+// already holds a file with different content.
+const (
+	// ConflictError fails the move and leaves both files untouched.
+	ConflictError = "error"
+	// ConflictSkip leaves both files untouched and reports Skipped.
+	ConflictSkip = "skip"
+	// ConflictSuffix appends -1, -2, … to the destination's name until it
+	// finds a free path (Moved) or one holding the same content (Deduplicated).
+	ConflictSuffix = "suffix"
+	// ConflictKeepNewer overwrites the destination if the source's
+	// modification time is later (Replaced) and otherwise skips.
+	ConflictKeepNewer = "keep-newer"
+	// ConflictQuarantine moves the source into ConflictPolicy.QuarantineDir,
+	// suffixing its name there if needed.
+	ConflictQuarantine = "quarantine"
 )
 
+// ConflictPolicy is a profile's on_conflict setting. The zero value behaves
+// as ConflictError.
+type ConflictPolicy struct {
+	Mode          string
+	QuarantineDir string // used by ConflictQuarantine
+}
+
+// conflictPolicyFor returns the conflict policy configured for a profile.
+func conflictPolicyFor(prof ffcfg.ProfileConfig) ConflictPolicy {
+	return ConflictPolicy{Mode: prof.OnConflict, QuarantineDir: prof.ConflictsDir}
+}
+
+// MoveResult describes what MoveEntryWithPolicy did, or what
+// PreviewMoveWithPolicy predicts it would do.
+type MoveResult struct {
+	Outcome MoveOutcome
+	// Path is where the source ends up: the requested destination, a
+	// suffixed variant of it, or a path in the quarantine directory. For
+	// Skipped it is the requested destination.
+	Path string
+	// Conflict is the conflict policy mode that was applied, or "" if the
+	// destination held no different file.
+	Conflict string
+}
+
+// maxConflictSuffix bounds the search for a free suffixed name.
+const maxConflictSuffix = 10000
+
+// MoveEntry moves a source entry to destPath on the local filesystem. It is
+// MoveEntryWithPolicy with the ConflictError policy.
+func MoveEntry(entry Entry, destPath string) (MoveOutcome, error) {
+	res, err := MoveEntryWithPolicy(entry, destPath, ConflictPolicy{})
+	return res.Outcome, err
+}
+
+// MoveEntryWithPolicy moves a source entry to destPath on the local
+// filesystem with a copy → verify → delete strategy that is safe for MTP
+// sources (which cannot be renamed in place):
+//
+//  1. copy the content to a temporary file next to destPath, hashing as it goes;
+//  2. re-read the source and require its SHA-256 to equal the copy's, and its
+//     length to match the reported size;
+//  3. only then rename the temp file into place and delete the source.
+//
 // On any failure the temp file is removed and the source is left intact.
 //
 // If a file already exists at destPath, it is treated as a possible accidental
 // duplicate (e.g. the same file downloaded twice): the source and the existing
 // destination are compared by SHA-256. If they match, the destination is left
 // as-is and the source is deleted, as if this run had performed the move, and
-// Deduplicated is returned. If they differ, an error is returned and neither
-// file is touched.
-func MoveEntry(entry Entry, destPath string) (MoveOutcome, error) {
-	destDir := filepath.Dir(destPath)
-	if err := os.MkdirAll(destDir, 0755); err != nil {
-		return Moved, fmt.Errorf("create dir %s: %w", destDir, err)
+// Deduplicated is returned. If they differ, policy decides what happens; with
+// ConflictError an error is returned and neither file is touched.
+func MoveEntryWithPolicy(entry Entry, destPath string, policy ConflictPolicy) (MoveResult, error) {
+	res, err := planMove(entry, destPath, policy)
+	if err != nil {
+		return res, err
+	}
+
+	switch res.Outcome {
+	case Moved, Replaced, Quarantined:
+		err = transferEntry(entry, res.Path)
+	case Deduplicated:
+		// Identical content: this is a duplicate of a file already moved into place.
+		if err = entry.Delete(); err != nil {
+			err = fmt.Errorf("source %s is a duplicate of %s but failed to delete: %w", entry.DisplayPath(), res.Path, err)
+		}
+	}
+	return res, err
+}
+
+// PreviewMove reports what MoveEntry would do, without modifying either file.
+func PreviewMove(entry Entry, destPath string) (MoveOutcome, error) {
+	res, err := PreviewMoveWithPolicy(entry, destPath, ConflictPolicy{})
+	return res.Outcome, err
+}
+
+// PreviewMoveWithPolicy reports what MoveEntryWithPolicy would do, without
+// modifying either file. It is the dry-run counterpart: if the destination
+// already exists it hashes both files, returning Deduplicated when they match
+// and otherwise the outcome (and path) the conflict policy would produce.
+func PreviewMoveWithPolicy(entry Entry, destPath string, policy ConflictPolicy) (MoveResult, error) {
+	return planMove(entry, destPath, policy)
+}
+
+// planMove decides how a move resolves without modifying anything. It is
+// shared by the move and dry-run paths so both always agree.
+func planMove(entry Entry, destPath string, policy ConflictPolicy) (MoveResult, error) {
+	res := MoveResult{Outcome: Moved, Path: destPath}
+	state, differs, err := inspectDestination(entry, destPath)
+	if err != nil {
+		return res, err
+	}
+	switch state {
+	case destAbsent:
+		return res, nil
+	case destSame:
+		res.Outcome = Deduplicated
+		return res, nil
+	}
+
+	res.Conflict = policy.Mode
+	switch policy.Mode {
+	case "", ConflictError:
+		res.Conflict = ConflictError
+		return res, differs
+	case ConflictSkip:
+		res.Outcome = Skipped
+		return res, nil
+	case ConflictSuffix:
+		return freeSuffixedPath(entry, destPath, res)
+	case ConflictKeepNewer:
+		newer, err := newerThanDestination(entry, destPath)
+		if err != nil {
+			return res, err
+		}
+		if newer {
+			res.Outcome = Replaced
+		} else {
+			res.Outcome = Skipped
+		}
+		return res, nil
+	case ConflictQuarantine:
+		if policy.QuarantineDir == "" {
+			return res, fmt.Errorf("destination %s differs from source %s and no conflicts directory is configured for quarantine", destPath, entry.DisplayPath())
+		}
+		res.Outcome = Quarantined
+		res.Path = filepath.Join(policy.QuarantineDir, filepath.Base(destPath))
+		switch state, err := destinationState(entry, res.Path); {
+		case err != nil:
+			return res, err
+		case state == destSame:
+			// Already quarantined by an earlier run.
+			res.Outcome = Deduplicated
+			return res, nil
+		case state == destDiffers:
+			res, err = freeSuffixedPath(entry, res.Path, res)
+			if res.Outcome == Moved {
+				res.Outcome = Quarantined
+			}
+			return res, err
+		}
+		return res, nil
+	}
+	return res, fmt.Errorf("unknown conflict policy %q", policy.Mode)
+}
+
+// newerThanDestination reports whether entry was modified after the file at
+// destPath. A source without a known modification time (some MTP devices)
+// can't be shown to be newer, so it never replaces anything.
+func newerThanDestination(entry Entry, destPath string) (bool, error) {
+	info, err := os.Stat(destPath)
+	if err != nil {
+		return false, fmt.Errorf("stat destination %s: %w", destPath, err)
 	}
+	srcTime := entry.ModTime()
+	return !srcTime.IsZero() && srcTime.After(info.ModTime()), nil
+}
 
-	// A pre-existing destination is reconciled by checksum rather than blindly
-	// overwritten, so we never clobber a different file and never re-copy a
-	// duplicate we already have.
-	if info, err := os.Stat(destPath); err == nil {
-		if info.IsDir() {
-			return Moved, fmt.Errorf("destination %s is a directory", destPath)
+// freeSuffixedPath finds the first of path-1, path-2, … (the suffix goes
+// before the extension) that is either free (Moved) or already holds the
+// source's content (Deduplicated).
+func freeSuffixedPath(entry Entry, path string, res MoveResult) (MoveResult, error) {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	for i := 1; i <= maxConflictSuffix; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", stem, i, ext)
+		state, err := destinationState(entry, candidate)
+		if err != nil {
+			return res, err
+		}
+		switch state {
+		case destAbsent:
+			res.Outcome, res.Path = Moved, candidate
+			return res, nil
+		case destSame:
+			res.Outcome, res.Path = Deduplicated, candidate
+			return res, nil
 		}
-		return reconcileExisting(entry, destPath)
-	} else if !os.IsNotExist(err) {
-		return Moved, fmt.Errorf("stat destination %s: %w", destPath, err)
+	}
+	return res, fmt.Errorf("no free name for %s after %d suffixes", path, maxConflictSuffix)
+}
+
+// destState is what a candidate destination path currently holds.
+type destState int
+
+const (
+	destAbsent  destState = iota // nothing: the source can be copied there
+	destSame                     // a file with the source's content
+	destDiffers                  // a file with different content
+)
+
+// destinationState inspects destPath without modifying anything, hashing the
+// existing file and the source when there is one. A directory in the way is
+// an error.
+func destinationState(entry Entry, destPath string) (destState, error) {
+	state, _, err := inspectDestination(entry, destPath)
+	return state, err
+}
+
+// inspectDestination is destinationState that also returns, for destDiffers,
+// the error describing the mismatch (for policies that report it).
+func inspectDestination(entry Entry, destPath string) (state destState, differs error, err error) {
+	info, err := os.Stat(destPath)
+	if os.IsNotExist(err) {
+		return destAbsent, nil, nil
+	}
+	if err != nil {
+		return destAbsent, nil, fmt.Errorf("stat destination %s: %w", destPath, err)
+	}
+	if info.IsDir() {
+		return destAbsent, nil, fmt.Errorf("destination %s is a directory", destPath)
+	}
+	if _, err := compareDestination(entry, destPath); err != nil {
+		if errors.Is(err, errDestinationDiffers) {
+			return destDiffers, err, nil
+		}
+		return destAbsent, nil, err
+	}
+	return destSame, nil, nil
+}
+
+// errDestinationDiffers marks compareDestination's content-mismatch error.
+var errDestinationDiffers = errors.New("destination differs from source")
+
+// transferEntry copies entry to destPath, verifies the copy and deletes the
+// source; see MoveEntryWithPolicy. An existing file at destPath is replaced
+// only once the copy is verified.
+func transferEntry(entry Entry, destPath string) error {
+	tmpPath, err := stageEntry(entry, destPath)
+	if err != nil {
+		return err
+	}
+	if err := finalizeStaged(tmpPath, destPath); err != nil {
+		return err
+	}
+	return deleteMoved(entry, destPath)
+}
+
+// stageEntry copies entry into a temporary file next to destPath and verifies
+// the copy, returning the temporary file's path. Nothing is replaced or
+// deleted yet: finalizeStaged puts the copy into place. On failure the
+// temporary file is removed.
+func stageEntry(entry Entry, destPath string) (string, error) {
+	destDir := filepath.Dir(destPath)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return "", fmt.Errorf("create dir %s: %w", destDir, err)
 	}
 
 	tmpPath := destPath + ".partial"
@@ -62,74 +310,56 @@ func MoveEntry(entry Entry, destPath string) (MoveOutcome, error) {
 	destHash, written, err := copyToTemp(entry, tmpPath)
 	if err != nil {
 		os.Remove(tmpPath)
-		return Moved, err
+		return "", err
 	}
 
 	// Verify the copy by re-reading the source and comparing hashes. This is the
 	// guarantee required before deleting anything from the device.
 	if size := entry.Size(); size >= 0 && written != size {
 		os.Remove(tmpPath)
-		return Moved, fmt.Errorf("copy size mismatch for %s: wrote %d bytes, source reports %d", entry.DisplayPath(), written, size)
+		return "", fmt.Errorf("copy size mismatch for %s: wrote %d bytes, source reports %d", entry.DisplayPath(), written, size)
 	}
 	srcHash, err := hashEntry(entry)
 	if err != nil {
 		os.Remove(tmpPath)
-		return Moved, fmt.Errorf("re-read source %s for verification: %w", entry.DisplayPath(), err)
+		return "", fmt.Errorf("re-read source %s for verification: %w", entry.DisplayPath(), err)
 	}
 	if srcHash != destHash {
 		os.Remove(tmpPath)
-		return Moved, fmt.Errorf("verification failed for %s: source and copied file differ (SHA-256 %s != %s)", entry.DisplayPath(), srcHash, destHash)
+		return "", fmt.Errorf("verification failed for %s: source and copied file differ (SHA-256 %s != %s)", entry.DisplayPath(), srcHash, destHash)
+	}
+	// The copy keeps the source's modification time, which is what
+	// keep-newer compares a later source against.
+	if mt := entry.ModTime(); !mt.IsZero() {
+		if err := os.Chtimes(tmpPath, time.Time{}, mt); err != nil {
+			os.Remove(tmpPath)
+			return "", fmt.Errorf("set modification time of %s: %w", tmpPath, err)
+		}
 	}
+	return tmpPath, nil
+}
 
+// finalizeStaged renames a verified copy made by stageEntry into place.
+func finalizeStaged(tmpPath, destPath string) error {
 	if err := os.Rename(tmpPath, destPath); err != nil {
 		os.Remove(tmpPath)
-		return Moved, fmt.Errorf("finalize %s: %w", destPath, err)
-	}
-
-	if err := entry.Delete(); err != nil {
-		return Moved, fmt.Errorf("copied and verified to %s but failed to delete source %s: %w", destPath, entry.DisplayPath(), err)
+		return fmt.Errorf("finalize %s: %w", destPath, err)
 	}
-	return Moved, nil
+	return nil
 }
 
-// reconcileExisting handles the case where destPath already holds a file. It
-// hashes both the existing destination and the source: on a match the source is
-// a duplicate that was effectively already moved here, so it is deleted; on a
-// mismatch both files are left untouched and an error is returned.
-func reconcileExisting(entry Entry, destPath string) (MoveOutcome, error) {
-	if _, err := compareDestination(entry, destPath); err != nil {
-		return Moved, err
-	}
-
-	// Identical content: this is a duplicate of a file already moved into place.
+// deleteMoved deletes the source of a copy finalized at destPath.
+func deleteMoved(entry Entry, destPath string) error {
 	if err := entry.Delete(); err != nil {
-		return Moved, fmt.Errorf("source %s is a duplicate of %s but failed to delete: %w", entry.DisplayPath(), destPath, err)
+		return fmt.Errorf("copied and verified to %s but failed to delete source %s: %w", destPath, entry.DisplayPath(), err)
 	}
-	return Deduplicated, nil
-}
-
-// PreviewMove reports what MoveEntry would do, without modifying either file. It
-// is the dry-run counterpart of MoveEntry: if the destination already exists it
-// hashes both files, returning Deduplicated when they match or an error when
-// they differ; otherwise it returns Moved.
-func PreviewMove(entry Entry, destPath string) (MoveOutcome, error) {
-	info, err := os.Stat(destPath)
-	if os.IsNotExist(err) {
-		return Moved, nil
-	}
-	if err != nil {
-		return Moved, fmt.Errorf("stat destination %s: %w", destPath, err)
-	}
-	if info.IsDir() {
-		return Moved, fmt.Errorf("destination %s is a directory", destPath)
-	}
-	return compareDestination(entry, destPath)
+	return nil
 }
 
 // compareDestination hashes the existing file at destPath and the source entry.
 // It returns Deduplicated when their SHA-256 sums match, or an error describing
-// the mismatch otherwise. It never modifies either file, so it is shared by the
-// move (reconcileExisting) and dry-run (PreviewMove) paths.
+// the mismatch (wrapping errDestinationDiffers) otherwise. It never modifies
+// either file, so it is shared by the move and dry-run paths.
 func compareDestination(entry Entry, destPath string) (MoveOutcome, error) {
 	destHash, err := hashFile(destPath)
 	if err != nil {
@@ -140,7 +370,7 @@ func compareDestination(entry Entry, destPath string) (MoveOutcome, error) {
 		return Moved, fmt.Errorf("re-read source %s for duplicate check: %w", entry.DisplayPath(), err)
 	}
 	if srcHash != destHash {
-		return Moved, fmt.Errorf("destination %s already exists and differs from source %s (SHA-256 %s != %s); leaving both untouched", destPath, entry.DisplayPath(), destHash, srcHash)
+		return Moved, fmt.Errorf("destination %s already exists and differs from source %s (SHA-256 %s != %s); leaving both untouched: %w", destPath, entry.DisplayPath(), destHash, srcHash, errDestinationDiffers)
 	}
 	return Deduplicated, nil
 }
@@ -185,18 +415,70 @@ func copyToTemp(entry Entry, tmpPath string) (hexHash string, written int64, err
 	return hexSum(h), written, nil
 }
 
-// hashEntry computes the hex SHA-256 of the entry's full content.
+// hashEntry computes the hex SHA-256 of the entry's full content. If the hash
+// was already computed for this entry (e.g. to render a {file.hash} token), the
+// remembered value is returned without reading the source again: the copy in
+// MoveEntry is then verified against that earlier, independent read.
 func hashEntry(entry Entry) (string, error) {
-	rc, err := entry.Open()
+	if m, ok := entry.(sha256Memo); ok {
+		if sum, ok := m.knownSHA256(); ok {
+			return sum, nil
+		}
+	}
+	sums, err := hashEntryAlgorithms(entry, []string{"sha256"})
 	if err != nil {
 		return "", err
 	}
+	return sums["sha256"], nil
+}
+
+// hashAlgorithms are the content hashes available to {file.hash.<algo>}
+// tokens. {file.hash} without an algorithm means sha256.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// hashEntryAlgorithms computes the hex digests of the entry's content for each
+// of the named algorithms (keys of hashAlgorithms) in a single read. A computed
+// SHA-256 is remembered on entries that support it, see hashEntry.
+func hashEntryAlgorithms(entry Entry, algos []string) (map[string]string, error) {
+	hashers := make(map[string]hash.Hash, len(algos))
+	writers := make([]io.Writer, 0, len(algos))
+	for _, algo := range algos {
+		newHash, ok := hashAlgorithms[algo]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash algorithm %q", algo)
+		}
+		if _, dup := hashers[algo]; dup {
+			continue
+		}
+		h := newHash()
+		hashers[algo] = h
+		writers = append(writers, h)
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
 	defer rc.Close()
-	h := sha256.New()
-	if _, err := io.Copy(h, rc); err != nil {
-		return "", err
+	if _, err := io.Copy(io.MultiWriter(writers...), rc); err != nil {
+		return nil, err
 	}
-	return hexSum(h), nil
+
+	sums := make(map[string]string, len(hashers))
+	for algo, h := range hashers {
+		sums[algo] = hexSum(h)
+	}
+	if sum, ok := sums["sha256"]; ok {
+		if m, ok := entry.(sha256Memo); ok {
+			m.rememberSHA256(sum)
+		}
+	}
+	return sums, nil
 }
 
 func hexSum(h hash.Hash) string {
@@ -18,12 +18,13 @@ type fakeEntry struct {
 	bodies  [][]byte // content returned by successive Open calls
 	opens   int
 	deleted bool
+	modTime time.Time
 }
 
 func (e *fakeEntry) Name() string        { return e.name }
 func (e *fakeEntry) DisplayPath() string { return "fake://" + e.name }
 func (e *fakeEntry) Size() int64         { return int64(len(e.bodies[0])) }
-func (e *fakeEntry) ModTime() time.Time  { return time.Time{} }
+func (e *fakeEntry) ModTime() time.Time  { return e.modTime }
 func (e *fakeEntry) Delete() error       { e.deleted = true; return nil }
 
 func (e *fakeEntry) Open() (io.ReadCloser, error) {
@@ -39,7 +40,7 @@ func (e *fakeEntry) Open() (io.ReadCloser, error) {
 // a trailing wildcard, matching Pixel filenames like
 // PXL_20260106_182648043.RAW-02.ORIGINAL.dng.
 func TestFilenameMetaPixelFormat(t *testing.T) {
-	pattern := "PXL_{meta.taken.date:yyyymmdd}_{meta.taken.time:hhmmss}.*"
+	pattern := "PXL_{meta.taken.date:yyyymmdd}_{meta.taken.time:hhmmss}*"
 	meta := parseMetadataFromFilenamePattern("PXL_20260106_182648043.RAW-02.ORIGINAL.dng", pattern)
 	if meta == nil || meta.TakenTime == nil {
 		t.Fatalf("expected metadata with TakenTime, got %+v", meta)
@@ -61,13 +62,13 @@ func TestProcessFileSkipsContentWhenFilenameSufficient(t *testing.T) {
 	cfg := &ffcfg.Config{
 		Profiles: map[string]ffcfg.ProfileConfig{
 			"Phone": {
-				Patterns: []string{"PXL_{meta.taken.date:yyyymmdd}_{meta.taken.time:hhmmss}.*"},
+				Patterns: []string{"PXL_{meta.taken.date:yyyymmdd}_{meta.taken.time:hhmmss}*"},
 				Target:   ffcfg.TargetPathConfig{Path: "/out/{meta.taken.year}/{meta.taken.datetime}.{file.extension}"},
 			},
 		},
 	}
 
-	result := processFile(e, ffcfg.SourceConfig{}, "Phone", cfg)
+	result := processFile(e, ffcfg.SourceConfig{}, "Phone", cfg, DefaultExtractors, nil)
 	if result.Error != nil {
 		t.Fatalf("unexpected error: %v", result.Error)
 	}
@@ -248,3 +249,292 @@ func TestMoveEntryHashMismatchDoesNotDelete(t *testing.T) {
 		t.Error("temp .partial file should be cleaned up after failure")
 	}
 }
+
+// memoFakeEntry is a fakeEntry that remembers its SHA-256, like the real local
+// and MTP entries do.
+type memoFakeEntry struct {
+	*fakeEntry
+	hashMemo
+}
+
+func TestMoveEntryReusesPlannedHash(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := []byte("raw photo bytes")
+
+	t.Run("verified against the planned hash", func(t *testing.T) {
+		dest := filepath.Join(tmpDir, "ok.dng")
+		e := &memoFakeEntry{fakeEntry: &fakeEntry{name: "ok.dng", bodies: [][]byte{content}}}
+		if _, err := hashEntryAlgorithms(e, []string{"sha256", "md5"}); err != nil {
+			t.Fatalf("hashEntryAlgorithms: %v", err)
+		}
+
+		if _, err := MoveEntry(e, dest); err != nil {
+			t.Fatalf("MoveEntry: %v", err)
+		}
+		if e.opens != 2 {
+			t.Errorf("source opened %d times; want 2 (hash at plan time, then copy)", e.opens)
+		}
+		if !e.deleted {
+			t.Error("source was not deleted after a verified copy")
+		}
+	})
+
+	t.Run("changed source fails verification", func(t *testing.T) {
+		dest := filepath.Join(tmpDir, "changed.dng")
+		e := &memoFakeEntry{fakeEntry: &fakeEntry{name: "changed.dng", bodies: [][]byte{content, []byte("changed since planning")}}}
+		if _, err := hashEntryAlgorithms(e, []string{"sha256"}); err != nil {
+			t.Fatalf("hashEntryAlgorithms: %v", err)
+		}
+
+		if _, err := MoveEntry(e, dest); err == nil {
+			t.Fatal("expected verification error, got nil")
+		}
+		if e.deleted {
+			t.Error("source was deleted despite failed verification")
+		}
+		if _, statErr := os.Stat(dest); !os.IsNotExist(statErr) {
+			t.Error("destination file should not exist after failed verification")
+		}
+	})
+}
+
+func TestProcessFileHashToken(t *testing.T) {
+	e := &memoFakeEntry{fakeEntry: &fakeEntry{name: "2024-01-15.jpg", bodies: [][]byte{[]byte("test")}}}
+	cfg := &ffcfg.Config{
+		Profiles: map[string]ffcfg.ProfileConfig{
+			"Hashed": {
+				Patterns: []string{"{meta.taken.date}.jpg"},
+				Target:   ffcfg.TargetPathConfig{Path: "/out/{meta.taken.date}_{file.hash:8}.{file.extension}"},
+			},
+		},
+	}
+
+	result := processFile(e, ffcfg.SourceConfig{}, "Hashed", cfg, DefaultExtractors, nil)
+	if result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+	if want := filepath.Join("/out", "2024-01-15_9f86d081.jpg"); result.NewPath != want {
+		t.Errorf("NewPath = %q; want %q", result.NewPath, want)
+	}
+	if sum, ok := e.knownSHA256(); !ok || sum != "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" {
+		t.Errorf("planned SHA-256 not remembered on the entry: %q, %v", sum, ok)
+	}
+}
+
+func TestMoveEntryWithPolicy(t *testing.T) {
+	content := []byte("incoming source bytes")
+	existing := []byte("a totally different file")
+
+	// seed writes files into a fresh directory and returns it.
+	seed := func(t *testing.T, files map[string][]byte) string {
+		t.Helper()
+		dir := t.TempDir()
+		for name, body := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), body, 0644); err != nil {
+				t.Fatalf("seed %s: %v", name, err)
+			}
+		}
+		return dir
+	}
+	readFile := func(t *testing.T, path string) string {
+		t.Helper()
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		return string(got)
+	}
+
+	t.Run("skip leaves both files", func(t *testing.T) {
+		dir := seed(t, map[string][]byte{"a.dng": existing})
+		e := &fakeEntry{name: "a.dng", bodies: [][]byte{content}}
+		res, err := MoveEntryWithPolicy(e, filepath.Join(dir, "a.dng"), ConflictPolicy{Mode: ConflictSkip})
+		if err != nil {
+			t.Fatalf("MoveEntryWithPolicy: %v", err)
+		}
+		if res.Outcome != Skipped || res.Conflict != ConflictSkip {
+			t.Errorf("result = %+v; want Skipped with conflict %q", res, ConflictSkip)
+		}
+		if e.deleted {
+			t.Error("source was deleted on skip")
+		}
+		if got := readFile(t, filepath.Join(dir, "a.dng")); got != string(existing) {
+			t.Errorf("existing dest modified: %q", got)
+		}
+	})
+
+	t.Run("suffix finds a free name", func(t *testing.T) {
+		dir := seed(t, map[string][]byte{"a.dng": existing, "a-1.dng": []byte("another different file")})
+		e := &fakeEntry{name: "a.dng", bodies: [][]byte{content}}
+		res, err := MoveEntryWithPolicy(e, filepath.Join(dir, "a.dng"), ConflictPolicy{Mode: ConflictSuffix})
+		if err != nil {
+			t.Fatalf("MoveEntryWithPolicy: %v", err)
+		}
+		if want := filepath.Join(dir, "a-2.dng"); res.Outcome != Moved || res.Path != want {
+			t.Errorf("result = %+v; want Moved to %s", res, want)
+		}
+		if got := readFile(t, filepath.Join(dir, "a-2.dng")); got != string(content) {
+			t.Errorf("suffixed dest content = %q; want %q", got, content)
+		}
+		if !e.deleted {
+			t.Error("source was not deleted after a verified copy")
+		}
+	})
+
+	t.Run("suffix stops at an identical file", func(t *testing.T) {
+		dir := seed(t, map[string][]byte{"a.dng": existing, "a-1.dng": content})
+		e := &fakeEntry{name: "a.dng", bodies: [][]byte{content}}
+		res, err := MoveEntryWithPolicy(e, filepath.Join(dir, "a.dng"), ConflictPolicy{Mode: ConflictSuffix})
+		if err != nil {
+			t.Fatalf("MoveEntryWithPolicy: %v", err)
+		}
+		if want := filepath.Join(dir, "a-1.dng"); res.Outcome != Deduplicated || res.Path != want {
+			t.Errorf("result = %+v; want Deduplicated at %s", res, want)
+		}
+		if !e.deleted {
+			t.Error("duplicate source was not deleted")
+		}
+	})
+
+	t.Run("keep-newer replaces an older destination", func(t *testing.T) {
+		dir := seed(t, map[string][]byte{"a.dng": existing})
+		dest := filepath.Join(dir, "a.dng")
+		old := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(dest, old, old); err != nil {
+			t.Fatal(err)
+		}
+		e := &fakeEntry{name: "a.dng", bodies: [][]byte{content}, modTime: time.Now()}
+		res, err := MoveEntryWithPolicy(e, dest, ConflictPolicy{Mode: ConflictKeepNewer})
+		if err != nil {
+			t.Fatalf("MoveEntryWithPolicy: %v", err)
+		}
+		if res.Outcome != Replaced {
+			t.Errorf("outcome = %v; want Replaced", res.Outcome)
+		}
+		if got := readFile(t, dest); got != string(content) {
+			t.Errorf("dest content = %q; want %q", got, content)
+		}
+		if !e.deleted {
+			t.Error("source was not deleted after replacing")
+		}
+	})
+
+	t.Run("keep-newer compares with the time of the file moved before", func(t *testing.T) {
+		// The destination is put in place by an earlier move, so its
+		// modification time is the first version's, not the time of that
+		// move.
+		dest := filepath.Join(t.TempDir(), "a.dng")
+		first := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+		v1 := &fakeEntry{name: "a.dng", bodies: [][]byte{existing}, modTime: first}
+		if _, err := MoveEntry(v1, dest); err != nil {
+			t.Fatalf("MoveEntry: %v", err)
+		}
+		if info, err := os.Stat(dest); err != nil || !info.ModTime().Equal(first) {
+			t.Fatalf("dest mtime = %v, %v; want the source's %v", info.ModTime(), err, first)
+		}
+
+		older := &fakeEntry{name: "a.dng", bodies: [][]byte{[]byte("an older edit")}, modTime: first.Add(-time.Hour)}
+		if res, err := MoveEntryWithPolicy(older, dest, ConflictPolicy{Mode: ConflictKeepNewer}); err != nil || res.Outcome != Skipped {
+			t.Errorf("older source: %+v, %v; want Skipped", res, err)
+		}
+		newer := &fakeEntry{name: "a.dng", bodies: [][]byte{content}, modTime: first.Add(time.Hour)}
+		if res, err := MoveEntryWithPolicy(newer, dest, ConflictPolicy{Mode: ConflictKeepNewer}); err != nil || res.Outcome != Replaced {
+			t.Errorf("newer source: %+v, %v; want Replaced", res, err)
+		}
+		if got := readFile(t, dest); got != string(content) {
+			t.Errorf("dest content = %q; want %q", got, content)
+		}
+	})
+
+	t.Run("keep-newer skips when the destination is newer", func(t *testing.T) {
+		dir := seed(t, map[string][]byte{"a.dng": existing})
+		dest := filepath.Join(dir, "a.dng")
+		e := &fakeEntry{name: "a.dng", bodies: [][]byte{content}, modTime: time.Now().Add(-time.Hour)}
+		res, err := MoveEntryWithPolicy(e, dest, ConflictPolicy{Mode: ConflictKeepNewer})
+		if err != nil {
+			t.Fatalf("MoveEntryWithPolicy: %v", err)
+		}
+		if res.Outcome != Skipped {
+			t.Errorf("outcome = %v; want Skipped", res.Outcome)
+		}
+		if e.deleted {
+			t.Error("older source was deleted")
+		}
+		if got := readFile(t, dest); got != string(existing) {
+			t.Errorf("newer dest modified: %q", got)
+		}
+	})
+
+	t.Run("quarantine moves the source aside", func(t *testing.T) {
+		dir := seed(t, map[string][]byte{"a.dng": existing})
+		conflicts := filepath.Join(dir, "conflicts")
+		e := &fakeEntry{name: "a.dng", bodies: [][]byte{content}}
+		res, err := MoveEntryWithPolicy(e, filepath.Join(dir, "a.dng"), ConflictPolicy{Mode: ConflictQuarantine, QuarantineDir: conflicts})
+		if err != nil {
+			t.Fatalf("MoveEntryWithPolicy: %v", err)
+		}
+		if want := filepath.Join(conflicts, "a.dng"); res.Outcome != Quarantined || res.Path != want {
+			t.Errorf("result = %+v; want Quarantined at %s", res, want)
+		}
+		if got := readFile(t, filepath.Join(conflicts, "a.dng")); got != string(content) {
+			t.Errorf("quarantined content = %q; want %q", got, content)
+		}
+		if got := readFile(t, filepath.Join(dir, "a.dng")); got != string(existing) {
+			t.Errorf("existing dest modified: %q", got)
+		}
+	})
+
+	t.Run("error policy fails", func(t *testing.T) {
+		dir := seed(t, map[string][]byte{"a.dng": existing})
+		e := &fakeEntry{name: "a.dng", bodies: [][]byte{content}}
+		res, err := MoveEntryWithPolicy(e, filepath.Join(dir, "a.dng"), ConflictPolicy{Mode: ConflictError})
+		if err == nil {
+			t.Fatal("expected error for differing destination, got nil")
+		}
+		if res.Conflict != ConflictError {
+			t.Errorf("conflict = %q; want %q", res.Conflict, ConflictError)
+		}
+		if e.deleted {
+			t.Error("source was deleted despite the destination differing")
+		}
+	})
+}
+
+func TestPreviewMoveWithPolicy(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "a.dng")
+	if err := os.WriteFile(dest, []byte("a totally different file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("incoming source bytes")
+
+	tests := []struct {
+		policy  ConflictPolicy
+		outcome MoveOutcome
+		path    string
+	}{
+		{policy: ConflictPolicy{Mode: ConflictSkip}, outcome: Skipped, path: dest},
+		{policy: ConflictPolicy{Mode: ConflictSuffix}, outcome: Moved, path: filepath.Join(dir, "a-1.dng")},
+		{policy: ConflictPolicy{Mode: ConflictKeepNewer}, outcome: Skipped, path: dest}, // source has no mtime
+		{policy: ConflictPolicy{Mode: ConflictQuarantine, QuarantineDir: filepath.Join(dir, "q")}, outcome: Quarantined, path: filepath.Join(dir, "q", "a.dng")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.Mode, func(t *testing.T) {
+			e := &fakeEntry{name: "a.dng", bodies: [][]byte{content}}
+			res, err := PreviewMoveWithPolicy(e, dest, tt.policy)
+			if err != nil {
+				t.Fatalf("PreviewMoveWithPolicy: %v", err)
+			}
+			if res.Outcome != tt.outcome || res.Path != tt.path || res.Conflict != tt.policy.Mode {
+				t.Errorf("result = %+v; want outcome %v at %s with conflict %q", res, tt.outcome, tt.path, tt.policy.Mode)
+			}
+			if e.deleted {
+				t.Error("PreviewMoveWithPolicy must not delete the source")
+			}
+			if _, err := os.Stat(filepath.Join(dir, "q")); !os.IsNotExist(err) {
+				t.Error("PreviewMoveWithPolicy must not create the quarantine directory")
+			}
+		})
+	}
+}
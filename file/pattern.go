@@ -0,0 +1,175 @@
+package file
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Filename patterns (a profile's patterns, a source's filenames) match a
+// whole filename. Outside tokens their text is literal, except for the
+// wildcards: "*" and the {*} token match any run of characters, "?" exactly
+// one, and a backslash makes the character after it literal ("\*", "\{").
+// After the "regex:" prefix the text outside tokens is a Go regular
+// expression instead, e.g. "regex:(IMG|VID)_{meta.taken.date:yyyymmdd}_.*".
+
+// regexPatternPrefix marks a filename pattern written as a regular
+// expression.
+const regexPatternPrefix = "regex:"
+
+// filenamePattern is a compiled filename pattern.
+type filenamePattern struct {
+	re *regexp.Regexp
+	// tokens maps the expression's group names to the token paths they
+	// capture (e.g. "meta.taken.date").
+	tokens map[string]string
+	// formats maps token paths to the time layout their value parses with.
+	formats map[string]string
+}
+
+// filenamePatterns caches compiled patterns by pattern, so each is compiled
+// once rather than for every file.
+var filenamePatterns sync.Map // string -> compiledPattern
+
+type compiledPattern struct {
+	p   *filenamePattern
+	err error
+}
+
+// compileFilenamePattern returns the compiled form of pattern.
+func compileFilenamePattern(pattern string) (*filenamePattern, error) {
+	if c, ok := filenamePatterns.Load(pattern); ok {
+		return c.(compiledPattern).p, c.(compiledPattern).err
+	}
+	p, err := buildFilenamePattern(pattern)
+	if err != nil {
+		err = fmt.Errorf("filename pattern %q: %w", pattern, err)
+	}
+	filenamePatterns.Store(pattern, compiledPattern{p: p, err: err})
+	return p, err
+}
+
+// checkFilenamePatterns returns the first error compiling patterns, presets
+// included.
+func checkFilenamePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		expanded, err := presetPatterns(pattern)
+		if err != nil {
+			return err
+		}
+		for _, p := range expanded {
+			if _, err := compileFilenamePattern(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func buildFilenamePattern(pattern string) (*filenamePattern, error) {
+	text, isRegex := strings.CutPrefix(pattern, regexPatternPrefix)
+	p := &filenamePattern{tokens: make(map[string]string), formats: make(map[string]string)}
+	var expr, literal strings.Builder
+	flush := func() {
+		expr.WriteString(regexp.QuoteMeta(literal.String()))
+		literal.Reset()
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '{' {
+			if end := strings.IndexByte(text[i:], '}'); end > 0 {
+				token := text[i+1 : i+end]
+				if sub, ok := p.tokenExpr(token); ok {
+					flush()
+					expr.WriteString(sub)
+					i += end
+					continue
+				}
+				if !isRegex {
+					return nil, fmt.Errorf("unknown token {%s}", token)
+				}
+			}
+		}
+		switch {
+		case isRegex && c == '\\' && i+1 < len(text):
+			expr.WriteString(text[i : i+2])
+			i++
+		case isRegex:
+			expr.WriteByte(c)
+		case c == '\\' && i+1 < len(text):
+			i++
+			literal.WriteByte(text[i])
+		case c == '*' || c == '?':
+			flush()
+			if c == '*' {
+				expr.WriteString(".*")
+			} else {
+				expr.WriteString(".")
+			}
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+	re, err := regexp.Compile("^(?:" + expr.String() + ")$")
+	if err != nil {
+		return nil, err
+	}
+	p.re = re
+	return p, nil
+}
+
+// tokenExpr returns the expression matching a pattern token ("*",
+// "meta.taken.date" or "meta.taken.time:hhmmss"), capturing the value of
+// those naming metadata in a group recorded in p.
+func (p *filenamePattern) tokenExpr(token string) (string, bool) {
+	if token == "*" {
+		return ".*", true
+	}
+	path, spec, hasSpec := strings.Cut(token, ":")
+	var exp, layout string
+	if hasSpec {
+		for _, variant := range FilenameMetaFormatVariants[path] {
+			if variant.Specifier == spec {
+				exp, layout = variant.Regex, variant.TimeLayout
+				break
+			}
+		}
+	} else {
+		for _, rule := range FilenameMetaRules {
+			if rule.Path == path {
+				exp, layout = rule.Exp, rule.Format
+				break
+			}
+		}
+	}
+	if exp == "" {
+		return "", false
+	}
+	group := "fileferry_" + strconv.Itoa(len(p.tokens))
+	p.tokens[group] = path
+	if layout != "" {
+		p.formats[path] = layout
+	}
+	return "(?P<" + group + ">" + exp + ")", true
+}
+
+// match returns the values pattern captured from filename by token path, or
+// false if it doesn't match. A token used twice keeps its first value.
+func (p *filenamePattern) match(filename string) (map[string]string, bool) {
+	m := p.re.FindStringSubmatch(filename)
+	if m == nil {
+		return nil, false
+	}
+	values := make(map[string]string)
+	for i, name := range p.re.SubexpNames() {
+		if path, ok := p.tokens[name]; ok {
+			if _, seen := values[path]; !seen {
+				values[path] = m[i]
+			}
+		}
+	}
+	return values, true
+}
@@ -0,0 +1,81 @@
+package file
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+func TestFilenamePattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		filename string
+		want     string // taken time as "2006-01-02 15:04:05", empty for no match
+	}{
+		{name: "dot is literal", pattern: "{meta.taken.date} {meta.taken.time}.mkv", filename: "2024-01-10 15-21-02.mkv", want: "2024-01-10 15:21:02"},
+		{name: "dot doesn't match another character", pattern: "{meta.taken.date} {meta.taken.time}.mkv", filename: "2024-01-10 15-21-02_mkv"},
+		{name: "parentheses are literal", pattern: "{meta.taken.date} (1).jpg", filename: "2024-01-10 (1).jpg", want: "2024-01-10 00:00:00"},
+		{name: "plus is literal", pattern: "{meta.taken.date}+edit.jpg", filename: "2024-01-10+edit.jpg", want: "2024-01-10 00:00:00"},
+		{name: "plus doesn't repeat", pattern: "{meta.taken.date}+edit.jpg", filename: "2024-01-10edit.jpg"},
+		{name: "star matches any run", pattern: "IMG_{meta.taken.date:yyyymmdd}*.jpg", filename: "IMG_20240110_burst (2).jpg", want: "2024-01-10 00:00:00"},
+		{name: "star matches nothing", pattern: "IMG_{meta.taken.date:yyyymmdd}*.jpg", filename: "IMG_20240110.jpg", want: "2024-01-10 00:00:00"},
+		{name: "star token", pattern: "{*}_{meta.taken.date:yyyymmdd}.jpg", filename: "holiday_20240110.jpg", want: "2024-01-10 00:00:00"},
+		{name: "question mark matches one character", pattern: "{meta.taken.date}_?.jpg", filename: "2024-01-10_é.jpg", want: "2024-01-10 00:00:00"},
+		{name: "question mark doesn't match two", pattern: "{meta.taken.date}_?.jpg", filename: "2024-01-10_ab.jpg"},
+		{name: "escaped star is literal", pattern: `{meta.taken.date}\*.jpg`, filename: "2024-01-10*.jpg", want: "2024-01-10 00:00:00"},
+		{name: "escaped star doesn't match others", pattern: `{meta.taken.date}\*.jpg`, filename: "2024-01-10x.jpg"},
+		{name: "regex", pattern: `regex:(IMG|VID)_{meta.taken.date:yyyymmdd}_\d+\.(jpg|mp4)`, filename: "VID_20240110_0042.mp4", want: "2024-01-10 00:00:00"},
+		{name: "regex keeps repetition braces", pattern: `regex:x{2}_{meta.taken.date}\.jpg`, filename: "xx_2024-01-10.jpg", want: "2024-01-10 00:00:00"},
+		{name: "regex is anchored", pattern: `regex:IMG|{meta.taken.date}\.jpg`, filename: "IMG_2024-01-10.jpg"},
+		{name: "unknown token", pattern: "{meta.camera.model}_{meta.taken.date}.jpg", filename: "{meta.camera.model}_2024-01-10.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := parseMetadataFromFilenamePattern(tt.filename, tt.pattern)
+			got := ""
+			if meta != nil {
+				got = meta.TakenTime.Format("2006-01-02 15:04:05")
+			}
+			if got != tt.want {
+				t.Errorf("parseMetadataFromFilenamePattern(%q, %q) taken = %q; want %q", tt.filename, tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileFilenamePattern(t *testing.T) {
+	p1, err := compileFilenamePattern("{meta.taken.date}.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2, _ := compileFilenamePattern("{meta.taken.date}.jpg"); p2 != p1 {
+		t.Error("compiling a pattern again didn't reuse the compiled pattern")
+	}
+
+	for _, pattern := range []string{"{meta.taken.date:ddmmyyyy}.jpg", "{camera}.jpg", "regex:({meta.taken.date}.jpg"} {
+		if _, err := compileFilenamePattern(pattern); err == nil {
+			t.Errorf("compileFilenamePattern(%q) succeeded; want an error", pattern)
+		}
+	}
+}
+
+func TestFileIteratorInvalidPattern(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "2024-01-10.jpg"), "x")
+	cfg := &ffcfg.Config{Profiles: map[string]ffcfg.ProfileConfig{"P": {
+		Sources:  []ffcfg.SourceConfig{{Path: dir, Types: []string{"image"}}},
+		Patterns: []string{"regex:({meta.taken.date}.jpg"},
+		Target:   ffcfg.TargetPathConfig{Path: filepath.Join(dir, "out", "{meta.taken.date}.{file.extension}")},
+	}}}
+
+	var files []File
+	for f := range FileIterator(cfg) {
+		files = append(files, f)
+	}
+	if len(files) != 1 || files[0].Error == nil || !strings.Contains(files[0].Error.Error(), "filename pattern") {
+		t.Fatalf("files = %+v; want the source reported with the pattern error", files)
+	}
+}
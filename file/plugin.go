@@ -0,0 +1,205 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+// External extractors (the config's extractors section) are programs that
+// print a file's metadata as a JSON object:
+//
+//	{
+//	  "taken": "2024-01-15T14:30:45+01:00",
+//	  "digitized": "…", "modified": "…",
+//	  "camera_maker": "…", "camera_model": "…", "software": "…",
+//	  "latitude": 45.815, "longitude": 15.9819,
+//	  "rating": 4, "keywords": ["…"],
+//	  "custom": {"batch": "B-17"}
+//	}
+//
+// Every field is optional. They run once per file; one that fails, times out
+// or prints something else reports nothing but a warning, leaving the file to
+// the other extractors. Values that don't parse are dropped.
+
+// pluginOutput is the JSON an external extractor prints.
+type pluginOutput struct {
+	Taken       string         `json:"taken"`
+	Digitized   string         `json:"digitized"`
+	Modified    string         `json:"modified"`
+	CameraMaker string         `json:"camera_maker"`
+	CameraModel string         `json:"camera_model"`
+	Software    string         `json:"software"`
+	Latitude    *float64       `json:"latitude"`
+	Longitude   *float64       `json:"longitude"`
+	Rating      *float64       `json:"rating"`
+	Keywords    []string       `json:"keywords"`
+	Custom      map[string]any `json:"custom"`
+}
+
+// pluginExtractor runs an external extractor.
+type pluginExtractor struct {
+	cfg ffcfg.ExtractorConfig
+}
+
+func (p pluginExtractor) Supports(e Entry) bool {
+	ext := filepath.Ext(e.Name())
+	return slices.ContainsFunc(p.cfg.Extensions, func(x string) bool { return strings.EqualFold(x, ext) })
+}
+
+// Extract runs the program on e. Local files are passed by path; others are
+// streamed to its stdin, with "-" in place of the path and the file's name in
+// FILEFERRY_FILENAME. Failures are ExtractorWarnings: they leave the fields
+// to the other extractors.
+func (p pluginExtractor) Extract(e Entry) (*FileMetadata, error) {
+	timeout := p.cfg.Timeout
+	if timeout == 0 {
+		timeout = ffcfg.DefaultExtractorTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := slices.Clone(p.cfg.Command[1:])
+	cmd := exec.CommandContext(ctx, p.cfg.Command[0])
+	cmd.Env = append(os.Environ(), "FILEFERRY_FILENAME="+e.Name())
+	cmd.WaitDelay = time.Second
+	if lp, ok := e.(localPathProvider); ok {
+		args = append(args, lp.LocalPath())
+	} else {
+		rc, err := e.Open()
+		if err != nil {
+			return nil, &ExtractorWarning{Err: err}
+		}
+		defer rc.Close()
+		cmd.Stdin = rc
+		args = append(args, "-")
+	}
+	cmd.Args = append(cmd.Args, args...)
+
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, &ExtractorWarning{Err: fmt.Errorf("%s timed out after %s", p.cfg.Command[0], timeout)}
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if msg := firstLine(exitErr.Stderr); msg != "" {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+		}
+		return nil, &ExtractorWarning{Err: fmt.Errorf("run %s: %w", p.cfg.Command[0], err)}
+	}
+	var po pluginOutput
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+	if err := dec.Decode(&po); err != nil {
+		return nil, &ExtractorWarning{Err: fmt.Errorf("%s printed invalid output: %w", p.cfg.Command[0], err)}
+	}
+	return po.metadata(), nil
+}
+
+// firstLine returns the first non-empty line of out, trimmed.
+func firstLine(out []byte) string {
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// metadata converts the output to FileMetadata, dropping values that don't
+// parse.
+func (po pluginOutput) metadata() *FileMetadata {
+	meta := &FileMetadata{
+		CameraMaker: strings.TrimSpace(po.CameraMaker),
+		CameraModel: strings.TrimSpace(po.CameraModel),
+		Software:    strings.TrimSpace(po.Software),
+		Keywords:    po.Keywords,
+	}
+	if po.Rating != nil {
+		meta.Rating = parseRating(*po.Rating)
+	}
+	for k, v := range po.Custom {
+		if s, ok := pluginCustomValue(v); ok {
+			if meta.Custom == nil {
+				meta.Custom = make(map[string]string, len(po.Custom))
+			}
+			meta.Custom[k] = s
+		}
+	}
+	meta.TakenTime, meta.TakenBasis = parsePluginTime(po.Taken)
+	meta.DigitizedTime, meta.DigitizedBasis = parsePluginTime(po.Digitized)
+	meta.ModifiedTime, meta.ModifiedBasis = parsePluginTime(po.Modified)
+	if po.Latitude != nil && po.Longitude != nil && *po.Latitude >= -90 && *po.Latitude <= 90 && *po.Longitude >= -180 && *po.Longitude <= 180 {
+		meta.Location = &GeoLocation{Latitude: *po.Latitude, Longitude: *po.Longitude}
+	}
+	return meta
+}
+
+// pluginCustomValue formats a custom value as its token renders it. Only
+// strings, numbers and booleans have one; objects, arrays and null don't.
+func pluginCustomValue(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// parsePluginTime parses an external extractor's time: RFC 3339 with an
+// offset is zoned, with "Z" it is an instant rendered in the profile's
+// timezone, and without either ("2024-01-15T14:30:45", or with a space) it is
+// a floating wall-clock reading.
+func parsePluginTime(s string) (*time.Time, TimeBasis) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, TimeFloating
+	}
+	if tm, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		// Not TimeContainerUTC: the plugin says UTC explicitly, so a
+		// source's container_time: local doesn't apply.
+		if strings.HasSuffix(s, "Z") || strings.HasSuffix(s, "z") {
+			return &tm, TimeInstant
+		}
+		return &tm, TimeZoned
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"} {
+		if tm, err := time.Parse(layout, s); err == nil {
+			return &tm, TimeFloating
+		}
+	}
+	return nil, TimeFloating
+}
+
+// extractorsFor returns the registry for cfg: DefaultExtractors, plus cfg's
+// external extractors when it has any.
+func extractorsFor(cfg *ffcfg.Config) *ExtractorRegistry {
+	if len(cfg.Extractors) == 0 {
+		return DefaultExtractors
+	}
+	r := DefaultExtractors.clone()
+	for _, ec := range cfg.Extractors {
+		priority := ffcfg.DefaultExtractorPriority
+		if ec.Priority != nil {
+			priority = *ec.Priority
+		}
+		r.Register(ec.Name, priority, pluginExtractor{cfg: ec})
+	}
+	return r
+}
@@ -0,0 +1,236 @@
+package file
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+// fakePlugin is an external extractor whose first argument picks how it
+// behaves. In "ok" mode it reports the file's content as the taken time.
+const fakePlugin = `#!/bin/sh
+mode=$1
+path=$2
+case "$mode" in
+ok)
+	if [ "$path" = "-" ]; then
+		content=$(cat)
+		name=$FILEFERRY_FILENAME
+	else
+		content=$(cat "$path")
+		name=$(basename "$path")
+	fi
+	printf '{"taken":"%s","camera_maker":"Scanner","latitude":45.815,"longitude":15.9819,"custom":{"batch":"B-17","name":"%s"}}\n' "$content" "$name" ;;
+fail) echo '{"taken":"2000-01-01T00:00:00"}'; exit 1 ;;
+hang) exec sleep 10 ;;
+garbage) echo 'not json' ;;
+mixed) echo '{"taken":"2024-01-15T14:30:45","rating":9,"custom":{"batch":"B-17","frame":17,"ratio":1.5,"scanned":true,"tags":["a"],"none":null}}' ;;
+esac
+`
+
+func writeFakePlugin(t *testing.T) string {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "plugin")
+	if err := os.WriteFile(script, []byte(fakePlugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestPluginExtractor(t *testing.T) {
+	script := writeFakePlugin(t)
+	want := time.Date(2024, 1, 15, 14, 30, 45, 0, time.FixedZone("", 3600))
+	check := func(t *testing.T, meta *FileMetadata, name string) {
+		t.Helper()
+		if meta == nil {
+			t.Fatal("Extract = nil")
+		}
+		if meta.TakenTime == nil || !meta.TakenTime.Equal(want) || meta.TakenBasis != TimeZoned {
+			t.Errorf("TakenTime = %v (%v); want %v, zoned", meta.TakenTime, meta.TakenBasis, want)
+		}
+		if meta.CameraMaker != "Scanner" || meta.Location == nil || meta.Location.Latitude != 45.815 {
+			t.Errorf("maker, location = %q, %+v; want Scanner, 45.815", meta.CameraMaker, meta.Location)
+		}
+		if meta.Custom["batch"] != "B-17" || meta.Custom["name"] != name {
+			t.Errorf("Custom = %v; want batch B-17, name %s", meta.Custom, name)
+		}
+	}
+	p := pluginExtractor{cfg: ffcfg.ExtractorConfig{Name: "scanner", Command: []string{script, "ok"}, Extensions: []string{".scan"}}}
+
+	t.Run("local file by path", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "page1.scan")
+		mustWrite(t, path, "2024-01-15T14:30:45+01:00")
+		fi, _ := os.Stat(path)
+		e := &localEntry{path: path, info: fi}
+		if !p.Supports(e) || p.Supports(&fakeEntry{name: "page1.jpg"}) {
+			t.Error("Supports: want .scan files only")
+		}
+		meta, err := p.Extract(e)
+		if err != nil {
+			t.Fatalf("Extract: %v", err)
+		}
+		check(t, meta, "page1.scan")
+	})
+
+	t.Run("streamed content", func(t *testing.T) {
+		e := &fakeEntry{name: "page2.SCAN", bodies: [][]byte{[]byte("2024-01-15T14:30:45+01:00")}}
+		meta, err := p.Extract(e)
+		if err != nil {
+			t.Fatalf("Extract: %v", err)
+		}
+		check(t, meta, "page2.SCAN")
+	})
+
+	t.Run("values of any type", func(t *testing.T) {
+		p := pluginExtractor{cfg: ffcfg.ExtractorConfig{Name: "scanner", Command: []string{script, "mixed"}, Extensions: []string{".scan"}}}
+		meta, err := p.Extract(&fakeEntry{name: "page.scan", bodies: [][]byte{[]byte("x")}})
+		if err != nil || meta == nil {
+			t.Fatalf("Extract = %+v, %v; want the valid fields", meta, err)
+		}
+		if meta.TakenTime == nil {
+			t.Error("TakenTime = nil; want it kept despite the invalid rating")
+		}
+		if meta.Rating != nil {
+			t.Errorf("Rating = %d; want a rating outside -1..5 dropped", *meta.Rating)
+		}
+		want := map[string]string{"batch": "B-17", "frame": "17", "ratio": "1.5", "scanned": "true"}
+		if !maps.Equal(meta.Custom, want) {
+			t.Errorf("Custom = %v; want %v", meta.Custom, want)
+		}
+	})
+
+	for _, mode := range []string{"fail", "hang", "garbage"} {
+		t.Run(mode, func(t *testing.T) {
+			p := pluginExtractor{cfg: ffcfg.ExtractorConfig{Name: "scanner", Command: []string{script, mode}, Extensions: []string{".scan"}, Timeout: 200 * time.Millisecond}}
+			start := time.Now()
+			meta, err := p.Extract(&fakeEntry{name: "page.scan", bodies: [][]byte{[]byte("x")}})
+			var w *ExtractorWarning
+			if meta != nil || !errors.As(err, &w) {
+				t.Errorf("Extract = %+v, %v; want nil and a warning", meta, err)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("Extract took %s; want it cut off by the timeout", d)
+			}
+		})
+	}
+}
+
+func TestFileIteratorPlugins(t *testing.T) {
+	script := writeFakePlugin(t)
+	tmpDir := t.TempDir()
+	mustWrite(t, filepath.Join(tmpDir, "page1.scan"), "2024-01-15T14:30:45")
+	ifd0, exifIFD := rawFixtureTIFFs("Canon", "Canon EOS R6")
+	if err := os.WriteFile(filepath.Join(tmpDir, "IMG_0001.jpg"), jpegWithExif(buildTIFF(ifd0, exifIFD)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ffcfg.Config{
+		Profiles: map[string]ffcfg.ProfileConfig{
+			"test": {
+				Sources: []ffcfg.SourceConfig{{Path: tmpDir, Types: []string{".scan", "image"}}},
+				Target:  ffcfg.TargetPathConfig{Path: "/target/{meta.custom.batch}/{meta.taken.date}.{file.extension}"},
+			},
+		},
+		Extractors: []ffcfg.ExtractorConfig{
+			{Name: "scanner", Command: []string{script, "ok"}, Extensions: []string{".scan"}},
+			// Fails on every JPEG: the built-in extractor still reads them.
+			{Name: "broken", Command: []string{script, "fail"}, Extensions: []string{".jpg"}},
+		},
+	}
+
+	got := make(map[string]File)
+	for f := range FileIterator(cfg) {
+		got[filepath.Base(f.OldPath)] = f
+	}
+	scan := got["page1.scan"]
+	if scan.Error != nil || scan.NewPath != filepath.Join("/target", "B-17", "2024-01-15.scan") {
+		t.Errorf("page1.scan: NewPath = %q, Error = %v; want /target/B-17/2024-01-15.scan", scan.NewPath, scan.Error)
+	}
+	if scan.Metadata != nil && scan.Metadata.Provenance["custom.batch"] != "scanner" {
+		t.Errorf("Provenance = %v; want custom.batch from scanner", scan.Metadata.Provenance)
+	}
+	// The JPEG has no custom batch, so its path can't be populated, but its
+	// date comes from EXIF despite the failing plugin.
+	jpg := got["IMG_0001.jpg"]
+	if jpg.Metadata == nil || jpg.Metadata.TakenTime == nil || jpg.Metadata.Provenance["taken"] != "image" {
+		t.Errorf("IMG_0001.jpg: metadata = %+v; want the EXIF taken time", jpg.Metadata)
+	}
+	if len(jpg.Warnings) != 1 || !strings.Contains(jpg.Warnings[0].Error(), "broken extractor") {
+		t.Errorf("IMG_0001.jpg: Warnings = %v; want the failing plugin reported", jpg.Warnings)
+	}
+	if len(scan.Warnings) != 0 {
+		t.Errorf("page1.scan: Warnings = %v; want none", scan.Warnings)
+	}
+
+	fileCh, eventCh, _ := FileIteratorWithEvents(cfg, "")
+	for range fileCh {
+	}
+	var warnings []ScanEvent
+	for ev := range eventCh {
+		if ev.EventType == "warning" {
+			warnings = append(warnings, ev)
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error.Error(), "IMG_0001.jpg") {
+		t.Errorf("warning events = %+v; want one for IMG_0001.jpg", warnings)
+	}
+
+	if n := len(DefaultExtractors.clone().extractors); n != 2 {
+		t.Errorf("DefaultExtractors has %d extractors; want the config's kept out of it", n)
+	}
+}
+
+func TestParsePluginTime(t *testing.T) {
+	tests := []struct {
+		in        string
+		want      time.Time
+		wantBasis TimeBasis
+	}{
+		{"2024-01-15T14:30:45+01:00", time.Date(2024, 1, 15, 13, 30, 45, 0, time.UTC), TimeZoned},
+		{"2024-01-15T14:30:45Z", time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC), TimeInstant},
+		{"2024-01-15T14:30:45.25", time.Date(2024, 1, 15, 14, 30, 45, 250e6, time.UTC), TimeFloating},
+		{"2024-01-15 14:30:45", time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC), TimeFloating},
+	}
+	for _, tt := range tests {
+		tm, basis := parsePluginTime(tt.in)
+		if tm == nil || !tm.Equal(tt.want) || basis != tt.wantBasis {
+			t.Errorf("parsePluginTime(%q) = %v (%v); want %v (%v)", tt.in, tm, basis, tt.want, tt.wantBasis)
+		}
+	}
+	if tm, _ := parsePluginTime("last week"); tm != nil {
+		t.Errorf("parsePluginTime(last week) = %v; want nil", tm)
+	}
+}
+
+func TestProcessFilePluginUTCTime(t *testing.T) {
+	// A plugin's "Z" time is an instant even on a source whose container
+	// times are local: it's converted to the profile's timezone.
+	script := writeFakePlugin(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "page1.scan")
+	mustWrite(t, path, "2024-01-15T14:30:45Z")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ffcfg.Config{
+		Profiles: map[string]ffcfg.ProfileConfig{"P": {
+			Timezone: "Europe/Zagreb",
+			Target:   ffcfg.TargetPathConfig{Path: "/target/{meta.taken.datetime}.{file.extension}"},
+		}},
+		Extractors: []ffcfg.ExtractorConfig{{Name: "scanner", Command: []string{script, "ok"}, Extensions: []string{".scan"}}},
+	}
+	src := ffcfg.SourceConfig{Path: dir, ContainerTime: ContainerTimeLocal}
+	f := processFile(&localEntry{path: path, info: fi}, src, "P", cfg, extractorsFor(cfg), nil)
+	if f.Error != nil {
+		t.Fatal(f.Error)
+	}
+	if want := filepath.Join("/target", "2024-01-15-15-30-45.scan"); f.NewPath != want {
+		t.Errorf("NewPath = %q; want %q, the UTC time in Europe/Zagreb", f.NewPath, want)
+	}
+}
@@ -0,0 +1,76 @@
+package file
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// presetPrefix marks a filename pattern naming a preset ("@android").
+const presetPrefix = "@"
+
+// Patterns for the naming conventions of common cameras and apps. Variants
+// with milliseconds come before those without, so the milliseconds are read
+// when they are there.
+var (
+	pixelPatterns = []string{
+		// PXL_20240101_120000123.jpg, PXL_20240101_120000123.RAW-02.ORIGINAL.dng
+		"PXL_{meta.taken.date:yyyymmdd}_{meta.taken.time:hhmmss}{meta.taken.ms}*",
+	}
+	androidCameraPatterns = []string{
+		// IMG_20240101_120000123.jpg, IMG_20240101_120000.jpg,
+		// IMG_20240101_120000_1.jpg, VID_20240101_120000.mp4
+		"IMG_{meta.taken.date:yyyymmdd}_{meta.taken.time:hhmmss}{meta.taken.ms}*",
+		"IMG_{meta.taken.date:yyyymmdd}_{meta.taken.time:hhmmss}*",
+		"VID_{meta.taken.date:yyyymmdd}_{meta.taken.time:hhmmss}{meta.taken.ms}*",
+		"VID_{meta.taken.date:yyyymmdd}_{meta.taken.time:hhmmss}*",
+	}
+	screenshotPatterns = []string{
+		// Screenshot_2024-01-01-12-00-00-123_com.app.png,
+		// Screenshot_20240101-120000.png,
+		// Screenshot_20240101-120000_Chrome.jpg,
+		// Screen_Recording_20240101-120000_YouTube.mp4
+		"Screenshot_{meta.taken.date}-{meta.taken.time}-{meta.taken.ms}*",
+		"Screenshot_{meta.taken.date}-{meta.taken.time}*",
+		"Screenshot_{meta.taken.date:yyyymmdd}-{meta.taken.time:hhmmss}*",
+		"Screen_Recording_{meta.taken.date:yyyymmdd}-{meta.taken.time:hhmmss}*",
+	}
+)
+
+// FilenamePresets are the named patterns a profile's patterns or a source's
+// filenames can refer to as "@name". Each is a list of patterns tried in
+// order.
+var FilenamePresets = map[string][]string{
+	"pixel":      pixelPatterns,
+	"screenshot": screenshotPatterns,
+	"android":    slices.Concat(pixelPatterns, androidCameraPatterns, screenshotPatterns),
+	// IMG-20240101-WA0001.jpg, VID-20240101-WA0001.mp4, … (date only).
+	"whatsapp": {
+		`regex:(IMG|VID|AUD|PTT|STK|DOC)-{meta.taken.date:yyyymmdd}-WA\d+.*`,
+	},
+	// signal-2024-01-01-12-00-00-123.jpg, signal-2024-01-01-120000.jpg,
+	// signal-2024-01-01-120000_001.jpg.
+	"signal": {
+		"signal-{meta.taken.date}-{meta.taken.time}-{meta.taken.ms}*",
+		"signal-{meta.taken.date}-{meta.taken.time:hhmmss}*",
+	},
+	// DJI_20240101120000_0001_D.JPG, DJI_20240101120000_0001_D.MP4.
+	"dji": {
+		"DJI_{meta.taken.date:yyyymmdd}{meta.taken.time:hhmmss}_*",
+	},
+}
+
+// presetPatterns returns the patterns a preset ("@android") stands for, or
+// pattern itself when it isn't one.
+func presetPatterns(pattern string) ([]string, error) {
+	name, ok := strings.CutPrefix(pattern, presetPrefix)
+	if !ok {
+		return []string{pattern}, nil
+	}
+	patterns, ok := FilenamePresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown filename preset %q (expected %s followed by one of %v)", pattern, presetPrefix, slices.Sorted(maps.Keys(FilenamePresets)))
+	}
+	return patterns, nil
+}
@@ -0,0 +1,94 @@
+package file
+
+import (
+	"path/filepath"
+	"testing"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+func TestFilenamePresets(t *testing.T) {
+	tests := []struct {
+		preset   string
+		filename string
+		want     string // taken time as "2006-01-02 15:04:05.000", empty for no match
+	}{
+		{"@pixel", "PXL_20240101_120000123.jpg", "2024-01-01 12:00:00.123"},
+		{"@pixel", "PXL_20260106_182648043.RAW-02.ORIGINAL.dng", "2026-01-06 18:26:48.043"},
+		{"@pixel", "PXL_20240101_120000123.NIGHT.jpg", "2024-01-01 12:00:00.123"},
+		{"@pixel", "IMG_20240101_120000.jpg", ""},
+
+		{"@android", "PXL_20240101_120000123.mp4", "2024-01-01 12:00:00.123"},
+		{"@android", "IMG_20240101_120000.jpg", "2024-01-01 12:00:00.000"},
+		{"@android", "IMG_20240101_120000123.jpg", "2024-01-01 12:00:00.123"},
+		{"@android", "IMG_20240101_120000_1.jpg", "2024-01-01 12:00:00.000"},
+		{"@android", "IMG_20240101_120000_HDR.jpg", "2024-01-01 12:00:00.000"},
+		{"@android", "VID_20240101_120000.mp4", "2024-01-01 12:00:00.000"},
+		{"@android", "VID_20240101_120000123.mp4", "2024-01-01 12:00:00.123"},
+		{"@android", "Screenshot_2024-01-01-12-00-00-123_com.app.png", "2024-01-01 12:00:00.123"},
+		{"@android", "IMG-20240101-WA0001.jpg", ""},
+
+		{"@screenshot", "Screenshot_2024-01-01-12-00-00-123_com.app.png", "2024-01-01 12:00:00.123"},
+		{"@screenshot", "Screenshot_2024-01-01-12-00-00.png", "2024-01-01 12:00:00.000"},
+		{"@screenshot", "Screenshot_20240101-120000.png", "2024-01-01 12:00:00.000"},
+		{"@screenshot", "Screenshot_20240101-120000_Chrome.jpg", "2024-01-01 12:00:00.000"},
+		{"@screenshot", "Screen_Recording_20240101-120000_YouTube.mp4", "2024-01-01 12:00:00.000"},
+
+		{"@whatsapp", "IMG-20240101-WA0001.jpg", "2024-01-01 00:00:00.000"},
+		{"@whatsapp", "VID-20240101-WA0012.mp4", "2024-01-01 00:00:00.000"},
+		{"@whatsapp", "PTT-20240101-WA0003.opus", "2024-01-01 00:00:00.000"},
+		{"@whatsapp", "IMG-20240101-0001.jpg", ""},
+
+		{"@signal", "signal-2024-01-01-120000.jpg", "2024-01-01 12:00:00.000"},
+		{"@signal", "signal-2024-01-01-120000_001.jpg", "2024-01-01 12:00:00.000"},
+		{"@signal", "signal-2024-01-01-12-00-00-123.jpg", "2024-01-01 12:00:00.123"},
+
+		{"@dji", "DJI_20240101120000_0001_D.JPG", "2024-01-01 12:00:00.000"},
+		{"@dji", "DJI_20240101120000_0002_D.MP4", "2024-01-01 12:00:00.000"},
+		{"@dji", "DJI_0001.JPG", ""},
+
+		{"@unknown", "PXL_20240101_120000123.jpg", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset+" "+tt.filename, func(t *testing.T) {
+			meta := parseMetadataFromFilenamePattern(tt.filename, tt.preset)
+			got := ""
+			if meta != nil {
+				got = meta.TakenTime.Format("2006-01-02 15:04:05.000")
+			}
+			if got != tt.want {
+				t.Errorf("taken = %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Every preset's patterns compile.
+	for name := range FilenamePresets {
+		if err := checkFilenamePatterns([]string{presetPrefix + name}); err != nil {
+			t.Errorf("preset @%s: %v", name, err)
+		}
+	}
+	if err := checkFilenamePatterns([]string{"@unknown"}); err == nil {
+		t.Error("checkFilenamePatterns(@unknown) succeeded; want an error")
+	}
+}
+
+func TestProcessFilePreset(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "IMG-20240101-WA0001.jpg")
+	mustWrite(t, path, "not really a JPEG")
+	cfg := &ffcfg.Config{Profiles: map[string]ffcfg.ProfileConfig{"P": {
+		Sources:  []ffcfg.SourceConfig{{Path: dir, Types: []string{"image"}}},
+		Patterns: []string{"@android", "@whatsapp"},
+		Target:   ffcfg.TargetPathConfig{Path: filepath.Join(dir, "out", "{meta.taken.date}_{file.name}")},
+	}}}
+
+	var files []File
+	for f := range FileIterator(cfg) {
+		files = append(files, f)
+	}
+	want := filepath.Join(dir, "out", "2024-01-01_IMG-20240101-WA0001.jpg")
+	if len(files) != 1 || files[0].Error != nil || files[0].NewPath != want {
+		t.Fatalf("files = %+v; want one moved to %s", files, want)
+	}
+}
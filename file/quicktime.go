@@ -0,0 +1,206 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mp4 "github.com/abema/go-mp4"
+)
+
+// MP4 and QuickTime files keep their metadata in moov: the mvhd creation time,
+// QuickTime metadata (a meta box whose keys box names the items in its ilst,
+// as iPhones and Android write them) and classic udta atoms (©mak, ©mod,
+// ©xyz). go-mp4 parses the boxes, including the keys-indexed ilst items; the
+// udta atoms it doesn't know are read raw.
+
+// QuickTime metadata keys read by readQuickTimeMetadata.
+const (
+	qtCreationDate = "com.apple.quicktime.creationdate"
+	qtMake         = "com.apple.quicktime.make"
+	qtModel        = "com.apple.quicktime.model"
+	qtLocation     = "com.apple.quicktime.location.ISO6709"
+	qtSoftware     = "com.apple.quicktime.software"
+	androidMake    = "com.android.manufacturer"
+	androidModel   = "com.android.model"
+)
+
+// Classic QuickTime udta atoms: camera make, model, location and software.
+var (
+	udtaMake     = mp4.BoxType{0xa9, 'm', 'a', 'k'}
+	udtaModel    = mp4.BoxType{0xa9, 'm', 'o', 'd'}
+	udtaLocation = mp4.BoxType{0xa9, 'x', 'y', 'z'}
+	udtaSoftware = mp4.BoxType{0xa9, 's', 'w', 'r'}
+)
+
+// maxUdtaAtom bounds the udta atoms read into memory; the ones read here are
+// short strings.
+const maxUdtaAtom = 64 << 10
+
+// readQuickTimeMetadata fills meta from an MP4/QuickTime file's moov box: the
+// taken time (the QuickTime creation date with its UTC offset, else the mvhd
+// creation time, which is UTC), camera make and model, software and location.
+func readQuickTimeMetadata(rs io.ReadSeeker, meta *FileMetadata) {
+	values := make(map[string]string)
+	var created *time.Time
+	var keys []string // names of the numbered ilst items of the current meta box
+
+	handler := func(h *mp4.ReadHandle) (interface{}, error) {
+		typ := h.BoxInfo.Type
+		parent := mp4.BoxType{}
+		if len(h.Path) >= 2 {
+			parent = h.Path[len(h.Path)-2]
+		}
+		switch {
+		case typ == mp4.BoxTypeMoov() && len(h.Path) == 1,
+			typ == mp4.BoxTypeUdta() && parent == mp4.BoxTypeMoov(),
+			typ == mp4.BoxTypeIlst() && parent == mp4.BoxTypeMeta():
+			return h.Expand()
+		case typ == mp4.BoxTypeMeta() && (parent == mp4.BoxTypeMoov() || parent == mp4.BoxTypeUdta()):
+			outer := keys
+			keys = nil
+			defer func() { keys = outer }()
+			return h.Expand()
+		case typ == mp4.BoxTypeMvhd() && parent == mp4.BoxTypeMoov():
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			if mvhd, ok := box.(*mp4.Mvhd); ok {
+				created = mvhdCreationTime(mvhd)
+			}
+		case typ == mp4.BoxTypeKeys():
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			if k, ok := box.(*mp4.Keys); ok {
+				for _, e := range k.Entries {
+					keys = append(keys, string(e.KeyValue))
+				}
+			}
+		case parent == mp4.BoxTypeIlst():
+			// Items are numbered by their key's (1-based) index.
+			i := int(binary.BigEndian.Uint32(typ[:]))
+			if i < 1 || i > len(keys) {
+				return nil, nil
+			}
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, nil
+			}
+			if item, ok := box.(*mp4.Item); ok && item.Data.DataType == mp4.DataTypeStringUTF8 {
+				if _, seen := values[keys[i-1]]; !seen {
+					values[keys[i-1]] = strings.TrimSpace(string(item.Data.Data))
+				}
+			}
+		case parent == mp4.BoxTypeUdta() && (typ == udtaMake || typ == udtaModel || typ == udtaLocation || typ == udtaSoftware):
+			if h.BoxInfo.Size-h.BoxInfo.HeaderSize > maxUdtaAtom {
+				return nil, nil
+			}
+			var buf bytes.Buffer
+			if _, err := h.ReadData(&buf); err != nil {
+				return nil, err
+			}
+			if v, ok := udtaString(buf.Bytes()); ok {
+				values[string(typ[1:])] = v
+			}
+		}
+		return nil, nil
+	}
+	// A damaged box late in the file still leaves what was read before it.
+	_, _ = mp4.ReadBoxStructure(rs, handler)
+
+	lookup := func(names ...string) string {
+		for _, n := range names {
+			if v := values[n]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	if tm, basis := parseQuickTimeDate(values[qtCreationDate]); tm != nil {
+		meta.TakenTime, meta.TakenBasis = tm, basis
+	} else if created != nil {
+		meta.TakenTime, meta.TakenBasis = created, TimeContainerUTC
+	}
+	if meta.CameraMaker == "" {
+		meta.CameraMaker = lookup(qtMake, androidMake, "mak")
+	}
+	if meta.CameraModel == "" {
+		meta.CameraModel = lookup(qtModel, androidModel, "mod")
+	}
+	if meta.Software == "" {
+		meta.Software = lookup(qtSoftware, "swr")
+	}
+	if meta.Location == nil {
+		meta.Location = parseISO6709(lookup(qtLocation, "xyz"))
+	}
+}
+
+// mvhdCreationTime returns the creation time recorded in an mvhd box (seconds
+// since 1904, UTC), or nil if it is unset.
+func mvhdCreationTime(mvhd *mp4.Mvhd) *time.Time {
+	var creationSecs uint64
+	if mvhd.CreationTimeV1 != 0 {
+		creationSecs = uint64(mvhd.CreationTimeV1)
+	} else if mvhd.CreationTimeV0 != 0 {
+		creationSecs = uint64(mvhd.CreationTimeV0)
+	}
+	if creationSecs == 0 {
+		return nil
+	}
+	epoch1904 := time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	tm := epoch1904.Add(time.Duration(creationSecs) * time.Second)
+	return &tm
+}
+
+// udtaString decodes a classic QuickTime udta text atom: a 16-bit length, a
+// 16-bit language code and the text.
+func udtaString(b []byte) (string, bool) {
+	if len(b) < 4 {
+		return "", false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if n > len(b)-4 {
+		return "", false
+	}
+	s := strings.TrimSpace(strings.TrimRight(string(b[4:4+n]), "\x00"))
+	return s, s != ""
+}
+
+// parseQuickTimeDate parses a com.apple.quicktime.creationdate value, the
+// local capture time with its UTC offset ("2024-06-01T14:30:05+0200").
+// Values without an offset are floating.
+func parseQuickTimeDate(s string) (*time.Time, TimeBasis) {
+	if s == "" {
+		return nil, TimeFloating
+	}
+	if tm, err := time.Parse("2006-01-02T15:04:05.999999999-0700", s); err == nil {
+		return &tm, TimeZoned
+	}
+	return parseXMPDate(s)
+}
+
+// iso6709Pattern matches the decimal-degree form of an ISO 6709 location
+// ("+45.8150+015.9819+120.000/"), which is what cameras and phones write.
+var iso6709Pattern = regexp.MustCompile(`^([+-]\d{1,2}(?:\.\d+)?)([+-]\d{1,3}(?:\.\d+)?)`)
+
+// parseISO6709 parses a location string into latitude and longitude, or nil
+// if it isn't one.
+func parseISO6709(s string) *GeoLocation {
+	m := iso6709Pattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil
+	}
+	lat, err1 := strconv.ParseFloat(m[1], 64)
+	lon, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil
+	}
+	return &GeoLocation{Latitude: lat, Longitude: lon}
+}
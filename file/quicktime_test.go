@@ -0,0 +1,169 @@
+package file
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mvhdBox returns a version 0 mvhd box with the given creation time.
+func mvhdBox(created time.Time) []byte {
+	secs := uint32(created.Sub(time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)) / time.Second)
+	return isoBox("mvhd", fullBox(0), u32(secs), u32(secs), u32(1000), u32(0),
+		u32(0x00010000), u16(0x0100), make([]byte, 10), make([]byte, 36), make([]byte, 24), u32(2))
+}
+
+// quickTimeMeta returns a QuickTime metadata meta box (no full box header, as
+// Apple writes it) holding the given keys and string values.
+func quickTimeMeta(items [][2]string) []byte {
+	hdlr := isoBox("hdlr", fullBox(0), u32(0), []byte("mdta"), make([]byte, 12), []byte{0})
+	keys := [][]byte{fullBox(0), u32(uint32(len(items)))}
+	var ilst [][]byte
+	for i, kv := range items {
+		keys = append(keys, u32(uint32(8+len(kv[0]))), []byte("mdta"), []byte(kv[0]))
+		data := isoBox("data", u32(1), u32(0), []byte(kv[1]))
+		ilst = append(ilst, append(u32(uint32(8+len(data))), append(u32(uint32(i+1)), data...)...))
+	}
+	return isoBox("meta", hdlr, isoBox("keys", keys...), isoBox("ilst", ilst...))
+}
+
+// udtaText returns a classic QuickTime udta text atom.
+func udtaText(typ, text string) []byte {
+	return isoBox(typ, u16(uint16(len(text))), u16(0x15c7), []byte(text))
+}
+
+func TestReadQuickTimeMetadata(t *testing.T) {
+	mvhd := mvhdBox(time.Date(2024, 6, 1, 12, 30, 5, 0, time.UTC))
+	tests := []struct {
+		name      string
+		file      []byte
+		wantTaken time.Time
+		wantBasis TimeBasis
+		wantMaker string
+		wantModel string
+		wantLoc   *GeoLocation
+	}{
+		{
+			name: "iPhone QuickTime keys",
+			file: bytes.Join([][]byte{
+				isoBox("ftyp", []byte("qt  "), u32(0), []byte("qt  ")),
+				isoBox("moov", mvhd, quickTimeMeta([][2]string{
+					{"com.apple.quicktime.location.ISO6709", "+45.8150+015.9819+120.000/"},
+					{"com.apple.quicktime.make", "Apple"},
+					{"com.apple.quicktime.model", "iPhone 15 Pro"},
+					{"com.apple.quicktime.creationdate", "2024-06-01T14:30:05+0200"},
+				})),
+				isoBox("mdat", []byte("video")),
+			}, nil),
+			wantTaken: time.Date(2024, 6, 1, 14, 30, 5, 0, time.FixedZone("", 2*3600)),
+			wantBasis: TimeZoned,
+			wantMaker: "Apple",
+			wantModel: "iPhone 15 Pro",
+			wantLoc:   &GeoLocation{Latitude: 45.815, Longitude: 15.9819},
+		},
+		{
+			name: "Android udta location and keys",
+			file: bytes.Join([][]byte{
+				isoBox("ftyp", []byte("isom"), u32(0), []byte("isommp42")),
+				isoBox("moov", mvhd,
+					isoBox("udta", udtaText("\xa9xyz", "-33.8688+151.2093/")),
+					quickTimeMeta([][2]string{
+						{"com.android.manufacturer", "Google"},
+						{"com.android.model", "Pixel 8"},
+					})),
+			}, nil),
+			wantTaken: time.Date(2024, 6, 1, 12, 30, 5, 0, time.UTC),
+			wantBasis: TimeContainerUTC,
+			wantMaker: "Google",
+			wantModel: "Pixel 8",
+			wantLoc:   &GeoLocation{Latitude: -33.8688, Longitude: 151.2093},
+		},
+		{
+			name: "classic udta make and model",
+			file: bytes.Join([][]byte{
+				isoBox("ftyp", []byte("qt  "), u32(0), []byte("qt  ")),
+				isoBox("moov", mvhd, isoBox("udta", udtaText("\xa9mak", "Canon"), udtaText("\xa9mod", "Canon EOS R6"))),
+			}, nil),
+			wantTaken: time.Date(2024, 6, 1, 12, 30, 5, 0, time.UTC),
+			wantBasis: TimeContainerUTC,
+			wantMaker: "Canon",
+			wantModel: "Canon EOS R6",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "clip.mov")
+			if err := os.WriteFile(path, tt.file, 0644); err != nil {
+				t.Fatal(err)
+			}
+			meta, err := extractVideoMetadata(path)
+			if err != nil {
+				t.Fatalf("extractVideoMetadata: %v", err)
+			}
+			if meta.TakenTime == nil || !meta.TakenTime.Equal(tt.wantTaken) || meta.TakenTime.Format("-0700") != tt.wantTaken.Format("-0700") {
+				t.Errorf("TakenTime = %v; want %v", meta.TakenTime, tt.wantTaken)
+			}
+			if meta.TakenBasis != tt.wantBasis {
+				t.Errorf("TakenBasis = %v; want %v", meta.TakenBasis, tt.wantBasis)
+			}
+			if meta.CameraMaker != tt.wantMaker || meta.CameraModel != tt.wantModel {
+				t.Errorf("camera = %q %q; want %q %q", meta.CameraMaker, meta.CameraModel, tt.wantMaker, tt.wantModel)
+			}
+			if (meta.Location == nil) != (tt.wantLoc == nil) || (meta.Location != nil && *meta.Location != *tt.wantLoc) {
+				t.Errorf("Location = %+v; want %+v", meta.Location, tt.wantLoc)
+			}
+		})
+	}
+}
+
+func TestExtractVideoMetadata3GP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "VID_0001.3gp")
+	file := bytes.Join([][]byte{
+		isoBox("ftyp", []byte("3gp4"), u32(0), []byte("isom3gp4")),
+		isoBox("moov", mvhdBox(time.Date(2009, 7, 4, 18, 0, 0, 0, time.UTC)), isoBox("udta", udtaText("\xa9mak", "Nokia"))),
+	}, nil)
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := extractVideoMetadata(path)
+	if err != nil {
+		t.Fatalf("extractVideoMetadata: %v", err)
+	}
+	if want := time.Date(2009, 7, 4, 18, 0, 0, 0, time.UTC); meta.TakenTime == nil || !meta.TakenTime.Equal(want) || meta.CameraMaker != "Nokia" {
+		t.Errorf("TakenTime, CameraMaker = %v, %q; want %v, Nokia", meta.TakenTime, meta.CameraMaker, want)
+	}
+}
+
+func TestParseISO6709(t *testing.T) {
+	tests := map[string]*GeoLocation{
+		"+45.8150+015.9819+120.000/": {Latitude: 45.815, Longitude: 15.9819},
+		"-33.8688+151.2093/":         {Latitude: -33.8688, Longitude: 151.2093},
+		"+45+016/":                   {Latitude: 45, Longitude: 16},
+		"+95.0000+015.0000/":         nil,
+		"Zagreb":                     nil,
+		"":                           nil,
+	}
+	for in, want := range tests {
+		got := parseISO6709(in)
+		if (got == nil) != (want == nil) || (got != nil && *got != *want) {
+			t.Errorf("parseISO6709(%q) = %+v; want %+v", in, got, want)
+		}
+	}
+}
+
+func TestGPSTokens(t *testing.T) {
+	meta := &FileMetadata{Location: &GeoLocation{Latitude: 45.815, Longitude: -15.9819}}
+	got, err := resolveTargetPath("/out/{meta.gps.lat}_{meta.gps.lon}", meta, nil, pathOptions{})
+	if err != nil {
+		t.Fatalf("resolveTargetPath: %v", err)
+	}
+	if want := filepath.Join("/out", "45.815_-15.9819"); got != want {
+		t.Errorf("path = %q; want %q", got, want)
+	}
+	got, _ = resolveTargetPath("/out/{meta.gps.lat}", &FileMetadata{}, nil, pathOptions{})
+	if !hasUnpopulatedTokens(got) {
+		t.Errorf("path = %q; want {meta.gps.lat} left unpopulated without a location", got)
+	}
+}
@@ -0,0 +1,142 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Readers for RAW formats that carry EXIF but aren't plain TIFF, so
+// exif.Decode can't read them as they are.
+
+// exifTags is the part of *exif.Exif metadata extraction uses. Formats that
+// split their EXIF over several blocks (CR3) satisfy it with multiExif.
+type exifTags interface {
+	Get(name exif.FieldName) (*tiff.Tag, error)
+}
+
+// multiExif looks tags up in several decoded EXIF blocks, in order.
+type multiExif []*exif.Exif
+
+func (m multiExif) Get(name exif.FieldName) (*tiff.Tag, error) {
+	for _, x := range m {
+		if tag, err := x.Get(name); err == nil {
+			return tag, nil
+		}
+	}
+	return nil, exif.TagNotPresentError(name)
+}
+
+var errNoCR3Exif = errors.New("cr3: no CMT boxes")
+
+// cr3UUID identifies the Canon box inside a CR3's moov that holds its
+// metadata boxes.
+var cr3UUID = []byte{0x85, 0xc0, 0xb6, 0x87, 0x82, 0x0f, 0x11, 0xe0, 0x81, 0x11, 0xf4, 0xce, 0x46, 0x2b, 0x6a, 0x48}
+
+// cr3Exif decodes the EXIF of a Canon CR3. CR3 is ISOBMFF; its metadata is a
+// set of TIFF blocks in a Canon uuid box within moov: CMT1 holds IFD0 (make,
+// model, modification date) and CMT2 the EXIF IFD (capture dates).
+func cr3Exif(r io.ReaderAt, size int64) (exifTags, error) {
+	top, err := bmffBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	moov, ok := findBMFFBox(top, "moov")
+	if !ok {
+		return nil, errNoCR3Exif
+	}
+	children, err := bmffBoxes(r, moov.start, moov.end)
+	if err != nil {
+		return nil, err
+	}
+	var m multiExif
+	for _, b := range children {
+		if b.typ != "uuid" || b.end-b.start < 16 {
+			continue
+		}
+		id := make([]byte, 16)
+		if _, err := r.ReadAt(id, b.start); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(id, cr3UUID) {
+			continue
+		}
+		canon, err := bmffBoxes(r, b.start+16, b.end)
+		if err != nil {
+			return nil, err
+		}
+		for _, typ := range []string{"CMT2", "CMT1"} {
+			cmt, ok := findBMFFBox(canon, typ)
+			if !ok {
+				continue
+			}
+			x, err := exif.Decode(io.NewSectionReader(r, cmt.start, cmt.end-cmt.start))
+			if err != nil {
+				return nil, fmt.Errorf("cr3: %s: %w", typ, err)
+			}
+			m = append(m, x)
+		}
+	}
+	if len(m) == 0 {
+		return nil, errNoCR3Exif
+	}
+	return m, nil
+}
+
+// rafMagic starts every Fujifilm RAF file.
+const rafMagic = "FUJIFILMCCD-RAW "
+
+// rafExifReader returns the embedded JPEG preview of a Fujifilm RAF, whose
+// APP1 segment carries the camera's EXIF. The RAF header records the JPEG's
+// offset and length at bytes 84 and 88; the JPEG follows the header closely,
+// so r is read sequentially and works for streamed (MTP) sources as is.
+func rafExifReader(r io.Reader) (io.Reader, error) {
+	var hdr [92]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("raf: read header: %w", err)
+	}
+	if string(hdr[:len(rafMagic)]) != rafMagic {
+		return nil, errors.New("raf: not a RAF file")
+	}
+	offset := int64(binary.BigEndian.Uint32(hdr[84:88]))
+	length := int64(binary.BigEndian.Uint32(hdr[88:92]))
+	if offset < int64(len(hdr)) || length == 0 {
+		return nil, fmt.Errorf("raf: invalid JPEG location %d+%d", offset, length)
+	}
+	if _, err := io.CopyN(io.Discard, r, offset-int64(len(hdr))); err != nil {
+		return nil, fmt.Errorf("raf: seek to JPEG: %w", err)
+	}
+	return io.LimitReader(r, length), nil
+}
+
+// tiffVariantReader returns r with a TIFF-variant header (ORF, RW2) rewritten
+// to a standard TIFF header, so exif.Decode reads it. These formats are TIFF
+// in every other respect, but use their own magic number where goexif insists
+// on the standard 42. Standard TIFF passes through unchanged.
+func tiffVariantReader(r io.Reader) (io.Reader, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("tiff: read header: %w", err)
+	}
+	var order binary.ByteOrder
+	switch string(hdr[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("tiff: invalid byte order")
+	}
+	switch order.Uint16(hdr[2:]) {
+	case 0x4f52, // Olympus "IIRO"/"MMOR"
+		0x5352, // Olympus "IIRS"
+		0x0055: // Panasonic "IIU\0"
+		order.PutUint16(hdr[2:], 42)
+	}
+	return io.MultiReader(bytes.NewReader(hdr[:]), r), nil
+}
@@ -0,0 +1,124 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// rawFixtureTIFFs returns the IFD0 (make, model, modification date) and EXIF
+// (capture date) blocks the RAW fixtures below are built from.
+func rawFixtureTIFFs(maker, model string) (ifd0, exifIFD []tiffField) {
+	return []tiffField{
+		asciiField(0x010f, maker),
+		asciiField(0x0110, model),
+		asciiField(0x0132, "2024:03:01 09:00:00"),
+	}, []tiffField{
+		asciiField(0x9003, "2024:01:15 14:30:45"),
+	}
+}
+
+// buildCR3 returns a minimal CR3: the Canon uuid box in moov holding CMT1
+// (IFD0) and CMT2 (the EXIF IFD as its own TIFF).
+func buildCR3() []byte {
+	ifd0, exifIFD := rawFixtureTIFFs("Canon", "Canon EOS R5")
+	canon := isoBox("uuid", cr3UUID,
+		isoBox("CNCV", []byte("CanonCR3_001/00.09.00/00.00.00")),
+		isoBox("CMT1", buildTIFF(ifd0, nil)),
+		isoBox("CMT2", buildTIFF(exifIFD, nil)),
+	)
+	return append(isoBox("ftyp", []byte("crx "), u32(1), []byte("crx isom")), isoBox("moov", canon)...)
+}
+
+// jpegWithExif returns a JPEG consisting of just an APP1 EXIF segment.
+func jpegWithExif(tiff []byte) []byte {
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	b := []byte{0xff, 0xd8, 0xff, 0xe1}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(seg)+2))
+	return append(append(b, seg...), 0xff, 0xd9)
+}
+
+// buildRAF returns a minimal RAF whose embedded JPEG starts a few bytes after
+// the header.
+func buildRAF() []byte {
+	ifd0, exifIFD := rawFixtureTIFFs("FUJIFILM", "X-T5")
+	jpeg := jpegWithExif(buildTIFF(ifd0, exifIFD))
+	hdr := make([]byte, 100)
+	copy(hdr, rafMagic+"0201FF129502X-T5")
+	binary.BigEndian.PutUint32(hdr[84:], uint32(len(hdr)))
+	binary.BigEndian.PutUint32(hdr[88:], uint32(len(jpeg)))
+	return append(hdr, jpeg...)
+}
+
+// buildTIFFVariant returns a TIFF with its magic number replaced, as ORF and
+// RW2 files have.
+func buildTIFFVariant(magic string, maker, model string) []byte {
+	ifd0, exifIFD := rawFixtureTIFFs(maker, model)
+	b := buildTIFF(ifd0, exifIFD)
+	copy(b[2:4], magic)
+	return b
+}
+
+func TestExtractImageMetadataRAW(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		maker string
+		model string
+	}{
+		{"IMG_0001.CR3", buildCR3(), "Canon", "Canon EOS R5"},
+		{"DSCF0001.RAF", buildRAF(), "FUJIFILM", "X-T5"},
+		{"P1010001.ORF", buildTIFFVariant("RO", "OM Digital Solutions", "OM-1"), "OM Digital Solutions", "OM-1"},
+		{"P1000001.RW2", buildTIFFVariant("U\x00", "Panasonic", "DC-S5M2"), "Panasonic", "DC-S5M2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A streamed entry, as over MTP: there is no exiftool fallback.
+			entry := &fakeEntry{name: tt.name, bodies: [][]byte{tt.data}}
+			meta, err := extractImageMetadataFromEntry(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const layout = "2006-01-02 15:04:05"
+			if meta.TakenTime == nil || meta.TakenTime.Format(layout) != "2024-01-15 14:30:45" {
+				t.Errorf("TakenTime = %v; want 2024-01-15 14:30:45", meta.TakenTime)
+			}
+			if meta.ModifiedTime == nil || meta.ModifiedTime.Format(layout) != "2024-03-01 09:00:00" {
+				t.Errorf("ModifiedTime = %v; want 2024-03-01 09:00:00", meta.ModifiedTime)
+			}
+			if meta.CameraMaker != tt.maker || meta.CameraModel != tt.model {
+				t.Errorf("camera = %q %q; want %q %q", meta.CameraMaker, meta.CameraModel, tt.maker, tt.model)
+			}
+		})
+	}
+}
+
+func TestTIFFVariantReader(t *testing.T) {
+	plain := buildTIFF([]tiffField{asciiField(0x010f, "Acme")}, nil)
+	for _, data := range [][]byte{plain, buildTIFFVariant("RO", "Acme", "X")} {
+		r, err := tiffVariantReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		got.ReadFrom(r)
+		if string(got.Bytes()[:4]) != "II*\x00" || got.Len() != len(data) {
+			t.Errorf("tiffVariantReader header = %q, %d bytes; want II*\\x00, %d bytes", got.Bytes()[:4], got.Len(), len(data))
+		}
+	}
+	if _, err := tiffVariantReader(bytes.NewReader([]byte("FUJIFILM"))); err == nil {
+		t.Error("tiffVariantReader accepted a non-TIFF header")
+	}
+}
+
+func TestRAFExifReaderRejectsBadHeader(t *testing.T) {
+	raf := buildRAF()
+	bad := append([]byte(nil), raf...)
+	copy(bad, "NOTAFUJIFILMFILE")
+	if _, err := rafExifReader(bytes.NewReader(bad)); err == nil {
+		t.Error("rafExifReader accepted a file without the RAF magic")
+	}
+	if _, err := rafExifReader(bytes.NewReader(raf[:50])); err == nil {
+		t.Error("rafExifReader accepted a truncated header")
+	}
+}
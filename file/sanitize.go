@@ -0,0 +1,147 @@
+package file
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// sanitizeRules describe which path components a target filesystem accepts.
+// Token values are rendered into target paths verbatim from metadata (camera
+// models like "DSC-RX100M3/M4", makers with odd Unicode), so every value is
+// cleaned with the target's rules before it becomes part of a path.
+type sanitizeRules struct {
+	// reserved are the characters replaced with replacement in token values,
+	// in addition to control characters.
+	reserved string
+	// trimTrailing strips trailing dots and spaces from each path component,
+	// which Windows silently drops (so "Corp." and "Corp" would collide).
+	trimTrailing bool
+	// reservedNames rejects DOS device names (CON, NUL, COM1, …) as path
+	// components, with or without an extension.
+	reservedNames bool
+	// maxComponentBytes limits the length of each path component in bytes.
+	maxComponentBytes int
+}
+
+// sanitizeReplacement stands in for every character a target rejects.
+const sanitizeReplacement = '_'
+
+// sanitizeProfiles are the selectable target.sanitize values.
+var sanitizeProfiles = map[string]*sanitizeRules{
+	"posix": {
+		reserved:          "/",
+		maxComponentBytes: 255,
+	},
+	"windows": {
+		reserved:          `<>:"/\|?*`,
+		trimTrailing:      true,
+		reservedNames:     true,
+		maxComponentBytes: 255,
+	},
+	// FAT and exFAT (SD cards, USB sticks) share the Windows rules; the extra
+	// characters are rejected by older FAT drivers and camera firmware.
+	"fat": {
+		reserved:          `<>:"/\|?*+,;=[]`,
+		trimTrailing:      true,
+		reservedNames:     true,
+		maxComponentBytes: 255,
+	},
+}
+
+// defaultSanitizeRules returns the rules for the platform FileFerry runs on,
+// used when a profile doesn't set target.sanitize.
+func defaultSanitizeRules() *sanitizeRules {
+	if runtime.GOOS == "windows" {
+		return sanitizeProfiles["windows"]
+	}
+	return sanitizeProfiles["posix"]
+}
+
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// value cleans a single token value: reserved and control characters are
+// replaced, so the value can never introduce a path separator, and a value
+// of "." or ".." is replaced whole, so it can never name the current or
+// parent directory.
+func (r *sanitizeRules) value(v string) string {
+	if v == "." || v == ".." {
+		return strings.Repeat(string(sanitizeReplacement), len(v))
+	}
+	return strings.Map(func(c rune) rune {
+		if c == utf8.RuneError || unicode.IsControl(c) || strings.ContainsRune(r.reserved, c) {
+			return sanitizeReplacement
+		}
+		return c
+	}, v)
+}
+
+// pathValue cleans a token value that is itself a relative path (such as
+// {source.relpath}): each element is cleaned with value, but the separators
+// between them are kept.
+func (r *sanitizeRules) pathValue(v string) string {
+	parts := strings.FieldsFunc(v, func(c rune) bool { return c == '/' || c == filepath.Separator })
+	for i, p := range parts {
+		parts[i] = r.value(p)
+	}
+	return strings.Join(parts, string(filepath.Separator))
+}
+
+// components applies the per-component rules (trailing dots and spaces,
+// reserved names, length) to every component of a rendered path. The volume
+// name (e.g. "C:") is left alone.
+func (r *sanitizeRules) components(path string) string {
+	vol := filepath.VolumeName(path)
+	parts := strings.Split(path[len(vol):], string(filepath.Separator))
+	for i, p := range parts {
+		if p == "" || p == "." || p == ".." {
+			continue
+		}
+		parts[i] = r.component(p, i == len(parts)-1)
+	}
+	return vol + strings.Join(parts, string(filepath.Separator))
+}
+
+func (r *sanitizeRules) component(c string, isBase bool) string {
+	if r.trimTrailing {
+		if trimmed := strings.TrimRight(c, ". "); trimmed != "" {
+			c = trimmed
+		}
+	}
+	if r.reservedNames {
+		stem, _, _ := strings.Cut(c, ".")
+		if windowsReservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+			c = string(sanitizeReplacement) + c
+		}
+	}
+	if r.maxComponentBytes > 0 && len(c) > r.maxComponentBytes {
+		ext := ""
+		if isBase {
+			ext = filepath.Ext(c)
+			if len(ext) >= r.maxComponentBytes {
+				ext = ""
+			}
+		}
+		c = truncateUTF8(strings.TrimSuffix(c, ext), r.maxComponentBytes-len(ext)) + ext
+	}
+	return c
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
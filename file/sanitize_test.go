@@ -0,0 +1,127 @@
+package file
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeRulesValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		input   string
+		want    string
+	}{
+		{name: "posix slash", profile: "posix", input: "DSC-RX100M3/M4", want: "DSC-RX100M3_M4"},
+		{name: "posix keeps colon", profile: "posix", input: "iPhone 15 Pro: Max", want: "iPhone 15 Pro: Max"},
+		{name: "posix control chars", profile: "posix", input: "Canon\x00\x00", want: "Canon__"},
+		{name: "windows colon", profile: "windows", input: "iPhone 15 Pro: Max", want: "iPhone 15 Pro_ Max"},
+		{name: "windows reserved set", profile: "windows", input: `a<b>c"d\e|f?g*h`, want: "a_b_c_d_e_f_g_h"},
+		{name: "windows keeps unicode", profile: "windows", input: "Škoda Ćevap", want: "Škoda Ćevap"},
+		{name: "fat extra chars", profile: "fat", input: "a+b,c;d=e[f]", want: "a_b_c_d_e_f_"},
+		{name: "invalid utf8", profile: "posix", input: "ab\xffc", want: "ab_c"},
+		{name: "parent directory", profile: "posix", input: "..", want: "__"},
+		{name: "current directory", profile: "posix", input: ".", want: "_"},
+		{name: "dots within a value", profile: "posix", input: "..x", want: "..x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeProfiles[tt.profile].value(tt.input); got != tt.want {
+				t.Errorf("value(%q) = %q; want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeRulesComponents(t *testing.T) {
+	long := strings.Repeat("é", 200) // 400 bytes
+
+	tests := []struct {
+		name    string
+		profile string
+		input   string
+		want    string
+	}{
+		{name: "windows trailing dots and spaces", profile: "windows", input: "/out/Corp. /x.jpg", want: "/out/Corp/x.jpg"},
+		{name: "posix keeps trailing dots", profile: "posix", input: "/out/Corp./x.jpg", want: "/out/Corp./x.jpg"},
+		{name: "windows reserved name", profile: "windows", input: "/out/CON/x.jpg", want: "/out/_CON/x.jpg"},
+		{name: "windows reserved name with extension", profile: "windows", input: "/out/nul.jpg", want: "/out/_nul.jpg"},
+		{name: "windows reserved prefix is fine", profile: "windows", input: "/out/CONSOLE/x.jpg", want: "/out/CONSOLE/x.jpg"},
+		{name: "posix reserved name is fine", profile: "posix", input: "/out/CON/x.jpg", want: "/out/CON/x.jpg"},
+		{name: "long base keeps extension", profile: "posix", input: "/out/" + long + ".jpg", want: "/out/" + strings.Repeat("é", 125) + ".jpg"},
+		{name: "long directory", profile: "posix", input: "/out/" + long + "/x.jpg", want: "/out/" + strings.Repeat("é", 127) + "/x.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeProfiles[tt.profile].components(filepath.FromSlash(tt.input))
+			if want := filepath.FromSlash(tt.want); got != want {
+				t.Errorf("components(%q) = %q; want %q", tt.input, got, want)
+			}
+		})
+	}
+}
+
+func TestResolveTargetPathSanitizesTokenValues(t *testing.T) {
+	meta := &FileMetadata{Extension: "jpg", CameraMaker: "Sony", CameraModel: "DSC-RX100M3/M4"}
+	sf := &sourceFile{Name: "a:b.jpg", RelPath: "trip: day 1/a:b.jpg"}
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		profile string
+		want    string
+	}{
+		{
+			name:    "model slash does not add a directory",
+			tmpl:    "/out/{meta.camera.maker}/{meta.camera.model}/x.{file.extension}",
+			profile: "posix",
+			want:    "/out/Sony/DSC-RX100M3_M4/x.jpg",
+		},
+		{
+			name:    "relpath keeps its directories",
+			tmpl:    "/out/{source.relpath}",
+			profile: "windows",
+			want:    "/out/trip_ day 1/a_b.jpg",
+		},
+		{
+			name:    "template literals are not sanitized",
+			tmpl:    "/out/{file.stem}.{file.extension}",
+			profile: "windows",
+			want:    "/out/a_b.jpg",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveTargetPath(tt.tmpl, meta, sf, pathOptions{sanitize: sanitizeProfiles[tt.profile]})
+			if err != nil {
+				t.Fatalf("resolveTargetPath() error = %v", err)
+			}
+			if want := filepath.FromSlash(tt.want); got != want {
+				t.Errorf("resolveTargetPath() = %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestResolveTargetPathTraversalThroughTokenValue(t *testing.T) {
+	meta := &FileMetadata{Extension: "jpg", CameraModel: "..", Keywords: []string{"."}, Custom: map[string]string{"album": ".."}}
+	sf := &sourceFile{Name: "IMG.jpg", RelPath: "IMG.jpg"}
+
+	for _, tmpl := range []string{
+		"/out/{meta.camera.model}/{file.name}",
+		"/out/{meta.keywords:first}/{file.name}",
+		"/out/{meta.custom.album}/{meta.custom.album}/{file.name}",
+	} {
+		got, err := resolveTargetPath(tmpl, meta, sf, pathOptions{sanitize: sanitizeProfiles["posix"]})
+		if err != nil {
+			t.Fatalf("resolveTargetPath(%q) error = %v", tmpl, err)
+		}
+		if !strings.HasPrefix(filepath.Clean(got), filepath.FromSlash("/out/")) || filepath.Clean(got) != got {
+			t.Errorf("resolveTargetPath(%q) = %q; want a path inside /out", tmpl, got)
+		}
+	}
+}
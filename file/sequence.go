@@ -0,0 +1,157 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// seqTokenPattern matches the {seq} and {seq:NN} tokens. Unlike the other
+// tokens they can't be resolved per file: the number depends on every other
+// file planned into the same place, so processFile leaves them in NewPath and
+// the iterator resolves them once all files are known (see assignSequence).
+var seqTokenPattern = regexp.MustCompile(`\{seq(?::(\d+))?\}`)
+
+// hasSeqToken reports whether path still contains a {seq} token.
+func hasSeqToken(path string) bool {
+	return seqTokenPattern.MatchString(path)
+}
+
+// withoutSeqTokens removes {seq} tokens from path, so a path pending sequence
+// assignment can be checked for other unpopulated tokens.
+func withoutSeqTokens(path string) string {
+	return seqTokenPattern.ReplaceAllString(path, "")
+}
+
+// assignSequence resolves {seq} tokens in the target paths of files, in place.
+//
+// Files are grouped by the directory and name stem of their target path (the
+// path with {seq} still unresolved, minus its extension), and each group is
+// numbered from 1 in order of taken time, then original filename. The order
+// depends only on the files themselves, not on scan or worker order, so the
+// same set of files always gets the same names. Numbers where the file's or
+// one of its companions' destination already holds a different file (say,
+// from an earlier run) are skipped, so a later burst continues the numbering
+// rather than colliding with it. A file whose destinations can't be
+// inspected gets the error instead.
+func assignSequence(files []File) {
+	groups := make(map[string][]int)
+	for i, f := range files {
+		key := strings.TrimSuffix(f.NewPath, filepath.Ext(f.NewPath))
+		groups[key] = append(groups[key], i)
+	}
+
+	for _, idxs := range groups {
+		sort.SliceStable(idxs, func(a, b int) bool {
+			return seqLess(&files[idxs[a]], &files[idxs[b]])
+		})
+		used := make(map[int]bool, len(idxs))
+		for _, i := range idxs {
+			f := &files[i]
+			n, err := freeSeq(f, used)
+			if err != nil {
+				f.Error = err
+				continue
+			}
+			used[n] = true
+			setOp(f, f.Entry, withSeq(f.NewPath, n))
+		}
+	}
+}
+
+// freeSeq returns the lowest number not in used that f and its companions
+// can take: each destination is free or already holds the same content.
+// The sources are hashed at most once, however many numbers are taken.
+func freeSeq(f *File, used map[int]bool) (int, error) {
+	members := []Entry{f.Entry}
+	for _, c := range f.Companions {
+		members = append(members, c.Entry)
+	}
+	sums := make([]string, len(members))
+	for n := 1; n <= maxConflictSuffix; n++ {
+		if used[n] {
+			continue
+		}
+		primary := withSeq(f.NewPath, n)
+		paths := []string{primary}
+		for _, c := range f.Companions {
+			paths = append(paths, c.path(primary))
+		}
+		free := true
+		for i, path := range paths {
+			same, err := seqHolds(path, members[i], &sums[i])
+			if err != nil {
+				return 0, err
+			}
+			if !same {
+				free = false
+				break
+			}
+		}
+		if free {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("no free sequence number for %s after %d", f.NewPath, maxConflictSuffix)
+}
+
+// seqHolds reports whether path is free or holds entry's content. sum
+// remembers the entry's SHA-256 across calls.
+func seqHolds(path string, entry Entry, sum *string) (bool, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("stat destination %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return false, nil
+	}
+	destHash, err := hashFile(path)
+	if err != nil {
+		return false, fmt.Errorf("hash existing destination %s: %w", path, err)
+	}
+	if *sum == "" {
+		if *sum, err = hashEntry(entry); err != nil {
+			return false, fmt.Errorf("read source %s: %w", entry.DisplayPath(), err)
+		}
+	}
+	return *sum == destHash, nil
+}
+
+// withSeq resolves the {seq} tokens in path to n.
+func withSeq(path string, n int) string {
+	return seqTokenPattern.ReplaceAllStringFunc(path, func(tok string) string {
+		width := 0
+		if m := seqTokenPattern.FindStringSubmatch(tok); m[1] != "" {
+			width, _ = strconv.Atoi(m[1])
+		}
+		return fmt.Sprintf("%0*d", width, n)
+	})
+}
+
+// seqLess orders files within a sequence group: by taken time (files without
+// one first), then by original filename, then by source location.
+func seqLess(a, b *File) bool {
+	ta, tb := takenTimeOf(a), takenTimeOf(b)
+	if !ta.Equal(tb) {
+		return ta.Before(tb)
+	}
+	if na, nb := a.Entry.Name(), b.Entry.Name(); na != nb {
+		return na < nb
+	}
+	return a.OldPath < b.OldPath
+}
+
+func takenTimeOf(f *File) time.Time {
+	if f.Metadata == nil || f.Metadata.TakenTime == nil {
+		return time.Time{}
+	}
+	return *f.Metadata.TakenTime
+}
@@ -0,0 +1,193 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+func TestAssignSequence(t *testing.T) {
+	t1 := time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC)
+	t2 := t1.Add(time.Second)
+
+	planned := func(name, path string, tm *time.Time) File {
+		return File{
+			OldPath:  "fake://" + name,
+			NewPath:  path,
+			Metadata: &FileMetadata{TakenTime: tm},
+			Entry:    &fakeEntry{name: name, bodies: [][]byte{nil}},
+		}
+	}
+	files := []File{
+		planned("b.jpg", "/out/2024_{seq:03}.jpg", &t1),
+		planned("later.jpg", "/out/2024_{seq:03}.jpg", &t2),
+		planned("a.jpg", "/out/2024_{seq:03}.jpg", &t1),
+		planned("a.dng", "/out/2024_{seq:03}.dng", &t2),
+		planned("other.jpg", "/out/other/{seq}.jpg", &t1),
+	}
+
+	assignSequence(files)
+
+	want := map[string]string{
+		"b.jpg":     "/out/2024_002.jpg",
+		"later.jpg": "/out/2024_004.jpg",
+		"a.jpg":     "/out/2024_001.jpg",
+		"a.dng":     "/out/2024_003.dng", // same stem group, ordered by taken time
+		"other.jpg": "/out/other/1.jpg",
+	}
+	for _, f := range files {
+		if got := f.NewPath; got != want[f.Entry.Name()] {
+			t.Errorf("%s: NewPath = %q; want %q", f.Entry.Name(), got, want[f.Entry.Name()])
+		}
+		if !f.ShouldOp {
+			t.Errorf("%s: ShouldOp = false; want true", f.Entry.Name())
+		}
+	}
+}
+
+func TestFileIteratorAssignsSequence(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcDir := filepath.Join(tmpDir, "src")
+	if err := os.Mkdir(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Three burst shots in the same second, one file a second later.
+	for _, name := range []string{"2024-01-15 14-30-45 c.jpg", "2024-01-15 14-30-45 a.jpg", "2024-01-15 14-30-45 b.jpg", "2024-01-15 14-30-46 a.jpg"} {
+		mustWrite(t, filepath.Join(srcDir, name), name)
+	}
+
+	outDir := filepath.Join(tmpDir, "out")
+	cfg := &ffcfg.Config{
+		Profiles: map[string]ffcfg.ProfileConfig{
+			"burst": {
+				Sources:  []ffcfg.SourceConfig{{Path: srcDir, Types: []string{"image"}}},
+				Patterns: []string{"{meta.taken.date} {meta.taken.time} a.jpg", "{meta.taken.date} {meta.taken.time} b.jpg", "{meta.taken.date} {meta.taken.time} c.jpg"},
+				Target:   ffcfg.TargetPathConfig{Path: filepath.Join(outDir, "{meta.taken.datetime}-{seq:02}.{file.extension}")},
+			},
+		},
+	}
+
+	got := map[string]string{}
+	for f := range FileIterator(cfg) {
+		if f.Error != nil {
+			t.Fatalf("%s: unexpected error: %v", f.OldPath, f.Error)
+		}
+		got[filepath.Base(f.OldPath)] = f.NewPath
+	}
+
+	want := map[string]string{
+		"2024-01-15 14-30-45 a.jpg": filepath.Join(outDir, "2024-01-15-14-30-45-01.jpg"),
+		"2024-01-15 14-30-45 b.jpg": filepath.Join(outDir, "2024-01-15-14-30-45-02.jpg"),
+		"2024-01-15 14-30-45 c.jpg": filepath.Join(outDir, "2024-01-15-14-30-45-03.jpg"),
+		"2024-01-15 14-30-46 a.jpg": filepath.Join(outDir, "2024-01-15-14-30-46-01.jpg"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d files; want %d", len(got), len(want))
+	}
+	for name, path := range want {
+		if got[name] != path {
+			t.Errorf("%s: NewPath = %q; want %q", name, got[name], path)
+		}
+	}
+}
+
+func TestFileIteratorSequenceAcrossRuns(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcDir := filepath.Join(tmpDir, "src")
+	if err := os.Mkdir(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(tmpDir, "out")
+	cfg := &ffcfg.Config{
+		Profiles: map[string]ffcfg.ProfileConfig{
+			"burst": {
+				Sources:  []ffcfg.SourceConfig{{Path: srcDir, Types: []string{"image"}}},
+				Patterns: []string{"{meta.taken.date} {meta.taken.time} {*}.jpg"},
+				Target:   ffcfg.TargetPathConfig{Path: filepath.Join(outDir, "{meta.taken.datetime}-{seq:02}.{file.extension}")},
+			},
+		},
+	}
+	run := func() map[string]string {
+		got := map[string]string{}
+		for f := range FileIterator(cfg) {
+			if f.Error != nil {
+				t.Fatalf("%s: unexpected error: %v", f.OldPath, f.Error)
+			}
+			if _, err := MoveEntry(f.Entry, f.NewPath); err != nil {
+				t.Fatalf("%s: %v", f.OldPath, err)
+			}
+			got[filepath.Base(f.OldPath)] = filepath.Base(f.NewPath)
+		}
+		return got
+	}
+
+	for _, name := range []string{"2024-01-15 14-30-45 b.jpg", "2024-01-15 14-30-45 c.jpg"} {
+		mustWrite(t, filepath.Join(srcDir, name), name)
+	}
+	run()
+
+	// A later run brings another shot from the same second, which sorts
+	// first, and one of the earlier shots again.
+	for _, name := range []string{"2024-01-15 14-30-45 a.jpg", "2024-01-15 14-30-45 b.jpg"} {
+		mustWrite(t, filepath.Join(srcDir, name), name)
+	}
+	got := run()
+
+	want := map[string]string{
+		"2024-01-15 14-30-45 a.jpg": "2024-01-15-14-30-45-03.jpg",
+		"2024-01-15 14-30-45 b.jpg": "2024-01-15-14-30-45-01.jpg",
+	}
+	for name, path := range want {
+		if got[name] != path {
+			t.Errorf("%s: NewPath = %q; want %q", name, got[name], path)
+		}
+	}
+	if entries, _ := os.ReadDir(srcDir); len(entries) != 0 {
+		t.Errorf("%d files left in the source; want all moved or deduplicated", len(entries))
+	}
+}
+
+func TestAssignSequenceExistingDestinations(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a-01.jpg", "a-02.jpg", "a-03.jpg", "b-01.xmp"} {
+		mustWrite(t, filepath.Join(dir, name), "another picture")
+	}
+	mustWrite(t, filepath.Join(dir, "blocked"), "a file where a directory should be")
+
+	photo := &fakeEntry{name: "IMG_1.jpg", bodies: [][]byte{[]byte("picture")}}
+	raw := &fakeEntry{name: "IMG_2.cr3", bodies: [][]byte{[]byte("raw")}}
+	sidecar := &fakeEntry{name: "IMG_2.xmp", bodies: [][]byte{[]byte("<x:xmpmeta/>")}}
+	blocked := &fakeEntry{name: "IMG_3.jpg", bodies: [][]byte{[]byte("picture")}}
+	files := []File{
+		{OldPath: "fake://IMG_1.jpg", NewPath: filepath.Join(dir, "a-{seq:02}.jpg"), Entry: photo},
+		{OldPath: "fake://IMG_2.cr3", NewPath: filepath.Join(dir, "b-{seq:02}.cr3"), Entry: raw, Companions: []Companion{{Entry: sidecar, suffix: ".xmp"}}},
+		{OldPath: "fake://IMG_3.jpg", NewPath: filepath.Join(dir, "blocked", "{seq}.jpg"), Entry: blocked},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		assignSequence(files)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("assignSequence did not return")
+	}
+
+	if want := filepath.Join(dir, "a-04.jpg"); files[0].NewPath != want || files[0].Error != nil {
+		t.Errorf("NewPath = %q, %v; want %q", files[0].NewPath, files[0].Error, want)
+	}
+	if photo.opens != 1 {
+		t.Errorf("source read %d times; want once for all occupied numbers", photo.opens)
+	}
+	if want := filepath.Join(dir, "b-02.cr3"); files[1].NewPath != want || files[1].Companions[0].NewPath != filepath.Join(dir, "b-02.xmp") {
+		t.Errorf("NewPath = %q, companion %q; want %q, skipping the companion's occupied number", files[1].NewPath, files[1].Companions[0].NewPath, want)
+	}
+	if files[2].Error == nil || files[2].ShouldOp {
+		t.Errorf("blocked: Error = %v, ShouldOp = %v; want the stat error and no move", files[2].Error, files[2].ShouldOp)
+	}
+}
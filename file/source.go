@@ -38,6 +38,34 @@ type localPathProvider interface {
 	LocalPath() string
 }
 
+// relPathProvider is implemented by entries that know their location relative
+// to the scanned source root. It backs the {source.relpath} and
+// {source.reldir} template tokens; entries without it are treated as sitting
+// directly in the root.
+type relPathProvider interface {
+	// RelPath is the path relative to the source root, using "/" separators,
+	// e.g. "sub/IMG_1234.JPG".
+	RelPath() string
+}
+
+// sha256Memo is implemented by entries that remember their content's SHA-256
+// once it has been computed, so later steps (duplicate checks and MoveEntry's
+// verification) don't read the source again. Over MTP every read streams the
+// whole file from the device.
+type sha256Memo interface {
+	knownSHA256() (string, bool)
+	rememberSHA256(sum string)
+}
+
+// hashMemo implements sha256Memo for embedding in Entry implementations. An
+// entry is planned and moved by one goroutine at a time, so it needs no lock.
+type hashMemo struct {
+	sha256 string
+}
+
+func (m *hashMemo) knownSHA256() (string, bool) { return m.sha256, m.sha256 != "" }
+func (m *hashMemo) rememberSHA256(sum string)   { m.sha256 = sum }
+
 // Source is an open scan target. For MTP sources the underlying device session
 // must stay alive for as long as the returned Entries are used (their Open and
 // Delete calls reuse it), so callers must not Close the Source until all moves
@@ -87,7 +115,7 @@ func (s *localSource) Scan(types []string, recurse bool) ([]Entry, error) {
 			return nil
 		}
 		if isFileType(path, types) {
-			entries = append(entries, &localEntry{path: path, info: info})
+			entries = append(entries, &localEntry{path: path, info: info, root: s.root})
 		}
 		return nil
 	}
@@ -101,8 +129,10 @@ func (s *localSource) Close() error { return nil }
 
 // localEntry is a file on the local filesystem.
 type localEntry struct {
+	hashMemo
 	path string
 	info os.FileInfo
+	root string // source root the entry was found under, if known
 }
 
 func (e *localEntry) Name() string                 { return filepath.Base(e.path) }
@@ -113,6 +143,15 @@ func (e *localEntry) Open() (io.ReadCloser, error) { return os.Open(e.path) }
 func (e *localEntry) Delete() error                { return os.Remove(e.path) }
 func (e *localEntry) LocalPath() string            { return e.path }
 
+func (e *localEntry) RelPath() string {
+	if e.root != "" {
+		if rel, err := filepath.Rel(e.root, e.path); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return e.Name()
+}
+
 // mtpSource scans a folder on an MTP device.
 type mtpSource struct {
 	sess mtp.Session
@@ -137,6 +176,7 @@ func (s *mtpSource) Close() error { return s.sess.Close() }
 
 // mtpEntry is a file on an MTP device.
 type mtpEntry struct {
+	hashMemo
 	obj  mtp.Object
 	base string
 }
@@ -147,3 +187,4 @@ func (e *mtpEntry) Size() int64                  { return e.obj.Size() }
 func (e *mtpEntry) ModTime() time.Time           { return e.obj.ModTime() }
 func (e *mtpEntry) Open() (io.ReadCloser, error) { return e.obj.Open() }
 func (e *mtpEntry) Delete() error                { return e.obj.Delete() }
+func (e *mtpEntry) RelPath() string              { return e.obj.RelPath() }
@@ -45,6 +45,18 @@ func TestOpenSourceLocalScan(t *testing.T) {
 	if len(rec) != 2 {
 		t.Errorf("recursive scan found %d entries; want 2", len(rec))
 	}
+
+	relPaths := map[string]bool{}
+	for _, e := range rec {
+		rp, ok := e.(relPathProvider)
+		if !ok {
+			t.Fatalf("local entry %s does not provide RelPath", e.DisplayPath())
+		}
+		relPaths[rp.RelPath()] = true
+	}
+	if !relPaths["a.jpg"] || !relPaths["sub/c.jpg"] {
+		t.Errorf("RelPath values = %v; want a.jpg and sub/c.jpg", relPaths)
+	}
 }
 
 func TestLocalEntryOpenAndDelete(t *testing.T) {
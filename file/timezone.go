@@ -0,0 +1,88 @@
+package file
+
+import (
+	"time"
+)
+
+// TimeBasis records how a metadata time relates to a time zone. Cameras and
+// containers disagree: EXIF stores wall-clock readings with an optional
+// offset, MP4 and Matroska store UTC (though some cameras write local time
+// there anyway), and filenames carry whatever the camera's clock showed.
+type TimeBasis int
+
+const (
+	// TimeFloating is a wall-clock reading without zone information (EXIF
+	// without an offset tag, filename patterns). It is interpreted in the
+	// profile's timezone.
+	TimeFloating TimeBasis = iota
+	// TimeZoned carries an explicit UTC offset (e.g. EXIF
+	// OffsetTimeOriginal) and is rendered in that offset.
+	TimeZoned
+	// TimeContainerUTC comes from a container field defined as UTC (MP4
+	// mvhd, Matroska DateUTC, PNG tIME). It is converted to the profile's
+	// timezone, unless the source declares container_time: local.
+	TimeContainerUTC
+	// TimeInstant is an absolute instant: a filesystem timestamp
+	// (modification or birth time) or a Unix time in a filename. It is converted to the profile's timezone;
+	// container_time doesn't apply.
+	TimeInstant
+)
+
+// ContainerTimeLocal is the SourceConfig.ContainerTime value declaring that a
+// source's container "UTC" times are really local wall-clock readings.
+const ContainerTimeLocal = "local"
+
+// anchorTimes returns a copy of meta whose times are definite instants in the
+// location they should be rendered in: floating times are read as wall clock
+// in opts' timezone, zoned times keep their own offset and container UTC
+// times are converted to opts' timezone. The result depends only on the
+// metadata and the profile, never on the machine's timezone (unless the
+// profile leaves timezone unset, which means the machine's).
+func anchorTimes(meta *FileMetadata, opts pathOptions) *FileMetadata {
+	if meta == nil {
+		return meta
+	}
+	anchored := *meta
+	anchored.TakenTime = anchorTime(meta.TakenTime, meta.TakenBasis, opts)
+	anchored.DigitizedTime = anchorTime(meta.DigitizedTime, meta.DigitizedBasis, opts)
+	anchored.ModifiedTime = anchorTime(meta.ModifiedTime, meta.ModifiedBasis, opts)
+	return &anchored
+}
+
+func anchorTime(tm *time.Time, basis TimeBasis, opts pathOptions) *time.Time {
+	if tm == nil {
+		return nil
+	}
+	loc := opts.location()
+	t := *tm
+	switch basis {
+	case TimeFloating:
+		t = wallClockIn(t, loc)
+	case TimeContainerUTC:
+		if opts.containerLocal {
+			t = wallClockIn(t.UTC(), loc)
+		} else {
+			t = t.In(loc)
+		}
+	case TimeInstant:
+		t = t.In(loc)
+	}
+	return &t
+}
+
+// wallClockIn reinterprets t's wall-clock reading (in its own location) as a
+// reading in loc.
+func wallClockIn(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// parseOffset parses an EXIF-style UTC offset ("+02:00", "-0530", "Z").
+func parseOffset(s string) (*time.Location, bool) {
+	for _, layout := range []string{"-07:00", "-0700", "Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			_, off := t.Zone()
+			return time.FixedZone("", off), true
+		}
+	}
+	return nil, false
+}
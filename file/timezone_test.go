@@ -0,0 +1,109 @@
+package file
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnchorTimes(t *testing.T) {
+	zagreb, err := time.LoadLocation("Europe/Zagreb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plus5 := time.FixedZone("", 5*3600)
+	wall := time.Date(2024, 7, 1, 23, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		basis TimeBasis
+		in    time.Time
+		opts  pathOptions
+		want  string
+	}{
+		{"floating reads wall clock in profile zone", TimeFloating, wall, pathOptions{loc: zagreb}, "2024-07-01 23:30 +0200"},
+		{"zoned keeps its offset", TimeZoned, wall.In(plus5), pathOptions{loc: zagreb}, "2024-07-02 04:30 +0500"},
+		{"container UTC is converted", TimeContainerUTC, wall, pathOptions{loc: zagreb}, "2024-07-02 01:30 +0200"},
+		{"container local is wall clock", TimeContainerUTC, wall, pathOptions{loc: zagreb, containerLocal: true}, "2024-07-01 23:30 +0200"},
+		{"UTC profile", TimeContainerUTC, wall, pathOptions{loc: time.UTC}, "2024-07-01 23:30 +0000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := &FileMetadata{TakenTime: timePtr(tt.in), TakenBasis: tt.basis}
+			got := anchorTimes(meta, tt.opts)
+			if s := got.TakenTime.Format("2006-01-02 15:04 -0700"); s != tt.want {
+				t.Errorf("anchored = %s; want %s", s, tt.want)
+			}
+			if !meta.TakenTime.Equal(tt.in) {
+				t.Errorf("anchorTimes modified its input")
+			}
+		})
+	}
+
+	if got := anchorTimes(&FileMetadata{}, pathOptions{}); got.TakenTime != nil {
+		t.Errorf("anchorTimes invented a time: %v", got.TakenTime)
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"+02:00", 7200, true},
+		{"-0530", -19800, true},
+		{"Z", 0, true},
+		{"   ", 0, false},
+		{"02:00", 0, false},
+	}
+	for _, tt := range tests {
+		loc, ok := parseOffset(tt.in)
+		if ok != tt.ok {
+			t.Errorf("parseOffset(%q) ok = %v; want %v", tt.in, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if _, off := time.Date(2024, 1, 1, 0, 0, 0, 0, loc).Zone(); off != tt.want {
+			t.Errorf("parseOffset(%q) offset = %d; want %d", tt.in, off, tt.want)
+		}
+	}
+}
+
+func TestTimeTokens(t *testing.T) {
+	tm := time.Date(2024, 7, 1, 23, 30, 0, 0, time.FixedZone("CEST", 7200))
+	meta := &FileMetadata{TakenTime: &tm}
+	got, err := resolveTargetPath("/out/{meta.taken.date}/{meta.taken.tz}-{meta.taken.tz:name}.jpg", meta, nil, pathOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("/out", "2024-07-01", "+0200-CEST.jpg"); got != want {
+		t.Errorf("resolveTargetPath = %q; want %q", got, want)
+	}
+
+	burst := time.Date(2024, 7, 1, 23, 30, 0, 70*int(time.Millisecond), time.UTC)
+	got, err = resolveTargetPath("/out/{meta.taken.datetime:ms}_{meta.taken.ms}.jpg", &FileMetadata{TakenTime: &burst}, nil, pathOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("/out", "2024-07-01-23-30-00-070_070.jpg"); got != want {
+		t.Errorf("resolveTargetPath = %q; want %q", got, want)
+	}
+
+	scanned := &FileMetadata{TakenTime: &tm, DigitizedTime: &burst}
+	got, err = resolveTargetPath("/out/{meta.taken.year}/{meta.digitized.date}_{meta.digitized.tz}.jpg", scanned, nil, pathOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("/out", "2024", "2024-07-01_+0000.jpg"); got != want {
+		t.Errorf("resolveTargetPath = %q; want %q", got, want)
+	}
+	if got, _ := resolveTargetPath("{meta.modified.date}", scanned, nil, pathOptions{}); !hasUnpopulatedTokens(got) {
+		t.Errorf("missing modified time was populated: %q", got)
+	}
+	if got, _ := resolveTargetPath("{meta.taken.tz:bogus}", meta, nil, pathOptions{}); !hasUnpopulatedTokens(got) {
+		t.Errorf("unknown tz spec was populated: %q", got)
+	}
+}
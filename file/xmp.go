@@ -0,0 +1,235 @@
+package file
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XMP namespaces holding the properties parseXMP reads.
+const (
+	xmpNS      = "http://ns.adobe.com/xap/1.0/"
+	xmpExifNS  = "http://ns.adobe.com/exif/1.0/"
+	xmpTIFFNS  = "http://ns.adobe.com/tiff/1.0/"
+	xmpPhotoNS = "http://ns.adobe.com/photoshop/1.0/"
+	xmpDCNS    = "http://purl.org/dc/elements/1.1/"
+	xmpRDFNS   = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmpMetaNS  = "adobe:ns:meta/"
+)
+
+// xmpProperty names an XMP property by namespace and local name.
+type xmpProperty struct {
+	space, local string
+}
+
+// The properties filling each metadata field, in order of preference. Dates
+// follow the Metadata Working Group mapping: exif:DateTimeOriginal (or
+// photoshop:DateCreated) is the original time, xmp:CreateDate the digitized
+// time and xmp:ModifyDate the modification time.
+var (
+	xmpOriginal  = []xmpProperty{{xmpExifNS, "DateTimeOriginal"}, {xmpPhotoNS, "DateCreated"}}
+	xmpDigitized = []xmpProperty{{xmpExifNS, "DateTimeDigitized"}, {xmpNS, "CreateDate"}}
+	xmpModified  = []xmpProperty{{xmpTIFFNS, "DateTime"}, {xmpNS, "ModifyDate"}}
+	xmpMake      = []xmpProperty{{xmpTIFFNS, "Make"}}
+	xmpModel     = []xmpProperty{{xmpTIFFNS, "Model"}}
+	xmpRating    = xmpProperty{xmpNS, "Rating"}
+	xmpSubject   = xmpProperty{xmpDCNS, "subject"}
+)
+
+var errNotXMP = errors.New("xmp: no x:xmpmeta or rdf:RDF element")
+
+// parseXMP reads dates, camera make/model, rating and keywords (dc:subject)
+// from an XMP packet. Properties may be written as attributes of
+// rdf:Description or as child elements; both forms are read.
+func parseXMP(data []byte) (*FileMetadata, error) {
+	props, err := xmpProperties(data)
+	if err != nil {
+		return nil, err
+	}
+	lookup := func(names []xmpProperty) string {
+		for _, n := range names {
+			if v := props[n]; len(v) > 0 {
+				return v[0]
+			}
+		}
+		return ""
+	}
+
+	meta := &FileMetadata{
+		CameraMaker: lookup(xmpMake),
+		CameraModel: lookup(xmpModel),
+	}
+	meta.TakenTime, meta.TakenBasis = parseXMPDate(lookup(xmpOriginal))
+	meta.DigitizedTime, meta.DigitizedBasis = parseXMPDate(lookup(xmpDigitized))
+	meta.ModifiedTime, meta.ModifiedBasis = parseXMPDate(lookup(xmpModified))
+	if v := lookup([]xmpProperty{xmpRating}); v != "" {
+		// Some writers emit "3.0".
+		if r, err := strconv.ParseFloat(v, 64); err == nil {
+			meta.Rating = parseRating(r)
+		}
+	}
+	meta.Keywords = props[xmpSubject]
+	return meta, nil
+}
+
+// xmpProperties collects the text values of an XMP packet's properties: one
+// for simple properties, the items for lists (rdf:Bag, rdf:Seq, rdf:Alt).
+func xmpProperties(data []byte) (map[xmpProperty][]string, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	props := make(map[xmpProperty][]string)
+	var open []xml.Name // enclosing elements
+	var text strings.Builder
+	seenRoot := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if (t.Name.Space == xmpRDFNS && t.Name.Local == "RDF") || (t.Name.Space == xmpMetaNS && t.Name.Local == "xmpmeta") {
+				seenRoot = true
+			}
+			if t.Name.Space == xmpRDFNS && t.Name.Local == "Description" {
+				for _, a := range t.Attr {
+					p := xmpProperty{a.Name.Space, a.Name.Local}
+					if _, ok := props[p]; !ok {
+						props[p] = []string{strings.TrimSpace(a.Value)}
+					}
+				}
+			}
+			open = append(open, t.Name)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(open) == 0 {
+				continue
+			}
+			open = open[:len(open)-1]
+			v := strings.TrimSpace(text.String())
+			text.Reset()
+			if v == "" {
+				continue
+			}
+			// Items of rdf:Bag/Seq/Alt lists (rdf:li) belong to the
+			// property enclosing the list.
+			if t.Name.Space == xmpRDFNS && t.Name.Local == "li" && len(open) >= 2 {
+				p := xmpProperty{open[len(open)-2].Space, open[len(open)-2].Local}
+				props[p] = append(props[p], v)
+				continue
+			}
+			if t.Name.Space == xmpRDFNS {
+				continue
+			}
+			p := xmpProperty{t.Name.Space, t.Name.Local}
+			if _, ok := props[p]; !ok {
+				props[p] = []string{v}
+			}
+		}
+	}
+	if !seenRoot {
+		return nil, errNotXMP
+	}
+	return props, nil
+}
+
+// parseRating returns r as a rating (0–5 stars, -1 for rejected), or nil if
+// it's outside that scale. Fractions are truncated.
+func parseRating(r float64) *int {
+	if r < -1 || r > 5 {
+		return nil
+	}
+	rating := int(r)
+	return &rating
+}
+
+// parseXMPDate parses an XMP date (ISO 8601, possibly without seconds, time
+// or offset). Dates with an offset are zoned, others floating.
+func parseXMPDate(s string) (*time.Time, TimeBasis) {
+	if s == "" {
+		return nil, TimeFloating
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999Z07:00", "2006-01-02T15:04Z07:00"} {
+		if tm, err := time.Parse(layout, s); err == nil {
+			return &tm, TimeZoned
+		}
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04", "2006-01-02", "2006:01:02 15:04:05"} {
+		if tm, err := time.Parse(layout, s); err == nil {
+			return &tm, TimeFloating
+		}
+	}
+	return nil, TimeFloating
+}
+
+// XMP modes, the values of ProfileConfig.XMP: how XMP metadata (a sidecar,
+// or the packet embedded in the file) combines with what the file's EXIF and
+// container say.
+const (
+	// XMPFallback (the default) fills only what the file doesn't record.
+	XMPFallback = "fallback"
+	// XMPPrefer lets XMP override the file, for libraries where Lightroom
+	// or darktable hold the authoritative (corrected) dates.
+	XMPPrefer = "prefer"
+	// XMPIgnore disregards XMP altogether.
+	XMPIgnore = "ignore"
+)
+
+// applyXMP merges XMP metadata into meta according to mode. A sidecar, being
+// what editors update, takes precedence over the embedded packet; the
+// embedded packet fills in what the sidecar lacks.
+func applyXMP(meta, sidecar *FileMetadata, mode string) {
+	if mode == XMPIgnore {
+		return
+	}
+	var xmp FileMetadata
+	if sidecar != nil {
+		xmp = *sidecar
+	}
+	if meta.embeddedXMP != nil {
+		fillMissing(&xmp, meta.embeddedXMP)
+	}
+	xmp.attribute("xmp")
+	if mode != XMPPrefer {
+		fillMissing(meta, &xmp)
+		return
+	}
+	xmp.Extension = meta.Extension
+	xmp.embeddedXMP = meta.embeddedXMP
+	fillMissing(&xmp, meta)
+	*meta = xmp
+}
+
+// readXMPSidecar reads the XMP sidecar of an entry, if it has one: either
+// next to it with the extension replaced (IMG_1234.xmp, as Lightroom writes)
+// or appended (IMG_1234.CR3.xmp, as darktable writes). Sidecars are looked up
+// on local sources only.
+func readXMPSidecar(e Entry) *FileMetadata {
+	lp, ok := e.(localPathProvider)
+	if !ok {
+		return nil
+	}
+	p := lp.LocalPath()
+	stem := strings.TrimSuffix(p, filepath.Ext(p))
+	for _, candidate := range []string{stem + ".xmp", stem + ".XMP", p + ".xmp", p + ".XMP"} {
+		data, err := os.ReadFile(candidate)
+		if err != nil {
+			continue
+		}
+		if meta, err := parseXMP(data); err == nil {
+			return meta
+		}
+	}
+	return nil
+}
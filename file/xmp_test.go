@@ -0,0 +1,247 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ffcfg "github.com/dkarlovi/fileferry/config"
+)
+
+const testXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:tiff="http://ns.adobe.com/tiff/1.0/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmp:CreateDate="2024-01-20T08:00:00"
+    tiff:Make="Google">
+   <exif:DateTimeOriginal>2024-01-15T14:30:45.120+01:00</exif:DateTimeOriginal>
+   <xmp:ModifyDate>2024-03-01T09:00</xmp:ModifyDate>
+   <tiff:Model><rdf:Alt><rdf:li xml:lang="x-default">Pixel 8</rdf:li></rdf:Alt></tiff:Model>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func TestParseXMP(t *testing.T) {
+	meta, err := parseXMP([]byte(testXMP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.CameraMaker != "Google" || meta.CameraModel != "Pixel 8" {
+		t.Errorf("camera = %q %q; want Google Pixel 8", meta.CameraMaker, meta.CameraModel)
+	}
+	const layout = "2006-01-02 15:04:05.000 -0700"
+	tests := []struct {
+		name  string
+		tm    *time.Time
+		basis TimeBasis
+		want  string
+		wantB TimeBasis
+	}{
+		{"TakenTime", meta.TakenTime, meta.TakenBasis, "2024-01-15 14:30:45.120 +0100", TimeZoned},
+		{"DigitizedTime", meta.DigitizedTime, meta.DigitizedBasis, "2024-01-20 08:00:00.000 +0000", TimeFloating},
+		{"ModifiedTime", meta.ModifiedTime, meta.ModifiedBasis, "2024-03-01 09:00:00.000 +0000", TimeFloating},
+	}
+	for _, tt := range tests {
+		if tt.tm == nil {
+			t.Errorf("%s not parsed", tt.name)
+			continue
+		}
+		if got := tt.tm.Format(layout); got != tt.want || tt.basis != tt.wantB {
+			t.Errorf("%s = %s (basis %v); want %s (basis %v)", tt.name, got, tt.basis, tt.want, tt.wantB)
+		}
+	}
+
+	if _, err := parseXMP([]byte("<html><body/></html>")); err == nil {
+		t.Error("parseXMP accepted a document without XMP")
+	}
+}
+
+func TestParseXMPRatingAndKeywords(t *testing.T) {
+	packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmp:Rating="4">
+   <dc:subject><rdf:Bag><rdf:li>family</rdf:li><rdf:li>beach</rdf:li></rdf:Bag></dc:subject>
+  </rdf:Description>
+ </rdf:RDF></x:xmpmeta>`
+	meta, err := parseXMP([]byte(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Rating == nil || *meta.Rating != 4 {
+		t.Errorf("Rating = %v; want 4", meta.Rating)
+	}
+	if len(meta.Keywords) != 2 || meta.Keywords[0] != "family" || meta.Keywords[1] != "beach" {
+		t.Errorf("Keywords = %q; want [family beach]", meta.Keywords)
+	}
+}
+
+func TestApplyXMP(t *testing.T) {
+	exifTime := time.Date(2024, 1, 15, 14, 30, 45, 0, time.UTC)
+	xmpTime := time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC)
+	embeddedTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rating := 5
+
+	tests := []struct {
+		mode      string
+		wantTaken time.Time
+		wantModel string
+		wantRated bool
+	}{
+		{XMPFallback, exifTime, "EXIF model", true},
+		{"", exifTime, "EXIF model", true},
+		{XMPPrefer, xmpTime, "XMP model", true},
+		{XMPIgnore, exifTime, "EXIF model", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			meta := &FileMetadata{
+				TakenTime:   &exifTime,
+				CameraModel: "EXIF model",
+				Extension:   "jpg",
+				embeddedXMP: &FileMetadata{TakenTime: &embeddedTime, CameraMaker: "Embedded maker"},
+			}
+			sidecar := &FileMetadata{TakenTime: &xmpTime, CameraModel: "XMP model", Rating: &rating}
+			applyXMP(meta, sidecar, tt.mode)
+			if !meta.TakenTime.Equal(tt.wantTaken) {
+				t.Errorf("TakenTime = %v; want %v", meta.TakenTime, tt.wantTaken)
+			}
+			if meta.CameraModel != tt.wantModel {
+				t.Errorf("CameraModel = %q; want %q", meta.CameraModel, tt.wantModel)
+			}
+			if (meta.Rating != nil) != tt.wantRated {
+				t.Errorf("Rating = %v; want rated %v", meta.Rating, tt.wantRated)
+			}
+			if tt.mode != XMPIgnore && meta.CameraMaker != "Embedded maker" {
+				t.Errorf("CameraMaker = %q; want the embedded packet's", meta.CameraMaker)
+			}
+			if meta.Extension != "jpg" {
+				t.Errorf("Extension = %q; want jpg", meta.Extension)
+			}
+		})
+	}
+}
+
+func TestReadXMPSidecar(t *testing.T) {
+	sidecar := strings.Replace(testXMP, "Pixel 8", "Sidecar", 1)
+	for _, name := range []string{"IMG_1.xmp", "IMG_1.JPG.xmp"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			img := filepath.Join(dir, "IMG_1.JPG")
+			mustWrite(t, img, "not really a jpeg")
+			mustWrite(t, filepath.Join(dir, name), sidecar)
+			fi, err := os.Stat(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta := readXMPSidecar(&localEntry{path: img, info: fi})
+			if meta == nil || meta.CameraModel != "Sidecar" {
+				t.Fatalf("readXMPSidecar = %+v; want the sidecar's metadata", meta)
+			}
+		})
+	}
+	if meta := readXMPSidecar(&fakeEntry{name: "IMG_1.JPG"}); meta != nil {
+		t.Errorf("readXMPSidecar on a streamed entry = %+v; want nil", meta)
+	}
+}
+
+func TestExtractImageMetadataEmbeddedXMP(t *testing.T) {
+	tiff := buildTIFF([]tiffField{asciiField(0x010f, "Acme")}, []tiffField{asciiField(0x9003, "2024:01:15 14:30:45")})
+	xmpSeg := append([]byte(jpegXMPHeader), testXMP...)
+	exifJPEG := jpegWithExif(tiff)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(len(xmpSeg)+2))
+	// The XMP segment follows the EXIF one; a scan (SOS) ends the header.
+	jpeg := bytes.Join([][]byte{exifJPEG[:len(exifJPEG)-2], app1, xmpSeg, {0xff, 0xda, 0, 2}, {0xff, 0xd9}}, nil)
+
+	xmpTag := tiffField{tag: 0x02bc, typ: 1, count: uint32(len(testXMP)), data: []byte(testXMP)}
+	dng := buildTIFF([]tiffField{asciiField(0x010f, "Acme"), xmpTag}, nil)
+
+	for name, data := range map[string][]byte{"IMG_1.jpg": jpeg, "IMG_1.dng": dng} {
+		t.Run(name, func(t *testing.T) {
+			meta, err := extractImageMetadataFromEntry(&fakeEntry{name: name, bodies: [][]byte{data}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.CameraMaker != "Acme" {
+				t.Errorf("CameraMaker = %q; want Acme from EXIF", meta.CameraMaker)
+			}
+			if meta.embeddedXMP == nil || meta.embeddedXMP.CameraModel != "Pixel 8" {
+				t.Errorf("embedded XMP = %+v; want the packet's metadata", meta.embeddedXMP)
+			}
+		})
+	}
+}
+
+func TestProcessFileXMPSidecar(t *testing.T) {
+	dir := t.TempDir()
+	img := filepath.Join(dir, "IMG_1.jpg")
+	tiff := buildTIFF(nil, []tiffField{asciiField(0x9003, "2024:06:01 12:00:00")})
+	mustWrite(t, img, string(jpegWithExif(tiff)))
+	mustWrite(t, filepath.Join(dir, "IMG_1.xmp"), testXMP) // DateTimeOriginal 2024-01-15, Rating absent
+	fi, err := os.Stat(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for mode, want := range map[string]string{
+		XMPFallback: "2024-06-01_Pixel 8.jpg",
+		XMPPrefer:   "2024-01-15_Pixel 8.jpg",
+	} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := &ffcfg.Config{Profiles: map[string]ffcfg.ProfileConfig{
+				"Pictures": {
+					XMP:    mode,
+					Target: ffcfg.TargetPathConfig{Path: "/out/{meta.taken.date}_{meta.camera.model}.{file.extension}"},
+				},
+			}}
+			result := processFile(&localEntry{path: img, info: fi}, ffcfg.SourceConfig{}, "Pictures", cfg, DefaultExtractors, nil)
+			if result.Error != nil {
+				t.Fatalf("unexpected error: %v", result.Error)
+			}
+			if got := filepath.Base(result.NewPath); got != want {
+				t.Errorf("NewPath = %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProcessFileXMPPreferOverFilename(t *testing.T) {
+	// The filename alone fills the template, but with xmp: prefer the
+	// sidecar's date still wins.
+	dir := t.TempDir()
+	img := filepath.Join(dir, "2024-06-01.jpg")
+	mustWrite(t, img, "not really a JPEG")
+	mustWrite(t, filepath.Join(dir, "2024-06-01.xmp"), testXMP) // DateTimeOriginal 2024-01-15
+	fi, err := os.Stat(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for mode, want := range map[string]string{
+		XMPFallback: "2024-06-01.jpg",
+		XMPPrefer:   "2024-01-15.jpg",
+	} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := &ffcfg.Config{Profiles: map[string]ffcfg.ProfileConfig{
+				"Pictures": {
+					XMP:      mode,
+					Patterns: []string{"{meta.taken.date}.jpg"},
+					Target:   ffcfg.TargetPathConfig{Path: "/out/{meta.taken.date}.{file.extension}"},
+				},
+			}}
+			result := processFile(&localEntry{path: img, info: fi}, ffcfg.SourceConfig{}, "Pictures", cfg, DefaultExtractors, nil)
+			if result.Error != nil {
+				t.Fatalf("unexpected error: %v", result.Error)
+			}
+			if got := filepath.Base(result.NewPath); got != want {
+				t.Errorf("NewPath = %q; want %q", got, want)
+			}
+		})
+	}
+}
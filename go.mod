@@ -16,6 +16,7 @@ require (
 	github.com/go-ole/go-ole v1.3.0
 	github.com/remko/go-mkvparse v0.14.0
 	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+	golang.org/x/sys v0.35.0
 )
 
 require (
@@ -29,6 +30,5 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/posener/complete v1.2.3 // indirect
 	github.com/rs/zerolog v1.34.0 // indirect
-	golang.org/x/sys v0.35.0 // indirect
 	golang.org/x/term v0.34.0 // indirect
 )
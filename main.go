@@ -2,6 +2,9 @@ package main
 
 import (
 	"os"
+	// Embed the IANA timezone database so profile timezones also resolve on
+	// systems without one (notably Windows).
+	_ "time/tzdata"
 
 	"github.com/dkarlovi/fileferry/commands"
 	"github.com/symfony-cli/console"